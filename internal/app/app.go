@@ -5,6 +5,7 @@ import (
 	"time"
 
 	grpcsubpub "github.com/Kry0z1/subpub/internal/app/grpc"
+	"github.com/Kry0z1/subpub/internal/config"
 	"github.com/Kry0z1/subpub/internal/service"
 )
 
@@ -16,10 +17,15 @@ func New(
 	log *slog.Logger,
 	grpcPort int,
 	timeout time.Duration,
+	maxSnapshotSize int,
+	subpubCfg config.SubPubConfig,
 ) *App {
-	srvc := service.New(log)
+	srvc, err := service.New(log, subpubCfg)
+	if err != nil {
+		panic("couldn't create service: " + err.Error())
+	}
 
-	grpcApp := grpcsubpub.New(&srvc, log, grpcPort, timeout)
+	grpcApp := grpcsubpub.New(&srvc, log, grpcPort, timeout, maxSnapshotSize)
 
 	return &App{
 		GRPCServer: grpcApp,
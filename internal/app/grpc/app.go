@@ -35,6 +35,7 @@ func New(
 	log *slog.Logger,
 	port int,
 	timeout time.Duration,
+	maxSnapshotSize int,
 ) *App {
 	loggingOpts := []logging.Option{
 		logging.WithLogOnEvents(
@@ -49,14 +50,14 @@ func New(
 		}),
 	}
 
-	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	gRPCServer := grpc.NewServer(subpubServer.StatsHandler(), grpc.ChainUnaryInterceptor(
 		recovery.UnaryServerInterceptor(recoveryOpts...),
 		logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...),
 		InterceptorTimeout(timeout),
 	))
 
 	cctx, cancel := context.WithCancel(context.Background())
-	subpubServer.Register(gRPCServer, subpubService, cctx)
+	subpubServer.Register(gRPCServer, subpubService, subpubService, maxSnapshotSize, cctx)
 
 	return &App{
 		log:        log,
@@ -118,11 +119,24 @@ func (a *App) Stop(timeout time.Duration) {
 	}
 
 	log.Info("stopping subpub system")
-	err := a.subpub.Stop(ctx)
+	report, err := a.subpub.Stop(ctx)
 
 	if err != nil {
-		log.Info("couldn't gracefully stop subpub system")
-		log.Info("killing subpub system forcibly")
+		log.Warn("couldn't gracefully stop subpub system, killed it forcibly",
+			slog.String("error", err.Error()),
+			slog.Int64("dropped_messages", report.Undelivered()),
+			slog.Int("detached_handlers", report.RunningHandlers()),
+		)
+		for _, subject := range report.Subjects {
+			for _, sub := range subject.Subscriptions {
+				log.Warn("subscription abandoned",
+					slog.String("subject", subject.Subject),
+					slog.Int64("subscription_id", sub.ID),
+					slog.Int64("dropped_messages", sub.Undelivered),
+					slog.Bool("handler_detached", sub.HandlerRunning),
+				)
+			}
+		}
 	}
 
 	log.Info("subpub system stopped")
@@ -0,0 +1,144 @@
+// Package cli implements admin commands run against running server.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	pubsubv1 "github.com/Kry0z1/subpub/protos/gen/go/pubsub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultAddr    = "localhost:15000"
+	defaultTimeout = time.Minute
+
+	// chunkSize is size of chunks snapshot file is sent by.
+	chunkSize = 1 << 20
+)
+
+var ErrUsage = errors.New("usage: subpub snapshot|restore [-addr host:port] [-timeout duration] <file>")
+
+// IsCommand reports whether args start with command of cli.
+func IsCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "snapshot" || args[0] == "restore")
+}
+
+// Run runs command given by args:
+//
+//	snapshot <file>  saves snapshot of server to file
+//	restore <file>   loads snapshot file into server
+func Run(args []string, out io.Writer) error {
+	if !IsCommand(args) {
+		return ErrUsage
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(out)
+	addr := flags.String("addr", defaultAddr, "address of server")
+	timeout := flags.Duration("timeout", defaultTimeout, "timeout of command")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return ErrUsage
+	}
+	path := flags.Arg(0)
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("couldn't connect to %s: %w", *addr, err)
+	}
+	defer conn.Close()
+	admin := pubsubv1.NewAdminClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if args[0] == "snapshot" {
+		size, err := snapshot(ctx, admin, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "snapshot of %d bytes saved to %s\n", size, path)
+		return nil
+	}
+
+	response, err := restore(ctx, admin, path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored %d subjects, %d messages, %d groups, %d scheduled messages\n",
+		response.GetSubjects(), response.GetMessages(), response.GetGroups(), response.GetScheduled())
+	return nil
+}
+
+// snapshot saves snapshot to path, replacing file only once
+// the whole snapshot is received.
+func snapshot(ctx context.Context, admin pubsubv1.AdminClient, path string) (int, error) {
+	stream, err := admin.Snapshot(ctx, &pubsubv1.SnapshotRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	var data []byte
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("snapshot failed: %w", err)
+		}
+		data = append(data, chunk.GetData()...)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return len(data), os.Rename(tmp.Name(), path)
+}
+
+func restore(ctx context.Context, admin pubsubv1.AdminClient, path string) (*pubsubv1.RestoreResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := admin.Restore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+	for len(data) > 0 {
+		n := min(len(data), chunkSize)
+		if err := stream.Send(&pubsubv1.SnapshotChunk{Data: data[:n]}); err != nil {
+			// actual error is returned by CloseAndRecv
+			break
+		}
+		data = data[n:]
+	}
+
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+	return response, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -13,11 +14,124 @@ type Config struct {
 	Env         string        `yaml:"env" env-default:"local"`
 	StopTimeout time.Duration `yaml:"stop_timeout" env-default:"10s"`
 	GRPC        GRPCConfig    `yaml:"grpc" env-required:"true"`
+	SubPub      SubPubConfig  `yaml:"subpub"`
 }
 
 type GRPCConfig struct {
 	Port    int           `yaml:"port" env-required:"true"`
 	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+	// MaxSnapshotSize is max size in bytes of snapshot
+	// accepted by Admin.Restore, 256 MiB by default.
+	MaxSnapshotSize int `yaml:"max_snapshot_size" env-default:"268435456"`
+}
+
+type SubPubConfig struct {
+	// DeadLetter is key skipped messages are published to,
+	// empty disables dead letters.
+	DeadLetter string `yaml:"dead_letter"`
+	// TTL of messages by key, if publisher hasn't set one.
+	KeyTTL      map[string]time.Duration `yaml:"key_ttl"`
+	DedupWindow time.Duration            `yaml:"dedup_window" env-default:"2m"`
+	// QueueLimit limits messages waiting for delivery to every
+	// subscriber, 0 means no limit. Requests may set their own.
+	QueueLimit int `yaml:"queue_limit"`
+	// QueueOverflow is one of OverflowDropNewest, OverflowDropOldest.
+	QueueOverflow string             `yaml:"queue_overflow" env-default:"drop_oldest"`
+	SlowConsumer  SlowConsumerConfig `yaml:"slow_consumer"`
+	// Tracing creates spans for messages with global
+	// OpenTelemetry tracer provider.
+	Tracing bool `yaml:"tracing"`
+	// Schemas are registered at startup,
+	// later they can be changed by admin RPC.
+	Schemas []SchemaConfig `yaml:"schemas"`
+	// Transforms derive keys from others inside of server.
+	Transforms []TransformConfig `yaml:"transforms"`
+	// Mappings rename keys of published messages,
+	// later they can be changed by admin RPC.
+	Mappings []MappingConfig `yaml:"mappings"`
+	// Mirrors copy messages of keys to other keys.
+	Mirrors []MirrorConfig `yaml:"mirrors"`
+	// Partitions split keys into partitions for consumer groups.
+	Partitions map[string]PartitionConfig `yaml:"partitions"`
+}
+
+// PartitionConfig is subpub.PartitionPolicy of key.
+type PartitionConfig struct {
+	Partitions int `yaml:"partitions"`
+	// Retention is max messages kept in partition for groups,
+	// 0 means subpub.DefaultRetention.
+	Retention int `yaml:"retention"`
+}
+
+// MappingConfig moves messages of keys matching Source to one of
+// Destinations, see subpub.MapRule.
+type MappingConfig struct {
+	Source       string              `yaml:"source"`
+	Destinations []DestinationConfig `yaml:"destinations"`
+}
+
+// DestinationConfig is key of mapping with its share of messages.
+// Key may refer to wildcards of source as $1, $2 and so on.
+type DestinationConfig struct {
+	Key    string `yaml:"key"`
+	Weight int    `yaml:"weight"`
+}
+
+// MirrorConfig copies messages of keys matching Source
+// to Destination, which may refer to wildcards as well.
+type MirrorConfig struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
+
+// TransformConfig is rule republishing messages of keys matching
+// Source to Destination. Data is JSON, or plain string otherwise.
+type TransformConfig struct {
+	// Name is unique name of rule, e.g. for metrics.
+	Name   string `yaml:"name"`
+	Source string `yaml:"source"`
+	// Filter is jq expression, message is dropped
+	// if its result is false or null.
+	Filter string `yaml:"filter"`
+	// Project keeps only listed fields of JSON object,
+	// nested fields are separated by dots.
+	Project []string `yaml:"project"`
+	// Template rewrites data with Go template,
+	// can't be used together with Project.
+	Template string `yaml:"template"`
+	// Destination is Go template of destination key.
+	Destination string `yaml:"destination"`
+}
+
+// SchemaConfig sets schema of data for keys matching pattern,
+// either JSON Schema or protobuf message.
+type SchemaConfig struct {
+	Pattern string `yaml:"pattern"`
+	// JSONSchema is path to JSON Schema document.
+	JSONSchema string `yaml:"json_schema"`
+	// ProtoDescriptor is path to FileDescriptorSet
+	// (protoc --include_imports --descriptor_set_out),
+	// ProtoMessage is full name of message in it.
+	ProtoDescriptor string `yaml:"proto_descriptor"`
+	ProtoMessage    string `yaml:"proto_message"`
+}
+
+const (
+	OverflowDropNewest = "drop_newest"
+	OverflowDropOldest = "drop_oldest"
+)
+
+// SlowConsumerConfig enables watchdog if any threshold is set.
+type SlowConsumerConfig struct {
+	MaxQueued      int64         `yaml:"max_queued"`
+	MaxQueueAge    time.Duration `yaml:"max_queue_age"`
+	MaxHandlerTime time.Duration `yaml:"max_handler_time"`
+	Evict          bool          `yaml:"evict"`
+	Interval       time.Duration `yaml:"interval" env-default:"1s"`
+}
+
+func (c SlowConsumerConfig) Enabled() bool {
+	return c.MaxQueued > 0 || c.MaxQueueAge > 0 || c.MaxHandlerTime > 0
 }
 
 func MustLoad() *Config {
@@ -36,9 +150,29 @@ func MustLoadPath(path string) *Config {
 		panic("couldn't read config: " + err.Error())
 	}
 
+	// files of schemas are relative to config
+	for i, schema := range cfg.SubPub.Schemas {
+		cfg.SubPub.Schemas[i].JSONSchema = relativeTo(path, schema.JSONSchema)
+		cfg.SubPub.Schemas[i].ProtoDescriptor = relativeTo(path, schema.ProtoDescriptor)
+	}
+
+	switch cfg.SubPub.QueueOverflow {
+	case OverflowDropNewest, OverflowDropOldest:
+	default:
+		panic("unknown subpub.queue_overflow: " + cfg.SubPub.QueueOverflow)
+	}
+
 	return &cfg
 }
 
+// relativeTo resolves relative file path against directory of config.
+func relativeTo(configPath string, file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(configPath), file)
+}
+
 // Gets config path in this priority:
 // param > env > default
 //
@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"sync"
+
+	"github.com/Kry0z1/subpub/pkg/subpub"
+)
+
+// acks are messages sent to client of stream in ack mode,
+// by their ack IDs.
+type acks struct {
+	mut  sync.Mutex
+	next uint64
+	msgs map[uint64]subpub.Message
+	// prune is size of msgs which triggers pruning.
+	prune int
+}
+
+// minPrune is size of msgs below which they are never pruned.
+const minPrune = 64
+
+// add remembers msg to be acked by client, returning its ack ID.
+//
+// Messages redelivered by system can't be acked by previous
+// ack IDs, so those are pruned once there are many of them.
+func (a *acks) add(msg subpub.Message) uint64 {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if len(a.msgs) >= a.prune {
+		for id, msg := range a.msgs {
+			if !msg.AwaitsAck() {
+				delete(a.msgs, id)
+			}
+		}
+		a.prune = max(2*len(a.msgs), minPrune)
+	}
+
+	a.next++
+	a.msgs[a.next] = msg
+	return a.next
+}
+
+// ack acks messages by their ack IDs, skipping unknown ones.
+func (a *acks) ack(ids []uint64) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	for _, id := range ids {
+		if msg, ok := a.msgs[id]; ok {
+			msg.Ack()
+			delete(a.msgs, id)
+		}
+	}
+}
+
+func newAcks() *acks {
+	return &acks{msgs: make(map[uint64]subpub.Message), prune: minPrune}
+}
@@ -0,0 +1,230 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/Kry0z1/subpub/internal/service"
+	"github.com/Kry0z1/subpub/internal/snapshot"
+	"github.com/Kry0z1/subpub/internal/transform"
+	"github.com/Kry0z1/subpub/pkg/subpub"
+	pubsubv1 "github.com/Kry0z1/subpub/protos/gen/go/pubsub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Admin interface {
+	RegisterSchema(spec service.SchemaSpec) error
+	DeleteSchema(pattern string) bool
+	ListSchemas() []service.SchemaSpec
+	TransformStats() []transform.Stats
+	SubjectMapping() subpub.MappingRules
+	SetSubjectMapping(rules subpub.MappingRules) error
+	Groups() []subpub.GroupStats
+	Snapshot() ([]byte, error)
+	Restore(data []byte) (subpub.Snapshot, error)
+}
+
+type AdminServer struct {
+	pubsubv1.UnimplementedAdminServer
+	admin Admin
+
+	// maxSnapshotSize limits data received by Restore.
+	maxSnapshotSize int
+}
+
+func (s AdminServer) RegisterSchema(ctx context.Context, request *pubsubv1.RegisterSchemaRequest) (*pubsubv1.RegisterSchemaResponse, error) {
+	schema := request.GetSchema()
+	spec := service.SchemaSpec{
+		Pattern:            schema.GetPattern(),
+		JSONSchema:         schema.GetJsonSchema(),
+		ProtoDescriptorSet: schema.GetProto().GetDescriptorSet(),
+		ProtoMessage:       schema.GetProto().GetMessage(),
+	}
+
+	err := s.admin.RegisterSchema(spec)
+	if errors.Is(err, service.ErrInvalidSchema) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "couldn't register schema")
+	}
+
+	return &pubsubv1.RegisterSchemaResponse{}, nil
+}
+
+func (s AdminServer) DeleteSchema(ctx context.Context, request *pubsubv1.DeleteSchemaRequest) (*pubsubv1.DeleteSchemaResponse, error) {
+	if !s.admin.DeleteSchema(request.GetPattern()) {
+		return nil, status.Error(codes.NotFound, "no schema for pattern")
+	}
+
+	return &pubsubv1.DeleteSchemaResponse{}, nil
+}
+
+func (s AdminServer) ListSchemas(ctx context.Context, request *pubsubv1.ListSchemasRequest) (*pubsubv1.ListSchemasResponse, error) {
+	specs := s.admin.ListSchemas()
+
+	schemas := make([]*pubsubv1.Schema, 0, len(specs))
+	for _, spec := range specs {
+		schema := &pubsubv1.Schema{Pattern: spec.Pattern}
+		if spec.ProtoMessage != "" {
+			schema.Definition = &pubsubv1.Schema_Proto{Proto: &pubsubv1.ProtoSchema{
+				DescriptorSet: spec.ProtoDescriptorSet,
+				Message:       spec.ProtoMessage,
+			}}
+		} else {
+			schema.Definition = &pubsubv1.Schema_JsonSchema{JsonSchema: spec.JSONSchema}
+		}
+		schemas = append(schemas, schema)
+	}
+
+	return &pubsubv1.ListSchemasResponse{Schemas: schemas}, nil
+}
+
+func (s AdminServer) ListTransforms(ctx context.Context, request *pubsubv1.ListTransformsRequest) (*pubsubv1.ListTransformsResponse, error) {
+	stats := s.admin.TransformStats()
+
+	transforms := make([]*pubsubv1.TransformStats, 0, len(stats))
+	for _, st := range stats {
+		transforms = append(transforms, &pubsubv1.TransformStats{
+			Name:      st.Name,
+			Received:  st.Received,
+			Filtered:  st.Filtered,
+			Published: st.Published,
+			Failed:    st.Failed,
+			Loops:     st.Loops,
+		})
+	}
+
+	return &pubsubv1.ListTransformsResponse{Transforms: transforms}, nil
+}
+
+func (s AdminServer) GetSubjectMapping(ctx context.Context, request *pubsubv1.GetSubjectMappingRequest) (*pubsubv1.GetSubjectMappingResponse, error) {
+	rules := s.admin.SubjectMapping()
+
+	mapping := &pubsubv1.SubjectMapping{}
+	for _, rule := range rules.Maps {
+		mapRule := &pubsubv1.MapRule{Source: rule.Source}
+		for _, dest := range rule.Destinations {
+			mapRule.Destinations = append(mapRule.Destinations, &pubsubv1.WeightedKey{Key: dest.Subject, Weight: uint32(dest.Weight)})
+		}
+		mapping.Maps = append(mapping.Maps, mapRule)
+	}
+	for _, rule := range rules.Mirrors {
+		mapping.Mirrors = append(mapping.Mirrors, &pubsubv1.MirrorRule{Source: rule.Source, Destination: rule.Destination})
+	}
+
+	return &pubsubv1.GetSubjectMappingResponse{Mapping: mapping}, nil
+}
+
+func (s AdminServer) SetSubjectMapping(ctx context.Context, request *pubsubv1.SetSubjectMappingRequest) (*pubsubv1.SetSubjectMappingResponse, error) {
+	var rules subpub.MappingRules
+	for _, mapRule := range request.GetMapping().GetMaps() {
+		rule := subpub.MapRule{Source: mapRule.GetSource()}
+		for _, dest := range mapRule.GetDestinations() {
+			rule.Destinations = append(rule.Destinations, subpub.WeightedSubject{Subject: dest.GetKey(), Weight: int(dest.GetWeight())})
+		}
+		rules.Maps = append(rules.Maps, rule)
+	}
+	for _, rule := range request.GetMapping().GetMirrors() {
+		rules.Mirrors = append(rules.Mirrors, subpub.MirrorRule{Source: rule.GetSource(), Destination: rule.GetDestination()})
+	}
+
+	err := s.admin.SetSubjectMapping(rules)
+	if errors.Is(err, subpub.ErrInvalidMapping) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "couldn't set mapping")
+	}
+
+	return &pubsubv1.SetSubjectMappingResponse{}, nil
+}
+
+func (s AdminServer) ListGroups(ctx context.Context, request *pubsubv1.ListGroupsRequest) (*pubsubv1.ListGroupsResponse, error) {
+	stats := s.admin.Groups()
+
+	groups := make([]*pubsubv1.ConsumerGroup, 0, len(stats))
+	for _, st := range stats {
+		group := &pubsubv1.ConsumerGroup{
+			Key:     st.Subject,
+			Group:   st.Group,
+			Members: uint32(len(st.Members)),
+		}
+		for _, p := range st.Partitions {
+			group.Partitions = append(group.Partitions, &pubsubv1.PartitionOffset{
+				Offset:  p.Offset,
+				End:     p.End,
+				Expired: p.Expired,
+				Dropped: p.Dropped,
+			})
+		}
+		groups = append(groups, group)
+	}
+
+	return &pubsubv1.ListGroupsResponse{Groups: groups}, nil
+}
+
+// snapshotChunk is size of chunks snapshot file is streamed by,
+// well below default max size of gRPC message.
+const snapshotChunk = 1 << 20
+
+func (s AdminServer) Snapshot(request *pubsubv1.SnapshotRequest, g grpc.ServerStreamingServer[pubsubv1.SnapshotChunk]) error {
+	data, err := s.admin.Snapshot()
+	if err != nil {
+		return status.Error(codes.Internal, "couldn't take snapshot")
+	}
+
+	for len(data) > 0 {
+		n := min(len(data), snapshotChunk)
+		if err := g.Send(&pubsubv1.SnapshotChunk{Data: data[:n]}); err != nil {
+			return status.Error(codes.Aborted, "stream has broken")
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (s AdminServer) Restore(g grpc.ClientStreamingServer[pubsubv1.SnapshotChunk, pubsubv1.RestoreResponse]) error {
+	var data []byte
+	for {
+		chunk, err := g.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return status.Error(codes.Aborted, "stream has broken")
+		}
+		if len(data)+len(chunk.GetData()) > s.maxSnapshotSize {
+			return status.Errorf(codes.ResourceExhausted, "snapshot is larger than %d bytes", s.maxSnapshotSize)
+		}
+		data = append(data, chunk.GetData()...)
+	}
+
+	state, err := s.admin.Restore(data)
+	switch {
+	case errors.Is(err, snapshot.ErrChecksum):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, snapshot.ErrFormat), errors.Is(err, snapshot.ErrVersion):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, subpub.ErrInvalidSnapshot):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return status.Error(codes.Internal, "restore failed")
+	}
+
+	response := &pubsubv1.RestoreResponse{
+		Subjects:  uint32(len(state.Subjects)),
+		Scheduled: uint32(len(state.Scheduled)),
+	}
+	for _, topic := range state.Topics {
+		for _, p := range topic.Partitions {
+			response.Messages += uint32(len(p.Messages))
+		}
+		response.Groups += uint32(len(topic.Groups))
+	}
+	return g.SendAndClose(response)
+}
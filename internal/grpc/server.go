@@ -2,18 +2,38 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/Kry0z1/subpub/internal/service"
+	"github.com/Kry0z1/subpub/pkg/subpub"
 	pubsubv1 "github.com/Kry0z1/subpub/protos/gen/go/pubsub"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// propagator carries trace context both in gRPC metadata
+// and in headers of messages.
+var propagator = propagation.TraceContext{}
+
 type SubPub interface {
-	Subscribe(key string) (chan string, error)
-	Publish(ctx context.Context, key string, data string) error
+	Subscribe(key string, opts ...subpub.SubscribeOption) (<-chan subpub.Message, subpub.Subscription, error)
+	SubscribeGroup(key string, group string) (<-chan subpub.GroupMessage, subpub.Subscription, error)
+	Publish(ctx context.Context, key string, data string, opts ...subpub.PublishOption) error
+	PublishAndWait(ctx context.Context, key string, data string, opts ...subpub.PublishOption) (int, error)
+	PublishAt(key string, data string, at time.Time, opts ...subpub.PublishOption) (uint64, error)
+	PublishTx(ctx context.Context, messages []service.TxMessage) error
 }
 
 type SubPubServer struct {
@@ -22,40 +42,351 @@ type SubPubServer struct {
 	cancelCtx context.Context
 }
 
+// source is open subscription, exactly one of channels is set.
+type source struct {
+	sub       subpub.Subscription
+	msgs      <-chan subpub.Message
+	groupMsgs <-chan subpub.GroupMessage
+
+	// acks are nil unless subscription is in ack mode.
+	acks *acks
+}
+
 func (s SubPubServer) Subscribe(request *pubsubv1.SubscribeRequest, g grpc.ServerStreamingServer[pubsubv1.Event]) error {
-	pipe, err := s.subpub.Subscribe(request.GetKey())
+	// there is no way to send ack frames
+	if request.GetAckWait() != nil {
+		return status.Error(codes.InvalidArgument, "ack_wait requires SubscribeStream")
+	}
+
+	src, err := s.subscribe(request)
+	if err != nil {
+		return err
+	}
+	defer src.sub.Unsubscribe()
+
+	// headers tell client that subscription is in place,
+	// so it may wait for them before publishing
+	if err := g.SendHeader(metadata.MD{}); err != nil {
+		return status.Error(codes.Aborted, "stream has broken")
+	}
+
+	return s.stream(g, src, nil)
+}
+
+func (s SubPubServer) SubscribeStream(g grpc.BidiStreamingServer[pubsubv1.SubscribeFrame, pubsubv1.Event]) error {
+	frame, err := g.Recv()
+	if err != nil {
+		return status.Error(codes.Aborted, "stream has broken")
+	}
+	if frame.GetSubscribe() == nil {
+		return status.Error(codes.InvalidArgument, "first frame must be subscribe")
+	}
+
+	src, err := s.subscribe(frame.GetSubscribe())
+	if err != nil {
+		return err
+	}
+	defer src.sub.Unsubscribe()
+
+	if err := g.SendHeader(metadata.MD{}); err != nil {
+		return status.Error(codes.Aborted, "stream has broken")
+	}
+
+	controlErr := make(chan error, 1)
+	go func() {
+		controlErr <- control(g, src)
+	}()
+
+	return s.stream(g, src, controlErr)
+}
+
+// control applies pause, resume and ack frames to subscription
+// until client stops sending them.
+func control(g grpc.BidiStreamingServer[pubsubv1.SubscribeFrame, pubsubv1.Event], src source) error {
+	for {
+		frame, err := g.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Aborted, "stream has broken")
+		}
+
+		switch {
+		case frame.GetPause() != nil:
+			src.sub.Pause()
+		case frame.GetResume() != nil:
+			src.sub.Resume()
+		case frame.GetAck() != nil:
+			if src.acks == nil {
+				return status.Error(codes.FailedPrecondition, "subscription is not in ack mode")
+			}
+			src.acks.ack(frame.GetAck().GetAckIds())
+		default:
+			return status.Error(codes.InvalidArgument, "already subscribed")
+		}
+	}
+}
+
+func (s SubPubServer) subscribe(request *pubsubv1.SubscribeRequest) (source, error) {
+	if request.GetAckWait() != nil {
+		if err := request.GetAckWait().CheckValid(); err != nil || request.GetAckWait().AsDuration() <= 0 {
+			return source{}, status.Error(codes.InvalidArgument, "ack_wait must be positive")
+		}
+		if request.GetGroup() != "" {
+			return source{}, status.Error(codes.InvalidArgument, "ack_wait can't be used with group")
+		}
+	}
+
+	if request.GetGroup() != "" {
+		msgs, sub, err := s.subpub.SubscribeGroup(request.GetKey(), request.GetGroup())
+		if errors.Is(err, subpub.ErrNotPartitioned) {
+			return source{}, status.Error(codes.FailedPrecondition, "key is not partitioned")
+		}
+		if err != nil {
+			return source{}, status.Error(codes.Internal, "couldn't subscribe")
+		}
+		return source{sub: sub, groupMsgs: msgs}, nil
+	}
+
+	// unbuffered channel keeps messages in subscription queue,
+	// so that paused stream doesn't send what's already buffered
+	opts := []subpub.SubscribeOption{subpub.WithChanBuffer(0, subpub.OverflowBlock)}
+	if request.GetMaxQueued() > 0 {
+		opts = append(opts, subpub.WithQueueLimit(int(request.GetMaxQueued()), subpub.OverflowDropOldest))
+	}
+	if request.GetAckWait() != nil {
+		opts = append(opts, subpub.WithAck(request.GetAckWait().AsDuration(), int(request.GetMaxDeliver())))
+	}
+
+	msgs, sub, err := s.subpub.Subscribe(request.GetKey(), opts...)
 	if err != nil {
-		return status.Error(codes.Internal, "couldn't subscribe")
+		return source{}, status.Error(codes.Internal, "couldn't subscribe")
+	}
+	src := source{sub: sub, msgs: msgs}
+	if request.GetAckWait() != nil {
+		src.acks = newAcks()
 	}
+	return src, nil
+}
 
+// stream sends messages to client until either side is done.
+//
+// Subscriber evicted as slow consumer gets ResourceExhausted.
+//
+// controlErr is nil for streams without control frames,
+// otherwise once nil is received from it, client has
+// stopped sending frames, but still receives messages.
+func (s SubPubServer) stream(g grpc.ServerStream, src source, controlErr <-chan error) error {
 	for {
+		var event *pubsubv1.Event
 		select {
-		case msg := <-pipe:
-			if pipe == nil {
-				return nil
+		case msg, ok := <-src.msgs:
+			if !ok {
+				return closed(src.sub)
 			}
-			if err := g.Send(&pubsubv1.Event{Data: msg}); err != nil {
-				return status.Error(codes.Aborted, "stream has broken")
+			event = &pubsubv1.Event{
+				Data:         eventData(msg.Data),
+				TraceContext: traceContext(msg),
+			}
+			if src.acks != nil {
+				event.AckId = src.acks.add(msg)
+				event.Delivery = uint32(msg.Delivery)
 			}
+		case msg, ok := <-src.groupMsgs:
+			if !ok {
+				return closed(src.sub)
+			}
+			event = &pubsubv1.Event{
+				Data:         eventData(msg.Data),
+				TraceContext: traceContext(msg.Message),
+				Partition:    uint32(msg.Partition),
+				Offset:       msg.Offset,
+			}
+		case err := <-controlErr:
+			if err != nil {
+				return err
+			}
+			controlErr = nil
+		case <-g.Context().Done():
+			return nil
 		case <-s.cancelCtx.Done():
 			return status.Error(codes.Aborted, "server died")
 		}
+
+		if event != nil {
+			if err := g.SendMsg(event); err != nil {
+				return status.Error(codes.Aborted, "stream has broken")
+			}
+		}
 	}
 }
 
-func (s SubPubServer) Publish(ctx context.Context, request *pubsubv1.PublishRequest) (*emptypb.Empty, error) {
-	err := s.subpub.Publish(ctx, request.Key, request.Data)
+// closed converts reason subscription was closed by system.
+func closed(sub subpub.Subscription) error {
+	if err := sub.Err(); errors.Is(err, subpub.ErrSlowConsumer) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unavailable, "subscription closed")
+}
+
+// eventData converts payload of message for client. Data published
+// by clients is string, while system publishes structs to $SYS
+// subjects and dead letters, which are sent as JSON.
+func eventData(data interface{}) string {
+	if s, ok := data.(string); ok {
+		return s
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprint(data)
+	}
+	return string(raw)
+}
+
+// traceContext extracts W3C trace context of delivery
+// from headers of msg and injects it for client.
+func traceContext(msg subpub.Message) map[string]string {
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier(msg.Headers))
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+func (s SubPubServer) Publish(ctx context.Context, request *pubsubv1.PublishRequest) (*pubsubv1.PublishResponse, error) {
+	opts, err := publishOptions(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetDeliverAt() != nil {
+		if request.GetWaitForDelivery() {
+			return nil, status.Error(codes.InvalidArgument, "wait_for_delivery can't be used with deliver_at")
+		}
+		if err := request.GetDeliverAt().CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid deliver_at")
+		}
+
+		id, err := s.subpub.PublishAt(request.GetKey(), request.GetData(), request.GetDeliverAt().AsTime(), opts...)
+		if err != nil {
+			return nil, publishError(err)
+		}
+		return &pubsubv1.PublishResponse{ScheduledId: id}, nil
+	}
+
+	if request.GetWaitForDelivery() {
+		delivered, err := s.subpub.PublishAndWait(ctx, request.GetKey(), request.GetData(), opts...)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, status.Error(codes.DeadlineExceeded, "delivery not confirmed in time")
+		}
+		if err != nil {
+			return nil, publishError(err)
+		}
+		return &pubsubv1.PublishResponse{Delivered: uint32(delivered)}, nil
+	}
+
+	err = s.subpub.Publish(ctx, request.Key, request.Data, opts...)
 	if err != nil {
-		return &emptypb.Empty{}, status.Error(codes.Internal, "publish failed")
+		return &pubsubv1.PublishResponse{}, publishError(err)
 	}
 
-	return &emptypb.Empty{}, nil
+	return &pubsubv1.PublishResponse{}, nil
+}
+
+func (s SubPubServer) PublishTx(ctx context.Context, request *pubsubv1.PublishTxRequest) (*pubsubv1.PublishTxResponse, error) {
+	if len(request.GetMessages()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "transaction is empty")
+	}
+
+	messages := make([]service.TxMessage, 0, len(request.GetMessages()))
+	for _, msg := range request.GetMessages() {
+		opts, err := publishOptions(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, service.TxMessage{Key: msg.GetKey(), Data: msg.GetData(), Opts: opts})
+	}
+
+	if err := s.subpub.PublishTx(ctx, messages); err != nil {
+		return nil, publishError(err)
+	}
+
+	return &pubsubv1.PublishTxResponse{}, nil
+}
+
+// publishError converts error of publishing. Data rejected by schema
+// is InvalidArgument with violations in BadRequest details.
+func publishError(err error) error {
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return status.Error(codes.Internal, "publish failed")
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range validationErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "data" + v.Path,
+			Description: v.Description,
+		})
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, validationErr.Error()).WithDetails(badRequest)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+	return st.Err()
+}
+
+// messageSettings is implemented by requests
+// carrying per-message settings.
+type messageSettings interface {
+	GetTtl() *durationpb.Duration
+	GetIdempotencyKey() string
+	GetPartitionKey() string
+}
+
+// publishOptions converts per-message settings of request.
+func publishOptions(request messageSettings) ([]subpub.PublishOption, error) {
+	var opts []subpub.PublishOption
+
+	if request.GetTtl() != nil {
+		if err := request.GetTtl().CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid ttl")
+		}
+		ttl := request.GetTtl().AsDuration()
+		if ttl <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		opts = append(opts, subpub.WithTTL(ttl))
+	}
+
+	if request.GetIdempotencyKey() != "" {
+		opts = append(opts, subpub.WithIdempotencyKey(request.GetIdempotencyKey()))
+	}
+
+	if request.GetPartitionKey() != "" {
+		opts = append(opts, subpub.WithPartitionKey(request.GetPartitionKey()))
+	}
+
+	return opts, nil
 }
 
 func New(subpub SubPub, ctx context.Context) pubsubv1.PubSubServer {
 	return &SubPubServer{subpub: subpub, cancelCtx: ctx}
 }
 
-func Register(server *grpc.Server, subpub SubPub, ctx context.Context) {
+// StatsHandler creates server spans, continuing trace
+// context found in metadata of incoming requests.
+func StatsHandler() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithPropagators(propagator)))
+}
+
+// Register registers services on server, admin service
+// accepts snapshots of no more than maxSnapshotSize bytes.
+func Register(server *grpc.Server, subpub SubPub, admin Admin, maxSnapshotSize int, ctx context.Context) {
 	pubsubv1.RegisterPubSubServer(server, New(subpub, ctx))
+	pubsubv1.RegisterAdminServer(server, &AdminServer{admin: admin, maxSnapshotSize: maxSnapshotSize})
 }
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Kry0z1/subpub/pkg/subpub"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// SchemaSpec is schema of data published to keys matching Pattern,
+// see subpub.MatchSubject. Either JSONSchema or ProtoMessage is set.
+type SchemaSpec struct {
+	Pattern string
+
+	// JSONSchema is JSON Schema document, data must be JSON.
+	JSONSchema string
+
+	// ProtoDescriptorSet is serialized google.protobuf.FileDescriptorSet
+	// with ProtoMessage and all of its dependencies,
+	// data must be protojson of ProtoMessage.
+	ProtoDescriptorSet []byte
+	ProtoMessage       string
+}
+
+// Violation is one reason data doesn't match schema.
+type Violation struct {
+	// Path is JSON pointer into data, empty for data as a whole.
+	Path        string
+	Description string
+}
+
+// ValidationError is returned for data rejected by schema.
+type ValidationError struct {
+	Key        string
+	Pattern    string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "data for %q doesn't match schema of %q", e.Key, e.Pattern)
+	for _, v := range e.Violations {
+		sb.WriteString("; ")
+		if v.Path != "" {
+			sb.WriteString(v.Path + ": ")
+		}
+		sb.WriteString(v.Description)
+	}
+	return sb.String()
+}
+
+// validator checks data, returning violations of schema.
+type validator func(data string) []Violation
+
+type schema struct {
+	spec     SchemaSpec
+	validate validator
+}
+
+// SchemaRegistry holds schemas of keys by patterns.
+// Safe for concurrent use.
+type SchemaRegistry struct {
+	mut     *sync.RWMutex
+	schemas map[string]schema
+}
+
+// Register compiles schema and sets it for its pattern,
+// replacing previous one.
+func (r *SchemaRegistry) Register(spec SchemaSpec) error {
+	if err := subpub.ValidatePattern(spec.Pattern); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+
+	var validate validator
+	var err error
+	switch {
+	case spec.JSONSchema != "" && spec.ProtoMessage != "":
+		return fmt.Errorf("%w: both JSON Schema and protobuf message are set", ErrInvalidSchema)
+	case spec.JSONSchema != "":
+		validate, err = jsonValidator(spec.JSONSchema)
+	case spec.ProtoMessage != "":
+		validate, err = protoValidator(spec.ProtoDescriptorSet, spec.ProtoMessage)
+	default:
+		return fmt.Errorf("%w: neither JSON Schema nor protobuf message is set", ErrInvalidSchema)
+	}
+	if err != nil {
+		return fmt.Errorf("%w for %q: %w", ErrInvalidSchema, spec.Pattern, err)
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.schemas[spec.Pattern] = schema{spec: spec, validate: validate}
+	return nil
+}
+
+// Delete removes schema of pattern.
+// Returns false if there is none.
+func (r *SchemaRegistry) Delete(pattern string) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	_, ok := r.schemas[pattern]
+	delete(r.schemas, pattern)
+	return ok
+}
+
+// List returns registered schemas sorted by pattern.
+func (r *SchemaRegistry) List() []SchemaSpec {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	specs := make([]SchemaSpec, 0, len(r.schemas))
+	for _, schema := range r.schemas {
+		specs = append(specs, schema.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Pattern < specs[j].Pattern
+	})
+	return specs
+}
+
+// Validate checks data against every schema matching key,
+// in order of patterns.
+//
+// Returns *ValidationError for the first schema rejecting data.
+func (r *SchemaRegistry) Validate(key string, data string) error {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	var matched []string
+	for pattern := range r.schemas {
+		if subpub.MatchSubject(pattern, key) {
+			matched = append(matched, pattern)
+		}
+	}
+	sort.Strings(matched)
+
+	for _, pattern := range matched {
+		if violations := r.schemas[pattern].validate(data); len(violations) > 0 {
+			return &ValidationError{Key: key, Pattern: pattern, Violations: violations}
+		}
+	}
+	return nil
+}
+
+// Interceptor rejects data not matching schemas of subject it's
+// published to. Subpub system runs it after mapping, so data is
+// checked against schemas of keys it actually goes to, mirrors
+// included. Data which isn't string is not checked.
+func (r *SchemaRegistry) Interceptor() subpub.PublishInterceptor {
+	return func(ctx context.Context, subject string, msg subpub.Message, next subpub.PublishFunc) error {
+		if data, ok := msg.Data.(string); ok {
+			if err := r.Validate(subject, data); err != nil {
+				return err
+			}
+		}
+		return next(ctx, subject, msg)
+	}
+}
+
+func jsonValidator(document string) (validator, error) {
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+
+	const url = "schema.json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, doc); err != nil {
+		return nil, err
+	}
+	sch, err := compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(data string) []Violation {
+		value, err := jsonschema.UnmarshalJSON(bytes.NewReader([]byte(data)))
+		if err != nil {
+			return []Violation{{Description: "invalid JSON: " + err.Error()}}
+		}
+
+		err = sch.Validate(value)
+		var validationErr *jsonschema.ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil
+		}
+		var violations []Violation
+		for _, unit := range validationErr.BasicOutput().Errors {
+			if unit.Error == nil {
+				continue
+			}
+			violations = append(violations, Violation{Path: unit.InstanceLocation, Description: unit.Error.String()})
+		}
+		return violations
+	}, nil
+}
+
+func protoValidator(descriptorSet []byte, message string) (validator, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, err
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", message)
+	}
+
+	return func(data string) []Violation {
+		if err := protojson.Unmarshal([]byte(data), dynamicpb.NewMessage(msgDesc)); err != nil {
+			return []Violation{{Description: err.Error()}}
+		}
+		return nil
+	}, nil
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		mut:     &sync.RWMutex{},
+		schemas: make(map[string]schema),
+	}
+}
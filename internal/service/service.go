@@ -2,55 +2,336 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/Kry0z1/subpub/internal/config"
+	"github.com/Kry0z1/subpub/internal/snapshot"
+	"github.com/Kry0z1/subpub/internal/transform"
 	"github.com/Kry0z1/subpub/pkg/subpub"
 	"log/slog"
+	"os"
+	"time"
 )
 
 type SubPub interface {
-	Subscribe(key string) (chan string, error)
-	Publish(ctx context.Context, key string, data string) error
+	Subscribe(key string, opts ...subpub.SubscribeOption) (<-chan subpub.Message, subpub.Subscription, error)
+	SubscribeGroup(key string, group string) (<-chan subpub.GroupMessage, subpub.Subscription, error)
+	Publish(ctx context.Context, key string, data string, opts ...subpub.PublishOption) error
+	PublishAndWait(ctx context.Context, key string, data string, opts ...subpub.PublishOption) (int, error)
+	PublishAt(key string, data string, at time.Time, opts ...subpub.PublishOption) (uint64, error)
+	PublishTx(ctx context.Context, messages []TxMessage) error
+}
+
+// TxMessage is one message of transaction.
+type TxMessage struct {
+	Key  string
+	Data string
+	Opts []subpub.PublishOption
 }
 
 type SubPubService struct {
 	subpubSystem subpub.SubPub
+	schemas      *SchemaRegistry
+	transforms   *transform.Engine
 	log          *slog.Logger
 }
 
-func New(log *slog.Logger) SubPubService {
+func New(log *slog.Logger, cfg config.SubPubConfig) (SubPubService, error) {
+	const op = "service.New"
+
+	schemas := NewSchemaRegistry()
+	for _, schemaCfg := range cfg.Schemas {
+		spec, err := loadSchema(schemaCfg)
+		if err == nil {
+			err = schemas.Register(spec)
+		}
+		if err != nil {
+			return SubPubService{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var system subpub.SubPub
+	transforms, err := transform.New(log, cfg.Transforms, func(ctx context.Context, key string, data string, headers map[string]string) error {
+		return system.PublishContext(ctx, key, data, subpub.WithHeaders(headers))
+	})
+	if err != nil {
+		return SubPubService{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	mapping, err := subpub.CompileMapping(mappingRules(cfg))
+	if err != nil {
+		return SubPubService{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	opts := []subpub.Option{
+		subpub.WithLogger(log),
+		subpub.WithSubjectMapping(mapping),
+		subpub.WithDedupWindow(cfg.DedupWindow),
+		// schemas go first, so that rejected data isn't transformed;
+		// derived messages are published through them too
+		subpub.WithPublishInterceptors(schemas.Interceptor(), transforms.Interceptor()),
+	}
+	if cfg.DeadLetter != "" {
+		opts = append(opts, subpub.WithDeadLetter(cfg.DeadLetter))
+	}
+	for key, ttl := range cfg.KeyTTL {
+		opts = append(opts, subpub.WithSubjectTTL(key, ttl))
+	}
+	for key, partitions := range cfg.Partitions {
+		opts = append(opts, subpub.WithPartitions(key, subpub.PartitionPolicy{
+			Partitions: partitions.Partitions,
+			Retention:  partitions.Retention,
+		}))
+	}
+	if cfg.QueueLimit > 0 {
+		overflow := subpub.OverflowDropOldest
+		if cfg.QueueOverflow == config.OverflowDropNewest {
+			overflow = subpub.OverflowDropNewest
+		}
+		opts = append(opts, subpub.WithSubscribeDefaults(subpub.WithQueueLimit(cfg.QueueLimit, overflow)))
+	}
+	if cfg.Tracing {
+		opts = append(opts, subpub.WithTracing(nil))
+	}
+	if slow := cfg.SlowConsumer; slow.Enabled() {
+		opts = append(opts, subpub.WithWatchdog(subpub.SlowConsumerPolicy{
+			MaxQueued:      slow.MaxQueued,
+			MaxQueueAge:    slow.MaxQueueAge,
+			MaxHandlerTime: slow.MaxHandlerTime,
+			Evict:          slow.Evict,
+			Interval:       slow.Interval,
+		}))
+	}
+
+	system = subpub.NewSubPub(opts...)
+	if err := transforms.Start(system); err != nil {
+		return SubPubService{}, fmt.Errorf("%s: %w", op, err)
+	}
+
 	return SubPubService{
 		log:          log,
-		subpubSystem: subpub.NewSubPub(),
+		schemas:      schemas,
+		transforms:   transforms,
+		subpubSystem: system,
+	}, nil
+}
+
+func mappingRules(cfg config.SubPubConfig) subpub.MappingRules {
+	var rules subpub.MappingRules
+	for _, mapping := range cfg.Mappings {
+		rule := subpub.MapRule{Source: mapping.Source}
+		for _, dest := range mapping.Destinations {
+			rule.Destinations = append(rule.Destinations, subpub.WeightedSubject{Subject: dest.Key, Weight: dest.Weight})
+		}
+		rules.Maps = append(rules.Maps, rule)
+	}
+	for _, mirror := range cfg.Mirrors {
+		rules.Mirrors = append(rules.Mirrors, subpub.MirrorRule{Source: mirror.Source, Destination: mirror.Destination})
 	}
+	return rules
 }
 
-func (s *SubPubService) Subscribe(key string) (chan string, error) {
-	const op = "service.Subscribe"
+// loadSchema reads files of schema.
+func loadSchema(cfg config.SchemaConfig) (SchemaSpec, error) {
+	spec := SchemaSpec{Pattern: cfg.Pattern, ProtoMessage: cfg.ProtoMessage}
+	if cfg.JSONSchema != "" {
+		document, err := os.ReadFile(cfg.JSONSchema)
+		if err != nil {
+			return SchemaSpec{}, err
+		}
+		spec.JSONSchema = string(document)
+	}
+	if cfg.ProtoDescriptor != "" {
+		descriptorSet, err := os.ReadFile(cfg.ProtoDescriptor)
+		if err != nil {
+			return SchemaSpec{}, err
+		}
+		spec.ProtoDescriptorSet = descriptorSet
+	}
+	return spec, nil
+}
+
+// RegisterSchema sets schema for keys matching its pattern,
+// replacing previous one. Messages published before are not checked.
+func (s *SubPubService) RegisterSchema(spec SchemaSpec) error {
+	const op = "service.RegisterSchema"
 
 	log := s.log.With(
 		slog.String("op", op),
-		slog.String("key", key),
+		slog.String("pattern", spec.Pattern),
 	)
 
-	returnChan := make(chan string)
-	cb := func(msg interface{}) {
-		returnChan <- msg.(string)
+	if err := s.schemas.Register(spec); err != nil {
+		log.Info("invalid schema", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("started subscription")
-	_, err := s.subpubSystem.Subscribe(key, cb)
+	log.Info("schema registered")
+	return nil
+}
+
+// DeleteSchema removes schema of pattern.
+// Returns false if there is none.
+func (s *SubPubService) DeleteSchema(pattern string) bool {
+	deleted := s.schemas.Delete(pattern)
+	s.log.Info("schema deleted",
+		slog.String("op", "service.DeleteSchema"),
+		slog.String("pattern", pattern),
+		slog.Bool("found", deleted),
+	)
+	return deleted
+}
+
+// ListSchemas returns schemas sorted by pattern.
+func (s *SubPubService) ListSchemas() []SchemaSpec {
+	return s.schemas.List()
+}
+
+// SubjectMapping returns current rules of mapping and mirroring keys.
+func (s *SubPubService) SubjectMapping() subpub.MappingRules {
+	mapping := s.subpubSystem.SubjectMapping()
+	if mapping == nil {
+		return subpub.MappingRules{}
+	}
+	return mapping.Rules()
+}
+
+// SetSubjectMapping replaces all rules of mapping and mirroring keys.
+// Messages published before are not affected.
+func (s *SubPubService) SetSubjectMapping(rules subpub.MappingRules) error {
+	const op = "service.SetSubjectMapping"
+
+	log := s.log.With(slog.String("op", op))
+
+	mapping, err := subpub.CompileMapping(rules)
 	if err != nil {
-		log.Error("subscription failed: ", err.Error())
+		log.Info("invalid mapping", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.subpubSystem.SetSubjectMapping(mapping)
+
+	log.Info("mapping replaced", slog.Int("maps", len(rules.Maps)), slog.Int("mirrors", len(rules.Mirrors)))
+	return nil
+}
+
+// Snapshot encodes state of system to snapshot file, see package snapshot.
+func (s *SubPubService) Snapshot() ([]byte, error) {
+	const op = "service.Snapshot"
+
+	log := s.log.With(slog.String("op", op))
+
+	state := s.subpubSystem.Snapshot()
+	data, err := snapshot.Encode(state)
+	if err != nil {
+		log.Error("snapshot failed", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	log.Info("snapshot taken",
+		slog.Int("subjects", len(state.Subjects)),
+		slog.Int("topics", len(state.Topics)),
+		slog.Int("scheduled", len(state.Scheduled)),
+		slog.Int("bytes", len(data)),
+	)
+	return data, nil
+}
+
+// Restore loads snapshot file into system, returning what was loaded.
+func (s *SubPubService) Restore(data []byte) (subpub.Snapshot, error) {
+	const op = "service.Restore"
+
+	log := s.log.With(slog.String("op", op))
+
+	state, err := snapshot.Decode(data)
+	if err == nil {
+		err = s.subpubSystem.Restore(state)
+	}
+	if err != nil {
+		log.Info("restore failed", slog.String("error", err.Error()))
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("snapshot restored",
+		slog.Time("taken_at", state.TakenAt),
+		slog.Int("subjects", len(state.Subjects)),
+		slog.Int("topics", len(state.Topics)),
+		slog.Int("scheduled", len(state.Scheduled)),
+	)
+	return state, nil
+}
+
+// TransformStats returns counters of every transform rule.
+func (s *SubPubService) TransformStats() []transform.Stats {
+	return s.transforms.Stats()
+}
+
+// logPublishError logs err of publishing as msg with args,
+// data rejected by schema is fault of client, so it's only info.
+func logPublishError(log *slog.Logger, msg string, err error, args ...any) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		log.Info("rejected by schema", slog.String("error", err.Error()))
+		return
+	}
+	log.Error(msg, append([]any{slog.String("error", err.Error())}, args...)...)
+}
+
+// Subscribe returns channel of messages on key,
+// which is closed once subscription is closed.
+//
+// Caller must Unsubscribe once it's not interested anymore.
+func (s *SubPubService) Subscribe(key string, opts ...subpub.SubscribeOption) (<-chan subpub.Message, subpub.Subscription, error) {
+	const op = "service.Subscribe"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("key", key),
+	)
+
+	log.Info("started subscription")
+	msgs, sub, err := s.subpubSystem.SubscribeChan(key, opts...)
+	if err != nil {
+		log.Error("subscription failed", slog.String("error", err.Error()))
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
 	log.Info("subscription successful")
-	return returnChan, nil
+	return msgs, sub, nil
+}
+
+// SubscribeGroup joins consumer group of partitioned key,
+// offset of message is committed once it's received from channel.
+func (s *SubPubService) SubscribeGroup(key string, group string) (<-chan subpub.GroupMessage, subpub.Subscription, error) {
+	const op = "service.SubscribeGroup"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("key", key),
+		slog.String("group", group),
+	)
+
+	log.Info("joining group")
+	msgs, sub, err := s.subpubSystem.SubscribeGroupChan(key, group)
+	if err != nil {
+		log.Error("joining group failed", slog.String("error", err.Error()))
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("joined group")
+	return msgs, sub, nil
+}
+
+// Groups returns consumer groups of partitioned keys with their offsets.
+func (s *SubPubService) Groups() []subpub.GroupStats {
+	return s.subpubSystem.Stats().Groups
 }
 
 // on ctx cancel returns but eventually message will be sent
 // if context is canceled error from subpub will be omitted
-func (s *SubPubService) Publish(ctx context.Context, key string, data string) error {
+//
+// so retries should carry subpub.WithIdempotencyKey,
+// then message is delivered only once
+func (s *SubPubService) Publish(ctx context.Context, key string, data string, opts ...subpub.PublishOption) error {
 	const op = "service.Publish"
 
 	log := s.log.With(
@@ -64,7 +345,7 @@ func (s *SubPubService) Publish(ctx context.Context, key string, data string) er
 	log.Info("started publish", key, data)
 
 	go func() {
-		published <- s.subpubSystem.Publish(key, data)
+		published <- s.subpubSystem.PublishContext(ctx, key, data, opts...)
 	}()
 
 	select {
@@ -73,7 +354,7 @@ func (s *SubPubService) Publish(ctx context.Context, key string, data string) er
 		if err == nil {
 			return nil
 		}
-		log.Error("publish failed: ", err.Error())
+		logPublishError(log, "publish failed", err)
 		return fmt.Errorf("%s: %w", op, err)
 	case <-ctx.Done():
 		log.Info("timed out")
@@ -81,6 +362,93 @@ func (s *SubPubService) Publish(ctx context.Context, key string, data string) er
 	}
 }
 
-func (s *SubPubService) Stop(ctx context.Context) error {
-	return s.subpubSystem.Close(ctx)
+// PublishAndWait publishes data and waits until every current
+// subscriber has handled it.
+//
+// Returns number of subscribers which have handled data,
+// on ctx cancel it's the number known by that moment.
+func (s *SubPubService) PublishAndWait(ctx context.Context, key string, data string, opts ...subpub.PublishOption) (int, error) {
+	const op = "service.PublishAndWait"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("key", key),
+		slog.String("data", data),
+	)
+
+	log.Info("started publish")
+
+	report, err := s.subpubSystem.PublishAndWait(ctx, key, data, opts...)
+	if err != nil {
+		logPublishError(log, "publish failed", err, slog.Int("delivered", report.Delivered()))
+		return report.Delivered(), fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully delivered", slog.Int("delivered", report.Delivered()))
+	return report.Delivered(), nil
+}
+
+// PublishAt schedules data to be published at given time.
+// Data is checked by schemas once its time has come, as key
+// may be mapped differently by then; rejected data is dropped.
+//
+// Returns ID of scheduled message.
+func (s *SubPubService) PublishAt(key string, data string, at time.Time, opts ...subpub.PublishOption) (uint64, error) {
+	const op = "service.PublishAt"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("key", key),
+		slog.String("data", data),
+		slog.Time("deliver_at", at),
+	)
+
+	id, err := s.subpubSystem.PublishAt(key, data, at, opts...)
+	if err != nil {
+		log.Error("scheduling failed", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully scheduled", slog.Uint64("id", id))
+	return id, nil
+}
+
+// PublishTx publishes all messages atomically: subscribers
+// see either all of them or none.
+func (s *SubPubService) PublishTx(ctx context.Context, messages []TxMessage) error {
+	const op = "service.PublishTx"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.Int("messages", len(messages)),
+	)
+
+	if err := ctx.Err(); err != nil {
+		log.Info("timed out")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx := s.subpubSystem.Begin()
+	for _, msg := range messages {
+		if err := tx.Publish(msg.Key, msg.Data, msg.Opts...); err != nil {
+			tx.Rollback()
+			logPublishError(log, "staging failed", err)
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("commit failed", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("successfully committed")
+	return nil
+}
+
+// Stop shuts subpub system down, forcibly once ctx is done.
+//
+// Report lists messages and handlers abandoned on forced stop.
+func (s *SubPubService) Stop(ctx context.Context) (subpub.ShutdownReport, error) {
+	return s.subpubSystem.Shutdown(ctx, subpub.WithHardStop())
 }
@@ -0,0 +1,258 @@
+// Package snapshot encodes state of subpub system to file
+// and decodes it back.
+//
+// File is JSON object:
+//
+//	{
+//	  "format": "subpub-snapshot",
+//	  "version": 1,
+//	  "sha256": "<hex SHA-256 of compact JSON of state>",
+//	  "state": {...}
+//	}
+//
+// State of version 1 has "taken_at" (RFC 3339), "subjects" with
+// their "published" counters, "topics" (partitioned keys) with
+// "partitions" of retained messages starting at offset "base" and
+// "groups" with committed "offsets" per partition, and "scheduled"
+// messages with their "id", "deliver_at" and publish options. Data
+// of messages is string, TTL of scheduled message is Go duration.
+//
+// Decode rejects files of other format, of newer version and with
+// state not matching checksum, so that truncated or edited file is
+// never loaded.
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Kry0z1/subpub/pkg/subpub"
+)
+
+const (
+	Format  = "subpub-snapshot"
+	Version = 1
+)
+
+var (
+	ErrFormat   = errors.New("not a subpub snapshot")
+	ErrVersion  = errors.New("unsupported snapshot version")
+	ErrChecksum = errors.New("snapshot checksum mismatch")
+	// ErrData is returned by Encode for data which is not string.
+	ErrData = errors.New("data is not string")
+)
+
+type file struct {
+	Format  string          `json:"format"`
+	Version int             `json:"version"`
+	SHA256  string          `json:"sha256"`
+	State   json.RawMessage `json:"state"`
+}
+
+type state struct {
+	TakenAt   time.Time   `json:"taken_at"`
+	Subjects  []subject   `json:"subjects"`
+	Topics    []topic     `json:"topics"`
+	Scheduled []scheduled `json:"scheduled"`
+}
+
+type subject struct {
+	Subject   string `json:"subject"`
+	Published uint64 `json:"published"`
+}
+
+type topic struct {
+	Subject    string      `json:"subject"`
+	Partitions []partition `json:"partitions"`
+	Groups     []group     `json:"groups"`
+}
+
+type partition struct {
+	Base     int64     `json:"base"`
+	Messages []message `json:"messages"`
+}
+
+type message struct {
+	Data        string            `json:"data"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	PublishedAt time.Time         `json:"published_at,omitzero"`
+	ExpiresAt   time.Time         `json:"expires_at,omitzero"`
+}
+
+type group struct {
+	Group   string  `json:"group"`
+	Offsets []int64 `json:"offsets"`
+}
+
+type scheduled struct {
+	ID        uint64    `json:"id"`
+	Subject   string    `json:"subject"`
+	Data      string    `json:"data"`
+	DeliverAt time.Time `json:"deliver_at"`
+
+	Headers        map[string]string `json:"headers,omitempty"`
+	TTL            string            `json:"ttl,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+	Priority       subpub.Priority   `json:"priority,omitempty"`
+	PartitionKey   string            `json:"partition_key,omitempty"`
+}
+
+// Encode encodes snapshot of current version.
+func Encode(snapshot subpub.Snapshot) ([]byte, error) {
+	const op = "snapshot.Encode"
+
+	st := state{
+		TakenAt:   snapshot.TakenAt,
+		Subjects:  make([]subject, 0, len(snapshot.Subjects)),
+		Topics:    make([]topic, 0, len(snapshot.Topics)),
+		Scheduled: make([]scheduled, 0, len(snapshot.Scheduled)),
+	}
+	for _, s := range snapshot.Subjects {
+		st.Subjects = append(st.Subjects, subject{Subject: s.Subject, Published: s.Published})
+	}
+	for _, ts := range snapshot.Topics {
+		t := topic{
+			Subject:    ts.Subject,
+			Partitions: make([]partition, 0, len(ts.Partitions)),
+			Groups:     make([]group, 0, len(ts.Groups)),
+		}
+		for _, ps := range ts.Partitions {
+			p := partition{Base: ps.Base, Messages: make([]message, 0, len(ps.Messages))}
+			for _, m := range ps.Messages {
+				data, ok := m.Data.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: %w: %s", op, ErrData, ts.Subject)
+				}
+				p.Messages = append(p.Messages, message{
+					Data:        data,
+					Headers:     m.Headers,
+					PublishedAt: m.PublishedAt,
+					ExpiresAt:   m.ExpiresAt,
+				})
+			}
+			t.Partitions = append(t.Partitions, p)
+		}
+		for _, gs := range ts.Groups {
+			t.Groups = append(t.Groups, group{Group: gs.Group, Offsets: gs.Offsets})
+		}
+		st.Topics = append(st.Topics, t)
+	}
+	for _, ss := range snapshot.Scheduled {
+		data, ok := ss.Data.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w: %s", op, ErrData, ss.Subject)
+		}
+		s := scheduled{
+			ID:        ss.ID,
+			Subject:   ss.Subject,
+			Data:      data,
+			DeliverAt: ss.DeliverAt,
+
+			Headers:        ss.Headers,
+			IdempotencyKey: ss.IdempotencyKey,
+			Priority:       ss.Priority,
+			PartitionKey:   ss.PartitionKey,
+		}
+		if ss.TTL > 0 {
+			s.TTL = ss.TTL.String()
+		}
+		st.Scheduled = append(st.Scheduled, s)
+	}
+
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	sum := sha256.Sum256(raw)
+
+	data, err := json.MarshalIndent(file{
+		Format:  Format,
+		Version: Version,
+		SHA256:  hex.EncodeToString(sum[:]),
+		State:   raw,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return data, nil
+}
+
+// Decode checks and decodes snapshot.
+func Decode(data []byte) (subpub.Snapshot, error) {
+	const op = "snapshot.Decode"
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil || f.Format != Format {
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w", op, ErrFormat)
+	}
+	if f.Version < 1 || f.Version > Version {
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w: %d", op, ErrVersion, f.Version)
+	}
+
+	// indentation of file is applied to state as well,
+	// so checksum is taken over its compact form
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, f.State); err != nil {
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w", op, ErrFormat)
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	if hex.EncodeToString(sum[:]) != f.SHA256 {
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w", op, ErrChecksum)
+	}
+
+	var st state
+	if err := json.Unmarshal(f.State, &st); err != nil {
+		return subpub.Snapshot{}, fmt.Errorf("%s: %w: %w", op, ErrFormat, err)
+	}
+
+	snapshot := subpub.Snapshot{TakenAt: st.TakenAt}
+	for _, s := range st.Subjects {
+		snapshot.Subjects = append(snapshot.Subjects, subpub.SubjectSnapshot{Subject: s.Subject, Published: s.Published})
+	}
+	for _, t := range st.Topics {
+		ts := subpub.TopicSnapshot{Subject: t.Subject}
+		for _, p := range t.Partitions {
+			ps := subpub.PartitionSnapshot{Base: p.Base}
+			for _, m := range p.Messages {
+				ps.Messages = append(ps.Messages, subpub.RetainedMessage{
+					Data:        m.Data,
+					Headers:     m.Headers,
+					PublishedAt: m.PublishedAt,
+					ExpiresAt:   m.ExpiresAt,
+				})
+			}
+			ts.Partitions = append(ts.Partitions, ps)
+		}
+		for _, g := range t.Groups {
+			ts.Groups = append(ts.Groups, subpub.GroupSnapshot{Group: g.Group, Offsets: g.Offsets})
+		}
+		snapshot.Topics = append(snapshot.Topics, ts)
+	}
+	for _, s := range st.Scheduled {
+		ss := subpub.ScheduledSnapshot{
+			ID:        s.ID,
+			Subject:   s.Subject,
+			Data:      s.Data,
+			DeliverAt: s.DeliverAt,
+
+			Headers:        s.Headers,
+			IdempotencyKey: s.IdempotencyKey,
+			Priority:       s.Priority,
+			PartitionKey:   s.PartitionKey,
+		}
+		if s.TTL != "" {
+			ttl, err := time.ParseDuration(s.TTL)
+			if err != nil {
+				return subpub.Snapshot{}, fmt.Errorf("%s: %w: ttl of %d: %w", op, ErrFormat, s.ID, err)
+			}
+			ss.TTL = ttl
+		}
+		snapshot.Scheduled = append(snapshot.Scheduled, ss)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,361 @@
+// Package transform derives subjects from others: messages of
+// source subjects are filtered, rewritten and republished
+// to destination subjects by declarative rules.
+package transform
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kry0z1/subpub/internal/config"
+	"github.com/Kry0z1/subpub/pkg/subpub"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// TrailHeader lists rules which have derived message,
+	// separated by trailSeparator. Rule never processes
+	// message it has already derived, so loops are cut.
+	TrailHeader    = "subpub-derived-by"
+	trailSeparator = ","
+
+	// defaultIdle is how long subscription to subject matching
+	// wildcard source lives after the last message published to it.
+	defaultIdle = 5 * time.Minute
+)
+
+// Publisher publishes derived data with headers.
+type Publisher func(ctx context.Context, subject string, data string, headers map[string]string) error
+
+// Engine runs rules on top of subpub system.
+//
+// Sources without wildcards are subscribed on Start. Subjects matching
+// wildcard sources are subscribed once something is published to
+// them, so Interceptor must be set for subpub system. Those
+// subscriptions are released once nothing has been published to
+// their subjects for a while and rule has taken every message
+// published before, so they don't pile up.
+type Engine struct {
+	log     *slog.Logger
+	rules   []*rule
+	publish Publisher
+
+	// idle is how long subscription to subject matching
+	// wildcard source outlives the last message to it.
+	idle time.Duration
+
+	// subscribed holds *subscription by subject for every rule
+	// with wildcard source, nil for others. Publishers only read it,
+	// new subjects are added under mut.
+	subscribed []*sync.Map
+
+	// mut guards sp and swept, publishers take it
+	// only to subscribe to new subject
+	mut *sync.Mutex
+	sp  subpub.SubPub
+	// swept is when idle subscriptions were released last time
+	swept time.Time
+}
+
+// subscription is subscription of rule to subject matching its source.
+type subscription struct {
+	// mut is read-locked while message is published to subject,
+	// so that subscription isn't released before message reaches it
+	mut *sync.RWMutex
+	sub subpub.Subscription
+	// last is unix nanoseconds of the last message to subject
+	last *atomic.Int64
+	// pending is number of messages published to subject, but not
+	// taken by rule yet. Messages lost on the way (expired, dropped
+	// or rolled back) are never taken, so subscription stays.
+	pending *atomic.Int64
+	// released is set once subscription is removed from subscribed
+	released bool
+}
+
+// Interceptor subscribes rules with wildcard source
+// to subjects matching it before message is published.
+func (e *Engine) Interceptor() subpub.PublishInterceptor {
+	return func(ctx context.Context, subject string, msg subpub.Message, next subpub.PublishFunc) error {
+		var held []*subscription
+		defer func() {
+			for _, s := range held {
+				s.mut.RUnlock()
+			}
+		}()
+
+		for i, r := range e.rules {
+			if e.subscribed[i] == nil || !subpub.MatchSubject(r.source, subject) {
+				continue
+			}
+			// fails only if system is closing,
+			// then publishing fails too
+			if s, _ := e.acquire(i, subject); s != nil {
+				held = append(held, s)
+			}
+		}
+
+		err := next(ctx, subject, msg)
+		if err != nil {
+			for _, s := range held {
+				s.pending.Add(-1)
+			}
+		}
+		return err
+	}
+}
+
+// Start subscribes rules to their sources in sp.
+func (e *Engine) Start(sp subpub.SubPub) error {
+	const op = "transform.Start"
+
+	e.mut.Lock()
+	e.sp = sp
+	e.swept = time.Now()
+	e.mut.Unlock()
+
+	for _, r := range e.rules {
+		if isWildcard(r.source) {
+			continue
+		}
+		if _, err := sp.SubscribeBatch(r.source, e.handler(r, nil)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// Stats returns counters of every rule in order of config.
+func (e *Engine) Stats() []Stats {
+	stats := make([]Stats, 0, len(e.rules))
+	for _, r := range e.rules {
+		stats = append(stats, r.stats())
+	}
+	return stats
+}
+
+// acquire returns subscription of rule i to subject with message
+// added to pending, subscribing it if there is none yet.
+// Subscription is read-locked and must be unlocked once message
+// is published. Returns nil before Start.
+func (e *Engine) acquire(i int, subject string) (*subscription, error) {
+	for {
+		var s *subscription
+		if v, ok := e.subscribed[i].Load(subject); ok {
+			s = v.(*subscription)
+		} else {
+			var err error
+			if s, err = e.subscribe(i, subject); s == nil {
+				return nil, err
+			}
+		}
+
+		s.mut.RLock()
+		if !s.released {
+			s.last.Store(time.Now().UnixNano())
+			s.pending.Add(1)
+			return s, nil
+		}
+		// released meanwhile, so it's gone from subscribed
+		s.mut.RUnlock()
+	}
+}
+
+// subscribe subscribes rule i to subject, if it hasn't been yet,
+// and releases idle subscriptions now and then.
+func (e *Engine) subscribe(i int, subject string) (*subscription, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.sp == nil {
+		return nil, nil
+	}
+	if v, ok := e.subscribed[i].Load(subject); ok {
+		return v.(*subscription), nil
+	}
+
+	now := time.Now()
+	if now.Sub(e.swept) >= e.idle/2 {
+		e.swept = now
+		e.sweep(now)
+	}
+
+	s := &subscription{
+		mut:     &sync.RWMutex{},
+		last:    &atomic.Int64{},
+		pending: &atomic.Int64{},
+	}
+	s.last.Store(now.UnixNano())
+	sub, err := e.sp.SubscribeBatch(subject, e.handler(e.rules[i], s))
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+	e.subscribed[i].Store(subject, s)
+	return s, nil
+}
+
+// sweep releases subscriptions nothing has been published to
+// for idle. Subscriptions in use are skipped, so it never waits
+// for publishers. e.mut must be held.
+func (e *Engine) sweep(now time.Time) {
+	cutoff := now.Add(-e.idle).UnixNano()
+	for _, subscribed := range e.subscribed {
+		if subscribed == nil {
+			continue
+		}
+		subscribed.Range(func(subject, v any) bool {
+			s := v.(*subscription)
+			if s.last.Load() > cutoff || !s.mut.TryLock() {
+				return true
+			}
+			defer s.mut.Unlock()
+
+			// nobody publishes to subject while it's locked,
+			// and rule has nothing to take, so Unsubscribe
+			// doesn't wait for handler
+			if s.last.Load() > cutoff || s.pending.Load() > 0 {
+				return true
+			}
+			s.sub.Unsubscribe()
+			s.released = true
+			subscribed.Delete(subject)
+			return true
+		})
+	}
+}
+
+// subscriptions returns number of subjects subscribed
+// by rules with wildcard sources.
+func (e *Engine) subscriptions() int {
+	n := 0
+	for _, subscribed := range e.subscribed {
+		if subscribed == nil {
+			continue
+		}
+		subscribed.Range(func(any, any) bool {
+			n++
+			return true
+		})
+	}
+	return n
+}
+
+// handler applies rule to messages of its subscription s,
+// which is nil for source without wildcards.
+func (e *Engine) handler(r *rule, s *subscription) subpub.BatchHandler {
+	log := e.log.With(slog.String("op", "transform.run"), slog.String("rule", r.name))
+
+	return func(msgs []subpub.Message) {
+		for _, msg := range msgs {
+			e.handle(log, r, msg)
+			if s != nil {
+				s.pending.Add(-1)
+			}
+		}
+	}
+}
+
+// handle applies rule to msg and publishes derived message.
+func (e *Engine) handle(log *slog.Logger, r *rule, msg subpub.Message) {
+	r.received.Add(1)
+
+	trail := msg.Headers[TrailHeader]
+	if derivedBy(trail, r.name) {
+		r.loops.Add(1)
+		log.Warn("loop detected", slog.String("subject", msg.Subject), slog.String("trail", trail))
+		return
+	}
+
+	raw, ok := msg.Data.(string)
+	if !ok {
+		r.failed.Add(1)
+		log.Error("data is not string", slog.String("subject", msg.Subject))
+		return
+	}
+
+	destination, data, pass, err := r.apply(msg.Subject, raw)
+	if err != nil {
+		r.failed.Add(1)
+		log.Error("transform failed", slog.String("subject", msg.Subject), slog.String("error", err.Error()))
+		return
+	}
+	if !pass {
+		r.filtered.Add(1)
+		return
+	}
+
+	// trace continues from delivery of source message
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(headers))
+	if trail == "" {
+		headers[TrailHeader] = r.name
+	} else {
+		headers[TrailHeader] = trail + trailSeparator + r.name
+	}
+
+	if err := e.publish(ctx, destination, data, headers); err != nil {
+		r.failed.Add(1)
+		log.Error("publish failed", slog.String("destination", destination), slog.String("error", err.Error()))
+		return
+	}
+	r.published.Add(1)
+}
+
+func derivedBy(trail string, name string) bool {
+	for derived := range strings.SplitSeq(trail, trailSeparator) {
+		if derived == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isWildcard(pattern string) bool {
+	for token := range strings.SplitSeq(pattern, subpub.TokenSeparator) {
+		if token == subpub.AnyToken || token == subpub.AnyTail {
+			return true
+		}
+	}
+	return false
+}
+
+// New compiles rules, which publish derived messages with publish.
+func New(log *slog.Logger, rules []config.TransformConfig, publish Publisher) (*Engine, error) {
+	e := &Engine{
+		log:     log,
+		publish: publish,
+		idle:    defaultIdle,
+		mut:     &sync.Mutex{},
+	}
+
+	names := map[string]struct{}{}
+	for _, cfg := range rules {
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[r.name]; ok {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidRule, r.name)
+		}
+		names[r.name] = struct{}{}
+
+		e.rules = append(e.rules, r)
+		var subscribed *sync.Map
+		if isWildcard(r.source) {
+			subscribed = &sync.Map{}
+		}
+		e.subscribed = append(e.subscribed, subscribed)
+	}
+	return e, nil
+}
@@ -0,0 +1,134 @@
+package transform
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kry0z1/subpub/internal/config"
+	"github.com/Kry0z1/subpub/internal/logger/slogdiscard"
+	"github.com/Kry0z1/subpub/pkg/subpub"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const receiveTimeout = time.Second
+
+// derived are messages published by engine.
+type derived struct {
+	mut      *sync.Mutex
+	subjects []string
+}
+
+func (d *derived) publish(ctx context.Context, subject string, data string, headers map[string]string) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.subjects = append(d.subjects, subject)
+	return nil
+}
+
+func (d *derived) len() int {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return len(d.subjects)
+}
+
+// start runs rules with idle on top of new subpub system.
+func start(t *testing.T, idle time.Duration, rules ...config.TransformConfig) (*Engine, subpub.SubPub, *derived) {
+	t.Helper()
+
+	d := &derived{mut: &sync.Mutex{}}
+	e, err := New(slog.New(slogdiscard.NewDiscardHandler()), rules, d.publish)
+	require.NoError(t, err)
+	e.idle = idle
+
+	sp := subpub.NewSubPub(subpub.WithPublishInterceptors(e.Interceptor()))
+	t.Cleanup(func() { sp.Close(context.Background()) })
+	require.NoError(t, e.Start(sp))
+	return e, sp, d
+}
+
+func TestEngineSubscribesMatchingSubjects(t *testing.T) {
+	e, sp, d := start(t, time.Hour,
+		config.TransformConfig{Name: "greeting", Source: "greet", Destination: "greetings"},
+		config.TransformConfig{Name: "big", Source: "orders.*", Destination: "big.{{index .Tokens 1}}"},
+	)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("orders."+strconv.Itoa(i), "{}"))
+	}
+	require.NoError(t, sp.Publish("orders.0", "{}"))
+	require.NoError(t, sp.Publish("greet", "world"))
+	require.NoError(t, sp.Publish("other", "{}"))
+
+	require.Eventually(t, func() bool { return d.len() == 5 }, receiveTimeout, time.Millisecond)
+	assert.ElementsMatch(t, []string{"big.0", "big.1", "big.2", "big.0", "greetings"}, d.subjects)
+	// source without wildcards is subscribed once on Start
+	assert.Equal(t, 3, e.subscriptions())
+}
+
+func TestEngineReleasesIdleSubscriptions(t *testing.T) {
+	const idle = 50 * time.Millisecond
+
+	e, sp, d := start(t, idle, config.TransformConfig{Name: "big", Source: "orders.*", Destination: "big"})
+
+	for i := range 100 {
+		require.NoError(t, sp.Publish("orders."+strconv.Itoa(i), "{}"))
+	}
+	require.Eventually(t, func() bool { return d.len() == 100 }, receiveTimeout, time.Millisecond)
+	assert.Equal(t, 100, e.subscriptions())
+
+	// subscribing new subject releases idle ones
+	time.Sleep(idle)
+	require.NoError(t, sp.Publish("orders.new", "{}"))
+	assert.Equal(t, 1, e.subscriptions())
+
+	// released subject is subscribed again
+	require.NoError(t, sp.Publish("orders.0", "{}"))
+	require.Eventually(t, func() bool { return d.len() == 102 }, receiveTimeout, time.Millisecond)
+	assert.Equal(t, 2, e.subscriptions())
+	assert.Equal(t, uint64(102), e.Stats()[0].Received)
+}
+
+func TestEngineReleaseWhilePublishing(t *testing.T) {
+	const (
+		publishers = 8
+		messages   = 500
+		subjects   = 20
+	)
+
+	e, sp, d := start(t, time.Millisecond, config.TransformConfig{Name: "big", Source: "orders.*", Destination: "big"})
+
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range messages {
+				if sp.Publish("orders."+strconv.Itoa((p+i)%subjects), "{}") != nil {
+					failed.Add(1)
+				}
+				if i%50 == 0 {
+					time.Sleep(2 * time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.Zero(t, failed.Load())
+
+	// every message is taken by rule exactly once,
+	// even if its subject is released and subscribed again
+	require.Eventually(t, func() bool { return d.len() == publishers*messages }, receiveTimeout, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, publishers*messages, d.len())
+	assert.Equal(t, uint64(publishers*messages), e.Stats()[0].Received)
+}
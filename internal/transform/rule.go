@@ -0,0 +1,257 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/Kry0z1/subpub/internal/config"
+	"github.com/Kry0z1/subpub/pkg/subpub"
+
+	"github.com/itchyny/gojq"
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid transform rule")
+
+	// errNotObject is returned by projection of data which
+	// is not JSON object.
+	errNotObject = errors.New("data is not JSON object")
+)
+
+// Stats are counters of rule.
+type Stats struct {
+	Name string
+
+	// Received is number of messages from source.
+	Received uint64
+	// Filtered is number of messages rejected by filter.
+	Filtered uint64
+	// Published is number of derived messages.
+	Published uint64
+	// Failed is number of messages which couldn't be
+	// transformed or published.
+	Failed uint64
+	// Loops is number of messages dropped, because they
+	// were already derived by this rule.
+	Loops uint64
+}
+
+// input is what templates of rule are executed with.
+type input struct {
+	Subject string
+	// Tokens are dot-separated tokens of Subject.
+	Tokens []string
+	// Data is data decoded from JSON, or raw data if it's not JSON.
+	Data any
+	// Raw is data as it was published.
+	Raw string
+}
+
+type rule struct {
+	name   string
+	source string
+
+	// filter is nil if every message passes.
+	filter *gojq.Code
+	// either project or rewrite is set, or none for data as is
+	project [][]string
+	rewrite *template.Template
+
+	destination *template.Template
+
+	received  *atomic.Uint64
+	filtered  *atomic.Uint64
+	published *atomic.Uint64
+	failed    *atomic.Uint64
+	loops     *atomic.Uint64
+}
+
+// apply transforms raw data published to subject, returning
+// destination and derived data, or false if filter has rejected it.
+func (r *rule) apply(subject string, raw string) (string, string, bool, error) {
+	in := input{
+		Subject: subject,
+		Tokens:  strings.Split(subject, subpub.TokenSeparator),
+		Data:    raw,
+		Raw:     raw,
+	}
+	var decoded any
+	if json.Unmarshal([]byte(raw), &decoded) == nil {
+		in.Data = decoded
+	}
+
+	if r.filter != nil {
+		pass, err := r.pass(in.Data)
+		if err != nil || !pass {
+			return "", "", false, err
+		}
+	}
+
+	data := raw
+	var err error
+	switch {
+	case r.project != nil:
+		data, err = r.projection(in.Data)
+	case r.rewrite != nil:
+		data, err = execute(r.rewrite, in)
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	destination, err := execute(r.destination, in)
+	if err != nil {
+		return "", "", false, err
+	}
+	if destination == "" {
+		return "", "", false, fmt.Errorf("empty destination for %q", subject)
+	}
+	return destination, data, true, nil
+}
+
+// pass runs filter, which passes message
+// if its first result is neither false nor null.
+func (r *rule) pass(data any) (bool, error) {
+	result, ok := r.filter.Run(data).Next()
+	if !ok {
+		return false, nil
+	}
+	if err, ok := result.(error); ok {
+		return false, err
+	}
+	return result != nil && result != false, nil
+}
+
+// projection keeps only projected fields of data, fields missing
+// in data are skipped.
+func (r *rule) projection(data any) (string, error) {
+	object, ok := data.(map[string]any)
+	if !ok {
+		return "", errNotObject
+	}
+
+	projected := map[string]any{}
+	for _, path := range r.project {
+		value, ok := lookup(object, path)
+		if !ok {
+			continue
+		}
+		// nested fields keep their nesting
+		target := projected
+		for _, field := range path[:len(path)-1] {
+			next, ok := target[field].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				target[field] = next
+			}
+			target = next
+		}
+		target[path[len(path)-1]] = value
+	}
+
+	result, err := json.Marshal(projected)
+	return string(result), err
+}
+
+func lookup(object map[string]any, path []string) (any, bool) {
+	var value any = object
+	for _, field := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[field]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func (r *rule) stats() Stats {
+	return Stats{
+		Name:      r.name,
+		Received:  r.received.Load(),
+		Filtered:  r.filtered.Load(),
+		Published: r.published.Load(),
+		Failed:    r.failed.Load(),
+		Loops:     r.loops.Load(),
+	}
+}
+
+func execute(tmpl *template.Template, in input) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, in); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func compileFilter(filter string) (*gojq.Code, error) {
+	query, err := gojq.Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query)
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func newRule(cfg config.TransformConfig) (*rule, error) {
+	if cfg.Name == "" || strings.Contains(cfg.Name, trailSeparator) {
+		return nil, fmt.Errorf("%w: name must be non-empty and have no %q", ErrInvalidRule, trailSeparator)
+	}
+	if err := subpub.ValidatePattern(cfg.Source); err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidRule, cfg.Name, err)
+	}
+	if cfg.Project != nil && cfg.Template != "" {
+		return nil, fmt.Errorf("%w %q: both project and template are set", ErrInvalidRule, cfg.Name)
+	}
+	if cfg.Destination == "" {
+		return nil, fmt.Errorf("%w %q: destination is not set", ErrInvalidRule, cfg.Name)
+	}
+
+	r := &rule{
+		name:   cfg.Name,
+		source: cfg.Source,
+
+		received:  &atomic.Uint64{},
+		filtered:  &atomic.Uint64{},
+		published: &atomic.Uint64{},
+		failed:    &atomic.Uint64{},
+		loops:     &atomic.Uint64{},
+	}
+
+	var err error
+	if cfg.Filter != "" {
+		if r.filter, err = compileFilter(cfg.Filter); err != nil {
+			return nil, fmt.Errorf("%w %q: filter: %w", ErrInvalidRule, cfg.Name, err)
+		}
+	}
+	for _, field := range cfg.Project {
+		path := strings.Split(field, ".")
+		if slices.Contains(path, "") {
+			return nil, fmt.Errorf("%w %q: invalid project field %q", ErrInvalidRule, cfg.Name, field)
+		}
+		r.project = append(r.project, path)
+	}
+	if cfg.Template != "" {
+		if r.rewrite, err = template.New("template").Funcs(funcs).Option("missingkey=error").Parse(cfg.Template); err != nil {
+			return nil, fmt.Errorf("%w %q: template: %w", ErrInvalidRule, cfg.Name, err)
+		}
+	}
+	if r.destination, err = template.New("destination").Funcs(funcs).Option("missingkey=error").Parse(cfg.Destination); err != nil {
+		return nil, fmt.Errorf("%w %q: destination: %w", ErrInvalidRule, cfg.Name, err)
+	}
+	return r, nil
+}
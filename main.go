@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/Kry0z1/subpub/internal/app"
+	"github.com/Kry0z1/subpub/internal/cli"
 	"github.com/Kry0z1/subpub/internal/config"
 	"github.com/Kry0z1/subpub/internal/logger/slogpretty"
 )
@@ -18,12 +19,21 @@ var (
 )
 
 func main() {
+	// admin commands talk to running server
+	if cli.IsCommand(os.Args[1:]) {
+		if err := cli.Run(os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg := config.MustLoad()
 	fmt.Println(cfg)
 
 	logger := setupLogger(cfg.Env)
 
-	application := app.New(logger, cfg.GRPC.Port, cfg.GRPC.Timeout)
+	application := app.New(logger, cfg.GRPC.Port, cfg.GRPC.Timeout, cfg.GRPC.MaxSnapshotSize, cfg.SubPub)
 
 	go func() {
 		application.GRPCServer.MustRun()
@@ -0,0 +1,177 @@
+package subpub
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAckUnsupported is returned by Subscribe, SubscribeContext and
+// group subscriptions given WithAck: their handlers can't ack
+// messages, so they ack them by returning.
+var ErrAckUnsupported = errors.New("ack mode is not supported by subscription")
+
+// acker acknowledges one delivery of message.
+type acker struct {
+	sub *subscription
+	id  uint64
+}
+
+// unacked is message delivered in ack mode and not acked yet.
+type unacked struct {
+	env envelope
+	// sent is when delivery has started.
+	sent time.Time
+	// timer is nil until message is passed to channel.
+	timer *time.Timer
+}
+
+// Ack acknowledges message delivered by subscription in ack mode,
+// see WithAck, so that it's never redelivered.
+//
+// Returns false if message is not awaiting ack: it's acked already,
+// its ack wait has run out and it's going to be redelivered,
+// subscription is closed or is not in ack mode.
+func (m Message) Ack() bool {
+	if m.ack == nil {
+		return false
+	}
+	return m.ack.sub.ackMessage(m.ack.id)
+}
+
+// AwaitsAck reports whether message can still be acked.
+func (m Message) AwaitsAck() bool {
+	if m.ack == nil {
+		return false
+	}
+	s := m.ack.sub
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	_, ok := s.unacked[m.ack.id]
+	return ok
+}
+
+// acking reports whether messages of subscription must be acked.
+func (s *subscription) acking() bool {
+	return s.cfg.ackWait > 0 && (s.handler.sink != nil || s.handler.batch != nil)
+}
+
+// await registers message as awaiting ack,
+// returning msg which acks it.
+func (s *subscription) await(message envelope, msg Message) Message {
+	message.deliveries++
+
+	s.mut.Lock()
+	s.ackSeq++
+	id := s.ackSeq
+	s.unacked[id] = &unacked{env: message, sent: s.b.sys.clock.Now()}
+	s.mut.Unlock()
+
+	msg.ack = &acker{sub: s, id: id}
+	msg.Delivery = message.deliveries
+	return msg
+}
+
+// awaitAck starts ack wait of message once delivery has
+// finished with status, or forgets message which wasn't delivered.
+// Ack wait of batched message starts once batch handler returns.
+//
+// Returns DeliveryPending for delivered message, its outcome
+// is known once it's acked or dead-lettered.
+func (s *subscription) awaitAck(id uint64, status DeliveryStatus) DeliveryStatus {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	u, ok := s.unacked[id]
+	if status != DeliveryDone {
+		delete(s.unacked, id)
+		return status
+	}
+	// already acked
+	if !ok {
+		return DeliveryPending
+	}
+
+	u.timer = time.AfterFunc(s.cfg.ackWait, func() {
+		s.ackTimeout(id)
+	})
+	return DeliveryPending
+}
+
+// ackMessage finishes delivery of acked message.
+func (s *subscription) ackMessage(id uint64) bool {
+	u, ok := s.forget(id)
+	if !ok {
+		return false
+	}
+
+	s.delivered.Add(1)
+	s.b.sys.metrics.MessageDelivered(s.b.subject, s.b.sys.clock.Now().Sub(u.sent))
+	u.env.tracker.finish(s.id, DeliveryDone)
+	return true
+}
+
+// ackTimeout redelivers message which wasn't acked in time,
+// or sends it to dead letters once it's delivered max times.
+func (s *subscription) ackTimeout(id uint64) {
+	u, ok := s.forget(id)
+	if !ok {
+		return
+	}
+
+	if s.cfg.maxDeliver > 0 && u.env.deliveries >= s.cfg.maxDeliver {
+		s.notAcked.Add(1)
+		s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryNotAcked)
+		u.env.tracker.finish(s.id, DeliveryNotAcked)
+		s.deadLetter(u.env, DeliveryNotAcked)
+		return
+	}
+
+	s.redelivered.Add(1)
+	ok, dropped := s.enqueue(u.env)
+	if !ok {
+		s.dropAll([]envelope{u.env})
+		s.dropped.Add(1)
+	}
+	s.overflow(dropped)
+}
+
+// unawait forgets message evicted from channel, which is
+// counted as dropped by caller and can't be acked anymore.
+func (s *subscription) unawait(msg Message) {
+	if msg.ack == nil {
+		return
+	}
+	if u, ok := s.forget(msg.ack.id); ok {
+		u.env.tracker.finish(s.id, DeliveryDropped)
+	}
+}
+
+// forget removes message from awaiting ack.
+func (s *subscription) forget(id uint64) (*unacked, bool) {
+	s.mut.Lock()
+	u, ok := s.unacked[id]
+	delete(s.unacked, id)
+	s.mut.Unlock()
+
+	if ok && u.timer != nil {
+		u.timer.Stop()
+	}
+	return u, ok
+}
+
+// dropUnacked drops messages awaiting ack once subscription is closed.
+func (s *subscription) dropUnacked() {
+	s.mut.Lock()
+	ids := make([]uint64, 0, len(s.unacked))
+	for id := range s.unacked {
+		ids = append(ids, id)
+	}
+	s.mut.Unlock()
+
+	for _, id := range ids {
+		if u, ok := s.forget(id); ok {
+			s.dropped.Add(1)
+			s.dropAll([]envelope{u.env})
+		}
+	}
+}
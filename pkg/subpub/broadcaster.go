@@ -5,91 +5,234 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var ErrBroadcasterClosed = errors.New("broadcaster is closed")
 
 type broadcaster struct {
-	// May be sync.Map is better here, depends on use cases
-	mut           *sync.RWMutex
-	subscriptions map[int64]*subscription
+	subject string
 
-	maxSubscriptionID *atomic.Int64
-	closed            bool
+	// ttl is default TTL of messages on subject.
+	ttl time.Duration
+
+	sys *system
+
+	published *atomic.Uint64
+
+	// mut serializes writers of subscriptions
+	// and lifecycle transitions, Publish never takes it.
+	mut *sync.Mutex
+
+	// subscriptions is an immutable snapshot of current subscribers.
+	// Every RegisterSub/UnregisterSub builds a new slice and swaps
+	// the pointer (copy-on-write), so publishers iterate it lock-free.
+	subscriptions *atomic.Pointer[[]*subscription]
+
+	state *lifecycle
 }
 
 // Close stops broadcaster and unsubscribes all of its subscribers.
 //
-// On success returns nil.
+// On success returns nil and broadcaster becomes StateClosed.
 //
-// If ctx is closed before close, broadcaster will not be marked as closed.
-// Otherwise, broadcaster will be marked close and no subsequent messages
-// on the topic will be delivered.
+// If ctx is closed before close, broadcaster will not be touched.
+// Otherwise, broadcaster will be marked as draining, no subsequent
+// messages and subscribers on the topic will be accepted.
 //
-// After context closing at most 1 subscriber will be stopped.
+// After context closing at most 1 subscriber will be stopped,
+// broadcaster stays draining and the next Close call
+// continues with the remaining subscribers.
 //
 // On closed context returns ctx.Err().
 func (b *broadcaster) Close(ctx context.Context) error {
-	b.mut.Lock()
-	defer b.mut.Unlock()
-
 	// if context is done already don't close broadcaster
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	if b.closed {
+	b.mut.Lock()
+	b.state.Transition(StateOpen, StateDraining)
+	subs := *b.subscriptions.Load()
+	b.mut.Unlock()
+
+	if b.state.Load() == StateClosed {
 		return nil
 	}
-	b.closed = true
-	for _, sub := range b.subscriptions {
+
+	// subscriptions are stopped, but stay registered
+	// until the whole topic is closed, so that
+	// shutdown report can still see them
+	for _, sub := range subs {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
-		sub.UnsubscribeNoLock()
+		sub.stop()
 	}
 
+	b.mut.Lock()
+	closed := b.state.Transition(StateDraining, StateClosed)
+	// the last subscriber may have left meanwhile,
+	// then topic is already removed
+	removed := closed && len(*b.subscriptions.Load()) > 0
+	if closed {
+		b.subscriptions.Store(&[]*subscription{})
+	}
+	b.mut.Unlock()
+
+	if removed {
+		b.sys.emit(SysEvent{Type: SysTopicRemoved, Subject: b.subject})
+	}
 	return nil
 }
 
-func (b *broadcaster) Publish(message interface{}) error {
-	if b.closed {
-		return ErrBroadcasterClosed
+// abort closes broadcaster without waiting for subscribers:
+// their queues are dropped and running handlers are detached.
+//
+// Returns reports of subscriptions which lost anything.
+func (b *broadcaster) abort() []SubscriptionReport {
+	b.mut.Lock()
+	b.state.Transition(StateOpen, StateDraining)
+	subs := *b.subscriptions.Load()
+	b.subscriptions.Store(&[]*subscription{})
+	closed := b.state.Transition(StateDraining, StateClosed)
+	b.mut.Unlock()
+
+	if closed && len(subs) > 0 {
+		b.sys.emit(SysEvent{Type: SysTopicRemoved, Subject: b.subject})
 	}
 
-	b.mut.RLock()
-	defer b.mut.RUnlock()
-	for _, sub := range b.subscriptions {
-		sub.receiver <- message
+	var reports []SubscriptionReport
+	for _, sub := range subs {
+		if report := sub.abort(); !report.Clean() {
+			reports = append(reports, report)
+		}
 	}
+	return reports
+}
 
-	return nil
+// report returns reports of subscriptions
+// which still have something to do.
+func (b *broadcaster) report() []SubscriptionReport {
+	var reports []SubscriptionReport
+	for _, sub := range *b.subscriptions.Load() {
+		if sub.state.Load() == StateClosed {
+			continue
+		}
+		if report := sub.report(); !report.Clean() {
+			reports = append(reports, report)
+		}
+	}
+	return reports
 }
 
-func (b *broadcaster) RegisterSub(sub *subscription, id int64) {
+// Publish puts message into queue of every subscriber
+// from the current snapshot.
+//
+// Never blocks on a subscriber: enqueueing only takes
+// short per-subscription lock.
+//
+// If tracked is true, returns tracker of message delivery
+// to every subscriber from the snapshot. Also returns messages
+// dropped by overflow, which caller must pass to overflow once
+// mut of system is released.
+func (b *broadcaster) Publish(message interface{}, cfg publishConfig, tracked bool) (*deliveryTracker, []overflowed, error) {
+	if b.state.Load() != StateOpen {
+		return nil, nil, ErrBroadcasterClosed
+	}
+
+	subs := *b.subscriptions.Load()
+	b.published.Add(1)
+	b.sys.metrics.MessagePublished(b.subject)
+
+	env := b.envelope(message, cfg)
+	if tracked {
+		env.tracker = newDeliveryTracker(subs)
+	}
+
+	var dropped []overflowed
+	for _, sub := range subs {
+		ok, evicted := sub.enqueue(env)
+		if !ok {
+			env.tracker.finish(sub.id, DeliveryDropped)
+		}
+		if evicted != nil {
+			dropped = append(dropped, overflowed{sub: sub, env: *evicted})
+		}
+	}
+
+	return env.tracker, dropped, nil
+}
+
+// RegisterSub adds sub to the subscribers.
+//
+// Returns number of subscribers including sub,
+// or ErrBroadcasterClosed if broadcaster is not open.
+func (b *broadcaster) RegisterSub(sub *subscription) (int, error) {
 	b.mut.Lock()
-	b.subscriptions[id] = sub
-	b.mut.Unlock()
+	defer b.mut.Unlock()
+
+	if b.state.Load() != StateOpen {
+		return 0, ErrBroadcasterClosed
+	}
+
+	old := *b.subscriptions.Load()
+	subs := make([]*subscription, len(old), len(old)+1)
+	copy(subs, old)
+	subs = append(subs, sub)
+	b.subscriptions.Store(&subs)
+	return len(subs), nil
 }
 
+// UnregisterSub removes subscription from subscribers
+// and emits SysSubscriberLeft, and SysTopicRemoved
+// if it was the last one.
 func (b *broadcaster) UnregisterSub(id int64) {
 	b.mut.Lock()
-	delete(b.subscriptions, id)
+	old := *b.subscriptions.Load()
+	subs := make([]*subscription, 0, len(old))
+	for _, sub := range old {
+		if sub.id != id {
+			subs = append(subs, sub)
+		}
+	}
+	b.subscriptions.Store(&subs)
 	b.mut.Unlock()
+
+	if len(subs) < len(old) {
+		b.sys.emit(SysEvent{Type: SysSubscriberLeft, Subject: b.subject, SubscriptionID: id, Subscribers: len(subs)})
+		if len(subs) == 0 {
+			b.sys.emit(SysEvent{Type: SysTopicRemoved, Subject: b.subject})
+		}
+	}
 }
 
-func (b *broadcaster) UnregisterSubNoLock(id int64) {
-	delete(b.subscriptions, id)
+// envelope wraps message for subscription queues.
+func (b *broadcaster) envelope(message interface{}, cfg publishConfig) envelope {
+	ttl := cfg.ttl
+	if ttl == 0 {
+		ttl = b.ttl
+	}
+	env := newEnvelope(message, cfg.headers, ttl, b.sys.clock)
+	env.priority = cfg.priority
+	return env
 }
 
 func (b *broadcaster) GetNextId() int64 {
-	return b.maxSubscriptionID.Add(1)
+	return b.sys.nextID()
 }
 
-func newBroadcaster() broadcaster {
-	return broadcaster{
-		mut:               &sync.RWMutex{},
-		subscriptions:     make(map[int64]*subscription),
-		maxSubscriptionID: &atomic.Int64{},
+func newBroadcaster(subject string, ttl time.Duration, sys *system) broadcaster {
+	b := broadcaster{
+		subject:   subject,
+		ttl:       ttl,
+		sys:       sys,
+		published: &atomic.Uint64{},
+
+		mut:           &sync.Mutex{},
+		subscriptions: &atomic.Pointer[[]*subscription]{},
+		state:         &lifecycle{},
 	}
+	b.subscriptions.Store(&[]*subscription{})
+	return b
 }
@@ -0,0 +1,134 @@
+package subpub
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultChanBuffer is buffer size of channel
+// made by SubscribeChan unless configured otherwise.
+const DefaultChanBuffer = 64
+
+// Overflow is what subscription does with message
+// when there is no room for it.
+type Overflow int
+
+const (
+	// OverflowBlock waits for room, while newer messages
+	// stay in subscription queue.
+	OverflowBlock Overflow = iota
+	// OverflowDropNewest drops message which doesn't fit.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest message
+	// to make room for the newer one.
+	OverflowDropOldest
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop newest"
+	case OverflowDropOldest:
+		return "drop oldest"
+	default:
+		return "unknown"
+	}
+}
+
+// chanSink delivers messages of subscription made by SubscribeChan.
+//
+// Only processor sends to ch, so it may both send and evict
+// without racing with other senders.
+type chanSink struct {
+	ch       chan Message
+	overflow Overflow
+
+	// abandoned is closed on Unsubscribe,
+	// so that blocked send doesn't hold processor.
+	abandoned chan struct{}
+	once      sync.Once
+}
+
+// send passes msg to channel according to overflow policy.
+//
+// Returns whether msg was sent and message evicted
+// from channel to make room for it, if any.
+func (c *chanSink) send(ctx context.Context, msg Message) (bool, *Message) {
+	select {
+	case <-c.abandoned:
+		return false, nil
+	default:
+	}
+
+	switch c.overflow {
+	case OverflowDropNewest:
+		select {
+		case c.ch <- msg:
+			return true, nil
+		default:
+			return false, nil
+		}
+	case OverflowDropOldest:
+		var evicted *Message
+		for {
+			select {
+			case c.ch <- msg:
+				return true, evicted
+			default:
+			}
+			if cap(c.ch) == 0 {
+				return false, nil
+			}
+			// consumer may take the oldest one first,
+			// then there is room already
+			select {
+			case old := <-c.ch:
+				evicted = &old
+			default:
+			}
+		}
+	default:
+		select {
+		case c.ch <- msg:
+			return true, nil
+		case <-c.abandoned:
+			return false, nil
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+}
+
+// abandon makes all pending and future sends fail.
+func (c *chanSink) abandon() {
+	c.once.Do(func() {
+		close(c.abandoned)
+	})
+}
+
+func newChanSink(cfg subscribeConfig) *chanSink {
+	return &chanSink{
+		ch:        make(chan Message, cfg.chanBuffer),
+		overflow:  cfg.chanOverflow,
+		abandoned: make(chan struct{}),
+	}
+}
+
+// SubscribeChan subscribes to subject delivering messages to returned
+// channel instead of handler. Channel is configured by WithChanBuffer.
+//
+// Channel is closed once subscription is closed, either by
+// Unsubscribe or by closing the system. Messages still queued on
+// Unsubscribe are dropped, so it doesn't wait for consumer;
+// closing the system does wait for them to be taken from channel.
+func (s *subpub) SubscribeChan(subject string, opts ...SubscribeOption) (<-chan Message, Subscription, error) {
+	cfg := newSubscribeConfig(s.cfg.subscribeDefaults, opts)
+	sink := newChanSink(cfg)
+	sub, err := s.subscribe(subject, handler{sink: sink}, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sink.ch, sub, nil
+}
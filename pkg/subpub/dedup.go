@@ -0,0 +1,99 @@
+package subpub
+
+import (
+	"sync"
+	"time"
+)
+
+// deduplicator remembers idempotency keys of published messages
+// per subject for the duration of subject's dedup window.
+type deduplicator struct {
+	mut      *sync.Mutex
+	subjects map[string]*dedupWindow
+
+	// window returns dedup window of subject.
+	window func(subject string) time.Duration
+	clock  Clock
+}
+
+type dedupWindow struct {
+	// seen maps key to the moment it's forgotten.
+	seen map[string]time.Time
+
+	// order holds keys in order of recording. Window is the same
+	// for all keys of subject, so it's also order of expiration.
+	order []dedupEntry
+}
+
+type dedupEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// Record remembers key for subject.
+//
+// Returns false if key is already remembered,
+// meaning message is a duplicate.
+func (d *deduplicator) Record(subject, key string) bool {
+	window := d.window(subject)
+	if window <= 0 {
+		return true
+	}
+
+	now := d.clock.Now()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	w, ok := d.subjects[subject]
+	if !ok {
+		w = &dedupWindow{seen: make(map[string]time.Time)}
+		d.subjects[subject] = w
+	}
+	w.expire(now)
+
+	if _, ok := w.seen[key]; ok {
+		return false
+	}
+
+	expiresAt := now.Add(window)
+	w.seen[key] = expiresAt
+	w.order = append(w.order, dedupEntry{key: key, expiresAt: expiresAt})
+	return true
+}
+
+// Forget removes key, so that message with it
+// may be published again.
+func (d *deduplicator) Forget(subject, key string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if w, ok := d.subjects[subject]; ok {
+		delete(w.seen, key)
+	}
+}
+
+// expire drops keys whose window is over.
+func (w *dedupWindow) expire(now time.Time) {
+	i := 0
+	for ; i < len(w.order) && !w.order[i].expiresAt.After(now); i++ {
+		entry := w.order[i]
+		// key may have been forgotten and recorded again
+		if w.seen[entry.key].Equal(entry.expiresAt) {
+			delete(w.seen, entry.key)
+		}
+	}
+	w.order = w.order[i:]
+	if len(w.order) == 0 {
+		w.order = nil
+	}
+}
+
+func newDeduplicator(window func(subject string) time.Duration, clock Clock) *deduplicator {
+	return &deduplicator{
+		mut:      &sync.Mutex{},
+		subjects: make(map[string]*dedupWindow),
+		window:   window,
+		clock:    clock,
+	}
+}
@@ -0,0 +1,260 @@
+package subpub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeliveryStatus is the outcome of message delivery to one subscriber.
+type DeliveryStatus int
+
+const (
+	// DeliveryPending means handler hasn't finished with message yet.
+	DeliveryPending DeliveryStatus = iota
+	// DeliveryDone means handler has finished with message.
+	DeliveryDone
+	// DeliveryDropped means message never reached handler,
+	// because subscription was closed or aborted.
+	DeliveryDropped
+	// DeliveryExpired means message never reached handler,
+	// because its TTL ran out in subscription queue.
+	DeliveryExpired
+	// DeliveryConflated means message never reached handler,
+	// because newer message with the same key replaced it.
+	DeliveryConflated
+	// DeliveryFiltered means message never reached handler,
+	// because delivery interceptor has short-circuited.
+	DeliveryFiltered
+	// DeliveryNotAcked means message was delivered max times
+	// in ack mode, but was never acked, see WithAck.
+	DeliveryNotAcked
+)
+
+func (s DeliveryStatus) String() string {
+	switch s {
+	case DeliveryPending:
+		return "pending"
+	case DeliveryDone:
+		return "done"
+	case DeliveryDropped:
+		return "dropped"
+	case DeliveryExpired:
+		return "expired"
+	case DeliveryConflated:
+		return "conflated"
+	case DeliveryFiltered:
+		return "filtered"
+	case DeliveryNotAcked:
+		return "not_acked"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes status as its name, so that
+// dead letters encoded as JSON are readable.
+func (s DeliveryStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *DeliveryStatus) UnmarshalText(text []byte) error {
+	for status := DeliveryPending; status <= DeliveryNotAcked; status++ {
+		if status.String() == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown delivery status %q", text)
+}
+
+type DeliveryOutcome struct {
+	SubscriptionID int64
+	Status         DeliveryStatus
+}
+
+// DeliveryReport holds outcomes for every subscriber
+// which was present at the moment of publishing.
+type DeliveryReport struct {
+	Subject string
+
+	// Duplicate is true if message was not published, because
+	// message with the same idempotency key was published already.
+	Duplicate bool
+
+	// Outcomes are sorted by SubscriptionID.
+	Outcomes []DeliveryOutcome
+}
+
+// Delivered returns number of subscribers whose handler
+// has finished with message.
+func (r DeliveryReport) Delivered() int {
+	var delivered int
+	for _, outcome := range r.Outcomes {
+		if outcome.Status == DeliveryDone {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// DeadLetter is published to dead-letter subject
+// (see WithDeadLetter) for every message skipped by subscription.
+type DeadLetter struct {
+	Subject        string
+	SubscriptionID int64
+	Msg            interface{}
+
+	// Reason is the final status of delivery.
+	Reason DeliveryStatus
+
+	PublishedAt time.Time
+	ExpiresAt   time.Time
+}
+
+// envelope is an element of subscription queue.
+type envelope struct {
+	payload interface{}
+	headers map[string]string
+
+	// publishedAt and expiresAt are set
+	// only for messages with TTL.
+	publishedAt time.Time
+	expiresAt   time.Time
+
+	priority Priority
+
+	// queuedAt is set on enqueue only if watchdog is enabled.
+	queuedAt time.Time
+
+	// key is conflation key, set per subscription on enqueue.
+	key string
+
+	// tracker is nil unless publisher waits for delivery.
+	tracker *deliveryTracker
+
+	// deliveries is number of times message was passed
+	// to subscription in ack mode.
+	deliveries int
+}
+
+func newEnvelope(message interface{}, headers map[string]string, ttl time.Duration, clock Clock) envelope {
+	env := envelope{payload: message, headers: headers}
+	if ttl > 0 {
+		env.publishedAt = clock.Now()
+		env.expiresAt = env.publishedAt.Add(ttl)
+	}
+	return env
+}
+
+// expired reports whether message TTL has run out by now.
+func (e envelope) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// deliveryTracker collects outcomes of one message
+// over all subscribers it was published to.
+type deliveryTracker struct {
+	mut      *sync.Mutex
+	outcomes map[int64]DeliveryStatus
+	left     int
+	done     chan struct{}
+}
+
+// finish records final status for subscription.
+// Nil tracker is a no-op, so callers don't have to check.
+func (t *deliveryTracker) finish(id int64, status DeliveryStatus) {
+	if t == nil {
+		return
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.outcomes[id] != DeliveryPending {
+		return
+	}
+	t.outcomes[id] = status
+	t.left--
+	if t.left == 0 {
+		close(t.done)
+	}
+}
+
+// wait blocks until every subscriber has final status or ctx is done.
+func (t *deliveryTracker) wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *deliveryTracker) report(subject string) DeliveryReport {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	report := DeliveryReport{
+		Subject:  subject,
+		Outcomes: make([]DeliveryOutcome, 0, len(t.outcomes)),
+	}
+	for id, status := range t.outcomes {
+		report.Outcomes = append(report.Outcomes, DeliveryOutcome{SubscriptionID: id, Status: status})
+	}
+	sort.Slice(report.Outcomes, func(i, j int) bool {
+		return report.Outcomes[i].SubscriptionID < report.Outcomes[j].SubscriptionID
+	})
+	return report
+}
+
+func newDeliveryTracker(subs []*subscription) *deliveryTracker {
+	t := &deliveryTracker{
+		mut:      &sync.Mutex{},
+		outcomes: make(map[int64]DeliveryStatus, len(subs)),
+		left:     len(subs),
+		done:     make(chan struct{}),
+	}
+	for _, sub := range subs {
+		t.outcomes[sub.id] = DeliveryPending
+	}
+	if t.left == 0 {
+		close(t.done)
+	}
+	return t
+}
+
+// PublishAndWait publishes msg and waits until handler of every
+// current subscriber of subject has finished with it.
+//
+// Returns outcome per subscriber. If ctx is done first,
+// returns outcomes known so far along with ctx.Err(),
+// message itself stays published.
+//
+// Errors on publishing are the same as for Publish.
+func (s *subpub) PublishAndWait(ctx context.Context, subject string, msg interface{}, opts ...PublishOption) (DeliveryReport, error) {
+	var tracker *deliveryTracker
+	var duplicate bool
+	err := s.intercept(ctx, subject, msg, newPublishConfig(opts), func(mapped string, msg interface{}, cfg publishConfig) error {
+		// report is for subject where message has gone
+		subject = mapped
+		var err error
+		tracker, err = s.publish("publish", subject, msg, cfg, true)
+		if err == errDuplicate {
+			duplicate = true
+			return nil
+		}
+		return err
+	}, s.send)
+	if duplicate && err == nil {
+		return DeliveryReport{Subject: subject, Duplicate: true}, nil
+	}
+	if err != nil || tracker == nil {
+		return DeliveryReport{Subject: subject}, err
+	}
+
+	err = tracker.wait(ctx)
+	return tracker.report(subject), err
+}
@@ -1,27 +1,145 @@
 package subpub
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+// Message is a published message along with its metadata.
+type Message struct {
+	Subject string
+	Data    interface{}
+
+	// Headers are set by publisher with WithHeaders.
+	// Must not be modified by receivers.
+	Headers map[string]string
+
+	// Delivery is number of attempt to deliver message
+	// by subscription in ack mode starting from 1, zero otherwise.
+	Delivery int
+
+	// ack is nil unless message awaits Ack.
+	ack *acker
+}
 
 // MessageHandler is a callback function that processes messages delivered to subscribers.
 type MessageHandler func(msg interface{})
 
+// ContextMessageHandler is a MessageHandler which also receives context
+// of subscription. Context is cancelled once subscription is closed,
+// or right away on forced shutdown.
+type ContextMessageHandler func(ctx context.Context, msg interface{})
+
+// BatchHandler processes messages delivered to subscriber in batches,
+// see WithBatching.
+type BatchHandler func(msgs []Message)
+
 type Subscription interface {
 	// Unsubscribe will remove interest in the current subject subscription is for.
 	Unsubscribe()
+
+	// Pause stops delivery of messages without unsubscribing,
+	// messages are queued until Resume.
+	Pause()
+
+	// Resume delivers messages queued while paused and resumes delivery.
+	Resume()
+
+	// Err returns why subscription was closed by system,
+	// e.g. ErrSlowConsumer, nil otherwise.
+	Err() error
+}
+
+// Tx stages messages to several subjects
+// and publishes them all at once.
+type Tx interface {
+	// Publish stages msg to be published to subject on Commit.
+	Publish(subject string, msg interface{}, opts ...PublishOption) error
+
+	// Commit publishes all staged messages atomically:
+	// no subscriber sees any of them before all are queued.
+	Commit() error
+
+	// Rollback discards staged messages.
+	Rollback()
 }
 
 type SubPub interface {
 	// Subscribe creates an asynchronous queue subscriber on the given subject.
-	Subscribe(subject string, cb MessageHandler) (Subscription, error)
+	Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// SubscribeContext is Subscribe with context-aware handler.
+	SubscribeContext(subject string, cb ContextMessageHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// SubscribeBatch is Subscribe with handler receiving messages in batches.
+	SubscribeBatch(subject string, cb BatchHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// SubscribeChan is Subscribe delivering messages to returned channel,
+	// which is closed once subscription is closed.
+	SubscribeChan(subject string, opts ...SubscribeOption) (<-chan Message, Subscription, error)
+
+	// SubscribeGroup joins consumer group of partitioned subject,
+	// partitions are processed in order by members of group.
+	SubscribeGroup(subject, group string, cb GroupHandler, opts ...SubscribeOption) (Subscription, error)
+
+	// SubscribeGroupChan is SubscribeGroup delivering messages
+	// to returned channel, which is closed once member has left.
+	SubscribeGroupChan(subject, group string, opts ...SubscribeOption) (<-chan GroupMessage, Subscription, error)
 
 	// Publish publishes the msg argument to the given subject.
-	Publish(subject string, msg interface{}) error
+	Publish(subject string, msg interface{}, opts ...PublishOption) error
+
+	// PublishContext is Publish passing ctx to publish interceptors.
+	PublishContext(ctx context.Context, subject string, msg interface{}, opts ...PublishOption) error
+
+	// PublishAndWait publishes msg and waits until every current
+	// subscriber's handler has finished with it or ctx is done.
+	PublishAndWait(ctx context.Context, subject string, msg interface{}, opts ...PublishOption) (DeliveryReport, error)
+
+	// SubjectMapping returns current mapping of subjects,
+	// nil if subjects are not mapped.
+	SubjectMapping() *SubjectMapping
+
+	// SetSubjectMapping replaces mapping of subjects
+	// of published messages, nil disables it.
+	SetSubjectMapping(mapping *SubjectMapping)
+
+	// Begin starts transaction to publish to several subjects atomically.
+	Begin() Tx
+
+	// PublishAt schedules msg to be published at given time.
+	// Returns ID of scheduled message.
+	PublishAt(subject string, msg interface{}, at time.Time, opts ...PublishOption) (uint64, error)
+
+	// PublishAfter schedules msg to be published after delay.
+	// Returns ID of scheduled message.
+	PublishAfter(subject string, msg interface{}, delay time.Duration, opts ...PublishOption) (uint64, error)
+
+	// Scheduled lists messages waiting for their time.
+	Scheduled() []ScheduledMessage
+
+	// CancelScheduled removes scheduled message by its ID.
+	CancelScheduled(id uint64) error
+
+	// Stats returns counters of every subject and subscription.
+	Stats() Stats
+
+	// Snapshot captures state of system to be restored elsewhere.
+	Snapshot() Snapshot
+
+	// Restore loads snapshot into fresh system.
+	Restore(snapshot Snapshot) error
 
 	// Close will shutdown sub-pub system.
 	// May be blocked by data delivery until the context is canceled.
 	Close(ctx context.Context) error
+
+	// Shutdown does the same as Close, but reports undelivered messages
+	// and running handlers if ctx is done first.
+	// With WithHardStop abandons them and closes system right away.
+	Shutdown(ctx context.Context, opts ...ShutdownOption) (ShutdownReport, error)
 }
 
-func NewSubPub() SubPub {
-	return newSubPub()
+func NewSubPub(opts ...Option) SubPub {
+	return newSubPub(opts...)
 }
@@ -0,0 +1,89 @@
+package subpub
+
+import (
+	"strings"
+	"time"
+)
+
+// Subjects of system lifecycle events, see SysEvent.
+//
+// Topic is created once it gets its first subscriber and removed
+// once it loses the last one, so it may be created again later.
+//
+// Events are published as any other message while system is open,
+// so topic.removed of topics closed with the system and close.finished
+// reach only Hooks. No events are emitted for $SYS subjects themselves.
+const (
+	SysPrefix = "$SYS."
+
+	SysTopicCreated     = "$SYS.topic.created"
+	SysTopicRemoved     = "$SYS.topic.removed"
+	SysSubscriberJoined = "$SYS.subscriber.joined"
+	SysSubscriberLeft   = "$SYS.subscriber.left"
+	SysCloseStarted     = "$SYS.close.started"
+	SysCloseFinished    = "$SYS.close.finished"
+)
+
+// SysEvent is published to $SYS subject on lifecycle changes.
+type SysEvent struct {
+	// Type is $SYS subject of event.
+	Type string
+
+	// Subject is topic of event, empty for close events.
+	Subject string
+
+	// SubscriptionID is set for subscriber events.
+	SubscriptionID int64
+
+	// Subscribers is number of subscribers of topic
+	// right after subscriber event.
+	Subscribers int
+
+	Time time.Time
+}
+
+// Hooks are called on lifecycle events synchronously, before
+// event is published, so they must be fast and must not call
+// Close or Unsubscribe. Nil hooks are skipped.
+type Hooks struct {
+	OnTopicCreated     func(SysEvent)
+	OnTopicRemoved     func(SysEvent)
+	OnSubscriberJoined func(SysEvent)
+	OnSubscriberLeft   func(SysEvent)
+	OnCloseStarted     func(SysEvent)
+	OnCloseFinished    func(SysEvent)
+}
+
+func (h Hooks) hook(eventType string) func(SysEvent) {
+	switch eventType {
+	case SysTopicCreated:
+		return h.OnTopicCreated
+	case SysTopicRemoved:
+		return h.OnTopicRemoved
+	case SysSubscriberJoined:
+		return h.OnSubscriberJoined
+	case SysSubscriberLeft:
+		return h.OnSubscriberLeft
+	case SysCloseStarted:
+		return h.OnCloseStarted
+	case SysCloseFinished:
+		return h.OnCloseFinished
+	default:
+		return nil
+	}
+}
+
+// emit calls hook of event and publishes it.
+//
+// Must not be called with s.mut held.
+func (s *subpub) emit(event SysEvent) {
+	if strings.HasPrefix(event.Subject, SysPrefix) {
+		return
+	}
+	event.Time = s.cfg.clock.Now()
+
+	if hook := s.cfg.hooks.hook(event.Type); hook != nil {
+		hook(event)
+	}
+	s.reportErr(s.send(event.Type, event, publishConfig{}))
+}
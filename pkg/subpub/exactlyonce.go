@@ -0,0 +1,173 @@
+package subpub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MessageIDHeader is header read by ExactlyOnce for ID of message
+// unless configured otherwise, see WithIDFunc. It's never set by
+// system, so publisher passes it with WithHeaders, and ID must be the
+// same for every copy of message published again.
+const MessageIDHeader = "subpub-message-id"
+
+var (
+	// ErrNoMessageID is returned by ExactlyOnce handler
+	// for message without ID, which is never processed.
+	ErrNoMessageID = errors.New("message has no ID")
+
+	// ErrNotRecorded is wrapped by error of ExactlyOnce handler
+	// for message which was processed, but couldn't be recorded
+	// in store, so it may be processed again.
+	ErrNotRecorded = errors.New("processed message not recorded")
+)
+
+// ProcessFunc processes message, returning error if it has failed.
+type ProcessFunc func(ctx context.Context, msg Message) error
+
+// IdempotencyStore remembers IDs of processed messages for
+// ExactlyOnce, see NewMemoryStore and OpenFileStore.
+//
+// Methods are called concurrently, so they must be safe
+// for concurrent use.
+type IdempotencyStore interface {
+	// Seen reports whether id is recorded and
+	// hasn't expired by now.
+	Seen(id string, now time.Time) (bool, error)
+
+	// Record remembers id until expiresAt.
+	Record(id string, expiresAt time.Time) error
+}
+
+type exactlyOnceConfig struct {
+	id    func(Message) string
+	clock Clock
+}
+
+// ExactlyOnceOption configures ExactlyOnce.
+type ExactlyOnceOption func(*exactlyOnceConfig)
+
+// WithIDFunc sets function returning ID of message,
+// empty ID means message has none.
+//
+// Default reads MessageIDHeader.
+func WithIDFunc(id func(Message) string) ExactlyOnceOption {
+	return func(cfg *exactlyOnceConfig) {
+		cfg.id = id
+	}
+}
+
+// WithStoreClock sets clock for windows of IDs in store.
+// Default is real time.
+func WithStoreClock(clock Clock) ExactlyOnceOption {
+	return func(cfg *exactlyOnceConfig) {
+		cfg.clock = clock
+	}
+}
+
+// ExactlyOnce wraps handler so that message with the same ID
+// is processed once within window after it was processed, making
+// at-least-once delivery of WithAck effectively-once. It's meant
+// for messages of SubscribeChan and SubscribeBatch in ack mode;
+// without it Message.Ack does nothing and failed message is not
+// redelivered.
+//
+// Wrapped handler records ID of message in store only once handler
+// has succeeded and then acks it, see Message.Ack. Message which is
+// recorded already is acked right away without calling handler,
+// and nil is returned.
+//
+// Handler failing partway must return error: then ID is not recorded
+// and message is not acked, so it's redelivered and processed again
+// from the start. So effects done before failure must be idempotent
+// or undone by handler itself. Error of store also leaves message
+// unacked; if ID couldn't be recorded after handler has succeeded,
+// message is acked anyway and error wrapping ErrNotRecorded is
+// returned, as further copies may be processed again.
+//
+// Messages with the same ID are processed one at a time: concurrent
+// call waits for the running one to finish or for ctx to be done.
+// Message without ID is rejected with ErrNoMessageID.
+func ExactlyOnce(store IdempotencyStore, window time.Duration, handler ProcessFunc, opts ...ExactlyOnceOption) ProcessFunc {
+	cfg := exactlyOnceConfig{
+		id: func(msg Message) string {
+			return msg.Headers[MessageIDHeader]
+		},
+		clock: realClock{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	inflight := &inflightIDs{mut: &sync.Mutex{}, running: make(map[string]chan struct{})}
+
+	return func(ctx context.Context, msg Message) error {
+		id := cfg.id(msg)
+		if id == "" {
+			return ErrNoMessageID
+		}
+
+		if err := inflight.acquire(ctx, id); err != nil {
+			return err
+		}
+		defer inflight.release(id)
+
+		seen, err := store.Seen(id, cfg.clock.Now())
+		if err != nil {
+			return err
+		}
+		if seen {
+			msg.Ack()
+			return nil
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			return err
+		}
+
+		err = store.Record(id, cfg.clock.Now().Add(window))
+		msg.Ack()
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNotRecorded, err)
+		}
+		return nil
+	}
+}
+
+// inflightIDs are IDs of messages being processed,
+// each with channel closed once it's done.
+type inflightIDs struct {
+	mut     *sync.Mutex
+	running map[string]chan struct{}
+}
+
+// acquire waits until no message with id is processed
+// and marks id as processed.
+func (f *inflightIDs) acquire(ctx context.Context, id string) error {
+	for {
+		f.mut.Lock()
+		done, ok := f.running[id]
+		if !ok {
+			f.running[id] = make(chan struct{})
+			f.mut.Unlock()
+			return nil
+		}
+		f.mut.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *inflightIDs) release(id string) {
+	f.mut.Lock()
+	close(f.running[id])
+	delete(f.running, id)
+	f.mut.Unlock()
+}
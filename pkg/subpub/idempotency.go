@@ -0,0 +1,290 @@
+package subpub
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCorruptStore is returned by OpenFileStore for malformed file.
+var ErrCorruptStore = errors.New("corrupt idempotency store")
+
+// MemoryStore is IdempotencyStore keeping no more than
+// capacity of the most recently used IDs in memory.
+//
+// Once it's full, the least recently used ID is evicted even if
+// its window isn't over, so capacity must cover number of messages
+// processed within window.
+type MemoryStore struct {
+	mut      *sync.Mutex
+	capacity int
+	ids      map[string]*list.Element
+	// lru holds memoryEntry, the most recently used first.
+	lru *list.List
+}
+
+type memoryEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+func (m *MemoryStore) Seen(id string, now time.Time) (bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	elem, ok := m.ids[id]
+	if !ok {
+		return false, nil
+	}
+	if !elem.Value.(memoryEntry).expiresAt.After(now) {
+		m.lru.Remove(elem)
+		delete(m.ids, id)
+		return false, nil
+	}
+	m.lru.MoveToFront(elem)
+	return true, nil
+}
+
+func (m *MemoryStore) Record(id string, expiresAt time.Time) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if elem, ok := m.ids[id]; ok {
+		elem.Value = memoryEntry{id: id, expiresAt: expiresAt}
+		m.lru.MoveToFront(elem)
+		return nil
+	}
+
+	if m.lru.Len() >= m.capacity {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.ids, oldest.Value.(memoryEntry).id)
+	}
+	m.ids[id] = m.lru.PushFront(memoryEntry{id: id, expiresAt: expiresAt})
+	return nil
+}
+
+// Len returns number of remembered IDs, including expired ones
+// not evicted yet.
+func (m *MemoryStore) Len() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return m.lru.Len()
+}
+
+// NewMemoryStore creates MemoryStore, capacity is at least 1.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		mut:      &sync.Mutex{},
+		capacity: max(capacity, 1),
+		ids:      make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// FileStore is IdempotencyStore persisting IDs to file,
+// so that they survive restart of consumer.
+//
+// File is append-only log with line per recorded ID: its expiration
+// as unix nanoseconds and quoted ID, separated by space. Every record
+// is synced to disk before Record returns. Expired IDs are dropped
+// from file when it's opened and once file has grown twice since
+// the last time, so it stays within twice the IDs live in window.
+//
+// Only one FileStore may use file at a time.
+type FileStore struct {
+	mut   *sync.Mutex
+	path  string
+	clock Clock
+	file  *os.File
+	ids   map[string]time.Time
+	// lines is number of lines in file.
+	lines int
+	// compacted is number of lines left by the last compaction.
+	compacted int
+}
+
+// minCompact is number of lines in file
+// below which it's never compacted.
+const minCompact = 1024
+
+func (f *FileStore) Seen(id string, now time.Time) (bool, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	expiresAt, ok := f.ids[id]
+	if !ok {
+		return false, nil
+	}
+	if !expiresAt.After(now) {
+		// line stays in file until compaction
+		delete(f.ids, id)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (f *FileStore) Record(id string, expiresAt time.Time) error {
+	const op = "FileStore.Record"
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.file == nil {
+		return fmt.Errorf("%s: %w", op, os.ErrClosed)
+	}
+	if _, err := f.file.WriteString(formatLine(id, expiresAt)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	f.ids[id] = expiresAt
+	f.lines++
+
+	// compaction is linear in number of IDs, so doing it only
+	// once file has doubled keeps Record amortized constant
+	if f.lines >= max(minCompact, 2*f.compacted) {
+		return f.compact(f.clock.Now())
+	}
+	return nil
+}
+
+// Close closes file, store can't record IDs anymore.
+func (f *FileStore) Close() error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// compact drops IDs expired by now and rewrites file with live ones,
+// replacing it atomically. f.mut must be held.
+func (f *FileStore) compact(now time.Time) error {
+	const op = "FileStore.compact"
+
+	for id, expiresAt := range f.ids {
+		if !expiresAt.After(now) {
+			delete(f.ids, id)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".idempotency-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for id, expiresAt := range f.ids {
+		if _, err := w.WriteString(formatLine(id, expiresAt)); err != nil {
+			tmp.Close()
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	f.lines = len(f.ids)
+	f.compacted = f.lines
+	return nil
+}
+
+func formatLine(id string, expiresAt time.Time) string {
+	return strconv.FormatInt(expiresAt.UnixNano(), 10) + " " + strconv.Quote(id) + "\n"
+}
+
+func parseLine(line string) (string, time.Time, error) {
+	nanos, quoted, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("%w: line %q", ErrCorruptStore, line)
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: line %q", ErrCorruptStore, line)
+	}
+	id, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: line %q", ErrCorruptStore, line)
+	}
+	return id, time.Unix(0, n), nil
+}
+
+// OpenFileStore opens FileStore at path, creating file if it doesn't
+// exist. Clock tells which IDs are expired, nil means real time, so it
+// should be the same as of ExactlyOnce.
+//
+// Line torn by crash in the middle of Record is the last one and
+// is skipped, any other malformed line is ErrCorruptStore.
+func OpenFileStore(path string, clock Clock) (*FileStore, error) {
+	const op = "subpub.OpenFileStore"
+
+	if clock == nil {
+		clock = realClock{}
+	}
+	f := &FileStore{
+		mut:   &sync.Mutex{},
+		path:  path,
+		clock: clock,
+		ids:   make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		id, expiresAt, err := parseLine(line)
+		if err != nil {
+			// only the last line may be unfinished
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		f.ids[id] = expiresAt
+	}
+
+	if err := f.compact(clock.Now()); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return f, nil
+}
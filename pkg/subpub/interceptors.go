@@ -0,0 +1,97 @@
+package subpub
+
+import "context"
+
+// PublishFunc publishes msg to subject. Passed to PublishInterceptor
+// as the rest of chain.
+type PublishFunc func(ctx context.Context, subject string, msg Message) error
+
+// PublishInterceptor wraps publishing of every message by user:
+// Publish, PublishAndWait, staging in Tx and scheduled messages once
+// their time has come. Internal $SYS events and dead letters are not
+// intercepted.
+//
+// Interceptor may change subject, data and headers of message before
+// passing it to next, or short-circuit by returning without calling
+// next. Then its error is returned to publisher, nil means message
+// is acknowledged as published without publishing.
+//
+// Headers of msg may be shared with publisher, so they must be
+// copied before modification.
+type PublishInterceptor func(ctx context.Context, subject string, msg Message, next PublishFunc) error
+
+// DeliveryFunc delivers msg to subscriber. Passed to
+// DeliveryInterceptor as the rest of chain.
+type DeliveryFunc func(ctx context.Context, msg Message)
+
+// DeliveryInterceptor wraps delivery of every message to handler,
+// it's called by processor of subscription with its context.
+//
+// For Subscribe and SubscribeContext next calls handler with given
+// context. For SubscribeBatch and SubscribeChan next only adds message
+// to batch or sends it to channel, so interceptor can't wrap handler.
+//
+// Interceptor may change message before passing it to next, or
+// short-circuit by returning without calling next. Then message is
+// counted as filtered and sent to dead letters with DeliveryFiltered.
+//
+// Headers of msg are shared with other subscribers, so they must be
+// copied before modification.
+type DeliveryInterceptor func(ctx context.Context, msg Message, next DeliveryFunc)
+
+// chainPublish wraps final into interceptors,
+// the first one is the outermost.
+func chainPublish(interceptors []PublishInterceptor, final PublishFunc) PublishFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], final
+		final = func(ctx context.Context, subject string, msg Message) error {
+			return interceptor(ctx, subject, msg, next)
+		}
+	}
+	return final
+}
+
+// chainDelivery wraps final into interceptors,
+// the first one is the outermost.
+func chainDelivery(interceptors []DeliveryInterceptor, final DeliveryFunc) DeliveryFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], final
+		final = func(ctx context.Context, msg Message) {
+			interceptor(ctx, msg, next)
+		}
+	}
+	return final
+}
+
+// intercept maps subject, then passes msg through publish interceptors
+// of system to publish with config updated by them. Copies for mirrors
+// are passed the same way to mirror, see SubjectMapping.
+func (s *subpub) intercept(ctx context.Context, subject string, msg interface{}, cfg publishConfig, publish, mirror func(subject string, msg interface{}, cfg publishConfig) error) error {
+	subject, mirrors := s.mapping.Load().route(subject)
+
+	if err := s.chain(ctx, subject, msg, cfg, publish); err != nil {
+		return err
+	}
+	for _, subject := range mirrors {
+		// message is already published, so copies are best effort
+		s.reportErr(s.chain(ctx, subject, msg, cfg, mirror))
+	}
+	return nil
+}
+
+// chain passes msg through publish interceptors of system,
+// and then to publish with config updated by them.
+func (s *subpub) chain(ctx context.Context, subject string, msg interface{}, cfg publishConfig, publish func(subject string, msg interface{}, cfg publishConfig) error) error {
+	if len(s.cfg.publishInterceptors) == 0 {
+		return publish(subject, msg, cfg)
+	}
+	final := func(ctx context.Context, subject string, msg Message) error {
+		cfg.headers = msg.Headers
+		return publish(subject, msg.Data, cfg)
+	}
+	return chainPublish(s.cfg.publishInterceptors, final)(ctx, subject, Message{
+		Subject: subject,
+		Data:    msg,
+		Headers: cfg.headers,
+	})
+}
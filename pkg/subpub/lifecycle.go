@@ -0,0 +1,74 @@
+package subpub
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// State is a lifecycle state of subpub system, topic or subscription.
+//
+// The only allowed transitions are
+// StateOpen -> StateDraining -> StateClosed.
+type State int32
+
+const (
+	// StateOpen accepts new subscribers and messages.
+	StateOpen State = iota
+	// StateDraining rejects new subscribers and messages,
+	// but still delivers everything already queued.
+	StateDraining
+	// StateClosed is final, nothing is running anymore.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("State(%d)", int32(s))
+	}
+}
+
+// StateError is returned when operation is rejected because
+// subpub system or topic is not open anymore.
+//
+// Unwraps to one of ErrClosed, ErrDraining or ErrTopicClosed.
+type StateError struct {
+	Op      string
+	Subject string
+	State   State
+	Err     error
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("subpub: %s on %q rejected (%s): %s", e.Op, e.Subject, e.State, e.Err)
+}
+
+func (e *StateError) Unwrap() error {
+	return e.Err
+}
+
+// lifecycle holds State and allows only forward transitions.
+type lifecycle struct {
+	state atomic.Int32
+}
+
+func (l *lifecycle) Load() State {
+	return State(l.state.Load())
+}
+
+// Transition moves lifecycle from one state to another.
+//
+// Returns false if current state is not from,
+// in which case nothing is changed.
+func (l *lifecycle) Transition(from, to State) bool {
+	if to <= from {
+		return false
+	}
+	return l.state.CompareAndSwap(int32(from), int32(to))
+}
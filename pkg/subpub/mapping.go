@@ -0,0 +1,260 @@
+package subpub
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMapping is returned by CompileMapping for malformed rules.
+var ErrInvalidMapping = errors.New("invalid subject mapping")
+
+// MappingRules rename subjects of published messages and copy
+// messages to other subjects, so that subject can be renamed without
+// updating every publisher at once. See CompileMapping.
+type MappingRules struct {
+	// Maps are tried in order, the first one matching
+	// subject of message moves it to its destination.
+	Maps []MapRule
+
+	// Mirrors matching subject of message after mapping
+	// copy it to their destinations.
+	Mirrors []MirrorRule
+}
+
+// MapRule moves messages published to subjects matching Source pattern
+// to one of Destinations, chosen randomly by weight for every message,
+// e.g. to send a share of traffic to canary consumers.
+type MapRule struct {
+	Source       string
+	Destinations []WeightedSubject
+}
+
+// WeightedSubject is destination of MapRule.
+//
+// Subject is made of tokens separated by dots, where token "$N" is
+// replaced with token matched by the N-th wildcard of source, counting
+// from 1. Trailing ">" matches all the rest of tokens at once, e.g.
+// "old.*.>" maps "old.eu.orders.created" to "new.$2.$1" as
+// "new.orders.created.eu".
+type WeightedSubject struct {
+	Subject string
+
+	// Weight is relative share of messages sent to Subject.
+	// If all weights of rule are zero, shares are equal.
+	Weight int
+}
+
+// MirrorRule copies messages of subjects matching Source pattern
+// to Destination, which may refer to wildcards like WeightedSubject.
+type MirrorRule struct {
+	Source      string
+	Destination string
+}
+
+// SubjectMapping is compiled MappingRules, applied by system set
+// with WithSubjectMapping or SubPub.SetSubjectMapping.
+//
+// Mapping is applied to every message published by user, before
+// publish interceptors: Publish, PublishAndWait, staging in Tx and
+// scheduled messages once their time has come. Internal $SYS events
+// and dead letters are not mapped.
+//
+// Message is published to mapped subject first, then copies are
+// published to mirrors, going through interceptors on their own.
+// Mapped and mirrored subjects are not mapped again, so rules never
+// loop. Copies are best effort: publisher gets only error of mapped
+// subject, while errors of mirrors are reported as errors which can't
+// be returned to caller, see WithErrorHandler. PublishAndWait waits
+// for subscribers of mapped subject only.
+type SubjectMapping struct {
+	rules   MappingRules
+	maps    []subjectMap
+	mirrors []subjectMirror
+}
+
+type subjectMap struct {
+	source       string
+	destinations []destination
+	// weights are cumulative, last one is total
+	weights []int
+}
+
+type subjectMirror struct {
+	source      string
+	destination destination
+}
+
+// destination is subject template, where tokens with
+// positive ref are replaced with wildcard captures.
+type destination struct {
+	tokens []string
+	refs   []int
+}
+
+// Rules returns rules mapping was compiled from.
+func (m *SubjectMapping) Rules() MappingRules {
+	return m.rules
+}
+
+// route returns subject where message published to subject
+// is moved to, and subjects where it's copied to.
+func (m *SubjectMapping) route(subject string) (string, []string) {
+	if m == nil {
+		return subject, nil
+	}
+
+	for _, sm := range m.maps {
+		captures, ok := capture(sm.source, subject)
+		if !ok {
+			continue
+		}
+		subject = sm.pick().expand(captures)
+		break
+	}
+
+	var mirrors []string
+	for _, mirror := range m.mirrors {
+		if captures, ok := capture(mirror.source, subject); ok {
+			mirrors = append(mirrors, mirror.destination.expand(captures))
+		}
+	}
+	return subject, mirrors
+}
+
+func (sm subjectMap) pick() destination {
+	if len(sm.destinations) == 1 {
+		return sm.destinations[0]
+	}
+
+	n := rand.IntN(sm.weights[len(sm.weights)-1])
+	for i, weight := range sm.weights {
+		if n < weight {
+			return sm.destinations[i]
+		}
+	}
+	return sm.destinations[len(sm.destinations)-1]
+}
+
+func (d destination) expand(captures []string) string {
+	tokens := make([]string, len(d.tokens))
+	for i, token := range d.tokens {
+		if d.refs[i] > 0 {
+			token = captures[d.refs[i]-1]
+		}
+		tokens[i] = token
+	}
+	return strings.Join(tokens, TokenSeparator)
+}
+
+// capture matches subject against pattern like MatchSubject,
+// returning tokens matched by wildcards in order. Tail matched
+// by ">" is single capture.
+func capture(pattern, subject string) ([]string, bool) {
+	if !MatchSubject(pattern, subject) {
+		return nil, false
+	}
+
+	var captures []string
+	subjectTokens := strings.Split(subject, TokenSeparator)
+	for i, token := range strings.Split(pattern, TokenSeparator) {
+		switch token {
+		case AnyToken:
+			captures = append(captures, subjectTokens[i])
+		case AnyTail:
+			captures = append(captures, strings.Join(subjectTokens[i:], TokenSeparator))
+		}
+	}
+	return captures, true
+}
+
+// wildcards counts wildcards of valid pattern.
+func wildcards(pattern string) int {
+	count := 0
+	for token := range strings.SplitSeq(pattern, TokenSeparator) {
+		if token == AnyToken || token == AnyTail {
+			count++
+		}
+	}
+	return count
+}
+
+func compileDestination(source, subject string) (destination, error) {
+	if err := ValidatePattern(subject); err != nil {
+		return destination{}, fmt.Errorf("%w: %w", ErrInvalidMapping, err)
+	}
+
+	d := destination{tokens: strings.Split(subject, TokenSeparator)}
+	d.refs = make([]int, len(d.tokens))
+	for i, token := range d.tokens {
+		if token == AnyToken || token == AnyTail {
+			return destination{}, fmt.Errorf("%w: destination %q has wildcards", ErrInvalidMapping, subject)
+		}
+		ref, ok := strings.CutPrefix(token, "$")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(ref)
+		if err != nil || n < 1 || n > wildcards(source) {
+			return destination{}, fmt.Errorf("%w: %q of %q refers to no wildcard of %q", ErrInvalidMapping, token, subject, source)
+		}
+		d.refs[i] = n
+	}
+	return d, nil
+}
+
+// CompileMapping checks rules and compiles them.
+//
+// Returns error wrapping ErrInvalidMapping if any pattern is invalid,
+// destination has wildcards or refers to missing ones, map rule
+// has no destinations or weights are negative.
+func CompileMapping(rules MappingRules) (*SubjectMapping, error) {
+	m := &SubjectMapping{rules: rules}
+
+	for _, rule := range rules.Maps {
+		if err := ValidatePattern(rule.Source); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMapping, err)
+		}
+		if len(rule.Destinations) == 0 {
+			return nil, fmt.Errorf("%w: no destinations for %q", ErrInvalidMapping, rule.Source)
+		}
+
+		sm := subjectMap{source: rule.Source}
+		equal := true
+		for _, dest := range rule.Destinations {
+			if dest.Weight < 0 {
+				return nil, fmt.Errorf("%w: negative weight of %q", ErrInvalidMapping, dest.Subject)
+			}
+			equal = equal && dest.Weight == 0
+		}
+		total := 0
+		for _, dest := range rule.Destinations {
+			d, err := compileDestination(rule.Source, dest.Subject)
+			if err != nil {
+				return nil, err
+			}
+			weight := dest.Weight
+			if equal {
+				weight = 1
+			}
+			total += weight
+			sm.destinations = append(sm.destinations, d)
+			sm.weights = append(sm.weights, total)
+		}
+		m.maps = append(m.maps, sm)
+	}
+
+	for _, rule := range rules.Mirrors {
+		if err := ValidatePattern(rule.Source); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMapping, err)
+		}
+		d, err := compileDestination(rule.Source, rule.Destination)
+		if err != nil {
+			return nil, err
+		}
+		m.mirrors = append(m.mirrors, subjectMirror{source: rule.Source, destination: d})
+	}
+	return m, nil
+}
@@ -0,0 +1,539 @@
+package subpub
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultDedupWindow is how long idempotency keys
+	// are remembered unless configured otherwise.
+	DefaultDedupWindow = 2 * time.Minute
+
+	// DefaultBatchSize is max size of batch
+	// passed to BatchHandler unless configured otherwise.
+	DefaultBatchSize = 100
+)
+
+type config struct {
+	// subjectTTL is default TTL of messages per subject.
+	subjectTTL map[string]time.Duration
+
+	// deadLetterSubject receives DeadLetter for every
+	// message which was not delivered. Empty means disabled.
+	deadLetterSubject string
+
+	// dedupWindow is default dedup window,
+	// subjectDedupWindow overrides it per subject.
+	dedupWindow        time.Duration
+	subjectDedupWindow map[string]time.Duration
+
+	// slowConsumer is nil unless watchdog is enabled.
+	slowConsumer *SlowConsumerPolicy
+
+	hooks Hooks
+
+	clock   Clock
+	metrics Metrics
+	logger  *slog.Logger
+	onError func(error)
+
+	// nextID is nil for default counter.
+	nextID func() int64
+
+	// subscribeDefaults are applied to every subscription
+	// before its own options.
+	subscribeDefaults []SubscribeOption
+
+	// publishInterceptors wrap every publish,
+	// deliveryInterceptors wrap interceptors of every subscription.
+	publishInterceptors  []PublishInterceptor
+	deliveryInterceptors []DeliveryInterceptor
+
+	// tracerProvider is nil for global one.
+	tracing        bool
+	tracerProvider trace.TracerProvider
+
+	// mapping is nil if subjects are not mapped.
+	mapping *SubjectMapping
+
+	// partitions are policies of partitioned subjects.
+	partitions map[string]PartitionPolicy
+}
+
+func (c *config) ttl(subject string) time.Duration {
+	return c.subjectTTL[subject]
+}
+
+func (c *config) dedup(subject string) time.Duration {
+	if window, ok := c.subjectDedupWindow[subject]; ok {
+		return window
+	}
+	return c.dedupWindow
+}
+
+func defaultConfig() config {
+	return config{
+		subjectTTL:         make(map[string]time.Duration),
+		dedupWindow:        DefaultDedupWindow,
+		subjectDedupWindow: make(map[string]time.Duration),
+		partitions:         make(map[string]PartitionPolicy),
+		clock:              realClock{},
+		metrics:            noopMetrics{},
+		logger:             slog.New(slog.DiscardHandler),
+	}
+}
+
+// Option configures subpub system on creation.
+type Option func(*config)
+
+// WithSubjectTTL sets default TTL for messages on subject.
+//
+// Messages which stayed in subscription queue longer than TTL
+// are skipped. TTL of message itself takes precedence.
+// Zero means no TTL.
+func WithSubjectTTL(subject string, ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.subjectTTL[subject] = ttl
+	}
+}
+
+// WithDeadLetter makes system publish DeadLetter to subject
+// for every message skipped by subscription.
+//
+// Messages published to dead-letter subject itself
+// are never dead-lettered again.
+func WithDeadLetter(subject string) Option {
+	return func(cfg *config) {
+		cfg.deadLetterSubject = subject
+	}
+}
+
+// WithDedupWindow sets for how long idempotency keys
+// of messages on every subject are remembered.
+// Zero disables deduplication.
+//
+// Default is DefaultDedupWindow.
+func WithDedupWindow(window time.Duration) Option {
+	return func(cfg *config) {
+		cfg.dedupWindow = window
+	}
+}
+
+// WithSubjectDedupWindow is WithDedupWindow for single subject.
+func WithSubjectDedupWindow(subject string, window time.Duration) Option {
+	return func(cfg *config) {
+		cfg.subjectDedupWindow[subject] = window
+	}
+}
+
+// WithWatchdog enables watchdog checking every subscription
+// against policy, see SlowConsumerPolicy.
+func WithWatchdog(policy SlowConsumerPolicy) Option {
+	return func(cfg *config) {
+		cfg.slowConsumer = &policy
+	}
+}
+
+// WithHooks sets callbacks for lifecycle events, see Hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(cfg *config) {
+		cfg.hooks = hooks
+	}
+}
+
+// WithClock sets clock for timestamps of system, see Clock.
+// Default is real time.
+func WithClock(clock Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = clock
+	}
+}
+
+// WithMetrics sets receiver of message counters.
+// By default counters are only available in Stats.
+func WithMetrics(metrics Metrics) Option {
+	return func(cfg *config) {
+		cfg.metrics = metrics
+	}
+}
+
+// WithLogger sets logger for warnings and errors which can't be
+// returned to caller, e.g. slow consumers and failed publishing
+// of dead letters. By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+	}
+}
+
+// WithErrorHandler sets callback for errors which can't be
+// returned to caller, e.g. failed publishing of dead letters,
+// events or scheduled messages. Errors caused by closing
+// of the system are not reported.
+//
+// Called synchronously, so it must not block.
+func WithErrorHandler(onError func(error)) Option {
+	return func(cfg *config) {
+		cfg.onError = onError
+	}
+}
+
+// WithIDGenerator sets generator of subscription IDs.
+//
+// IDs must be unique system-wide, as they define lock order
+// of subscriptions in transactions. Default is counter from 1.
+func WithIDGenerator(next func() int64) Option {
+	return func(cfg *config) {
+		cfg.nextID = next
+	}
+}
+
+// WithSubscribeDefaults sets options applied to every subscription,
+// options of subscription itself take precedence.
+// Repeated use appends options.
+func WithSubscribeDefaults(opts ...SubscribeOption) Option {
+	return func(cfg *config) {
+		cfg.subscribeDefaults = append(cfg.subscribeDefaults, opts...)
+	}
+}
+
+// WithPublishInterceptors adds interceptors wrapping every publish,
+// see PublishInterceptor. The first one is the outermost, repeated
+// use appends interceptors.
+func WithPublishInterceptors(interceptors ...PublishInterceptor) Option {
+	return func(cfg *config) {
+		cfg.publishInterceptors = append(cfg.publishInterceptors, interceptors...)
+	}
+}
+
+// WithDeliveryInterceptors adds interceptors wrapping delivery to every
+// subscription, see DeliveryInterceptor. The first one is the outermost,
+// and all of them wrap interceptors of subscription itself.
+// Repeated use appends interceptors.
+func WithDeliveryInterceptors(interceptors ...DeliveryInterceptor) Option {
+	return func(cfg *config) {
+		cfg.deliveryInterceptors = append(cfg.deliveryInterceptors, interceptors...)
+	}
+}
+
+// WithTracing enables OpenTelemetry spans for publishing and for every
+// delivery to subscriber, linked by W3C trace context in headers of
+// message. Nil provider means global one.
+//
+// Tracing is done by interceptors, which wrap all others: publish span
+// is child of context passed to PublishContext or PublishAndWait, and
+// delivery span is passed to handlers of SubscribeContext and injected
+// into headers of messages, so channel and batch subscribers get it too.
+func WithTracing(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.tracing = true
+		cfg.tracerProvider = provider
+	}
+}
+
+// WithSubjectMapping sets initial mapping of subjects,
+// see SubjectMapping. It can be replaced at runtime
+// with SubPub.SetSubjectMapping.
+func WithSubjectMapping(mapping *SubjectMapping) Option {
+	return func(cfg *config) {
+		cfg.mapping = mapping
+	}
+}
+
+// WithPartitions splits subject into partitions consumed by groups,
+// see SubscribeGroup. Messages are put into partition by key
+// set with WithPartitionKey, or round robin without one.
+//
+// Subscribers of the whole subject still get every message,
+// as for any other subject.
+func WithPartitions(subject string, policy PartitionPolicy) Option {
+	return func(cfg *config) {
+		cfg.partitions[subject] = policy
+	}
+}
+
+type publishConfig struct {
+	ttl            time.Duration
+	headers        map[string]string
+	idempotencyKey string
+	priority       Priority
+	partitionKey   string
+}
+
+// PublishOption configures single message.
+type PublishOption func(*publishConfig)
+
+// WithTTL sets time to live of message.
+//
+// If message is still in subscription queue after ttl, it's skipped.
+// Overrides TTL of subject, zero means TTL of subject.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.ttl = ttl
+	}
+}
+
+// WithHeaders attaches headers to message.
+//
+// Map is not copied, so it must not be modified after publishing.
+// Repeated use merges headers.
+func WithHeaders(headers map[string]string) PublishOption {
+	return func(cfg *publishConfig) {
+		if cfg.headers == nil {
+			cfg.headers = headers
+			return
+		}
+		merged := make(map[string]string, len(cfg.headers)+len(headers))
+		for k, v := range cfg.headers {
+			merged[k] = v
+		}
+		for k, v := range headers {
+			merged[k] = v
+		}
+		cfg.headers = merged
+	}
+}
+
+// WithIdempotencyKey marks message with key unique per message.
+//
+// Message with key already published to the same subject within
+// dedup window is accepted, but not delivered again. So it's safe
+// to retry publishing with the same key.
+func WithIdempotencyKey(key string) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.idempotencyKey = key
+	}
+}
+
+// WithPriority sets priority of message.
+//
+// Subscription serves queued messages of higher priority first,
+// messages of the same priority are delivered in publishing order.
+// Priorities above PriorityCritical are treated as PriorityCritical.
+func WithPriority(priority Priority) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.priority = min(priority, PriorityCritical)
+	}
+}
+
+// WithPartitionKey sets key choosing partition of message on
+// partitioned subject, messages with the same key go to the same
+// partition and keep their order. Ignored on other subjects.
+func WithPartitionKey(key string) PublishOption {
+	return func(cfg *publishConfig) {
+		cfg.partitionKey = key
+	}
+}
+
+func newPublishConfig(opts []PublishOption) publishConfig {
+	var cfg publishConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type subscribeConfig struct {
+	// conflationKey is nil unless subscription is conflating.
+	conflationKey func(Message) string
+
+	// priorityWeights are nil for strict priority.
+	priorityWeights []int
+
+	// rate is max deliveries per second, zero means no limit.
+	rate  float64
+	burst int
+
+	// batchSize and linger are used only with BatchHandler.
+	batchSize int
+	linger    time.Duration
+
+	// queueLimit is max number of queued messages,
+	// zero means no limit.
+	queueLimit    int
+	queueOverflow Overflow
+
+	// chanBuffer and chanOverflow are used only by SubscribeChan.
+	chanBuffer   int
+	chanOverflow Overflow
+
+	// interceptors wrap delivery of every message,
+	// global ones are prepended by system.
+	interceptors []DeliveryInterceptor
+
+	// ackWait is zero unless subscription is in ack mode,
+	// maxDeliver of zero means no limit.
+	ackWait    time.Duration
+	maxDeliver int
+
+	// watched is set by system if watchdog is enabled.
+	watched bool
+}
+
+// grabLimit returns how many messages processor
+// may take from queue at once, zero means all.
+//
+// Messages left in queue may still be conflated or preempted
+// by higher priorities, so processor with nothing to do
+// with big batch doesn't take it. Conflating subscription
+// takes messages one by one, so that message waiting for
+// handler is always replaced by newer one.
+func (c *subscribeConfig) grabLimit(batching bool) int {
+	limit := 0
+	if batching {
+		limit = c.batchSize
+	} else if c.conflationKey != nil {
+		return 1
+	}
+	if c.rate > 0 && (limit == 0 || c.burst < limit) {
+		limit = c.burst
+	}
+	return limit
+}
+
+// SubscribeOption configures single subscription.
+type SubscribeOption func(*subscribeConfig)
+
+// WithConflation makes subscription keep only the latest
+// queued message per key returned by key function.
+//
+// Newer message replaces older one with the same key right
+// in its place in the queue, so queue never holds more messages
+// than there are distinct keys. Messages with empty key are
+// never replaced.
+//
+// Key function is called by publisher, so it must be fast
+// and must not block.
+func WithConflation(key func(Message) string) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.conflationKey = key
+	}
+}
+
+// WithConflationHeader is WithConflation keyed by header value.
+func WithConflationHeader(header string) SubscribeOption {
+	return WithConflation(func(msg Message) string {
+		return msg.Headers[header]
+	})
+}
+
+// WithPriorityWeights replaces strict priority of subscription
+// with weighted round-robin, so that flood of high priority
+// messages can't starve lower ones.
+//
+// weights[p] is how many messages of priority p are delivered
+// in a row before turn passes to the next lower non-empty priority.
+// Missing and non-positive weights count as 1.
+// FIFO order within a priority is kept.
+func WithPriorityWeights(weights ...int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.priorityWeights = append(make([]int, 0, len(weights)), weights...)
+	}
+}
+
+// WithRateLimit limits rate of deliveries to subscription
+// with token bucket: on average no more than rate messages
+// per second and no more than burst messages at once.
+//
+// Messages above the limit wait in queue, so they still can be
+// conflated, expired or dropped on shutdown. Non-positive rate
+// means no limit, burst is at least 1.
+func WithRateLimit(rate float64, burst int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.rate = rate
+		cfg.burst = max(burst, 1)
+	}
+}
+
+// WithBatching configures subscription made with SubscribeBatch.
+//
+// Batch is passed to handler once it has maxSize messages or
+// linger has passed since processor started to collect it,
+// whichever comes first. Zero linger means no waiting: handler
+// gets whatever is queued, but no more than maxSize messages.
+// On closing batch is passed without waiting.
+//
+// Ignored by other subscriptions.
+func WithBatching(maxSize int, linger time.Duration) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.batchSize = max(maxSize, 1)
+		cfg.linger = linger
+	}
+}
+
+// WithQueueLimit limits number of messages queued for subscription,
+// e.g. while it's paused or its handler is slow.
+//
+// Message which doesn't fit is dropped by overflow policy. Publishers
+// never wait for subscribers, so OverflowBlock is OverflowDropNewest
+// here. OverflowDropOldest drops the oldest message of the lowest
+// queued priority. Dropped messages are counted in Stats and sent
+// to dead letters. Non-positive limit means no limit.
+func WithQueueLimit(limit int, overflow Overflow) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.queueLimit = max(limit, 0)
+		cfg.queueOverflow = overflow
+	}
+}
+
+// WithChanBuffer configures channel made by SubscribeChan:
+// its buffer size and what to do when it's full.
+//
+// Consumer blocking on channel never stalls other subscribers,
+// with OverflowBlock messages just wait in subscription queue.
+// Default is DefaultChanBuffer and OverflowBlock.
+//
+// Ignored by other subscriptions.
+func WithChanBuffer(size int, overflow Overflow) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.chanBuffer = max(size, 0)
+		cfg.chanOverflow = overflow
+	}
+}
+
+// WithAck puts subscription made with SubscribeChan or SubscribeBatch
+// into ack mode: every message taken from channel or passed in batch
+// must be acked with Message.Ack.
+//
+// Message not acked within wait after it was passed to channel, or
+// after batch handler has returned, is queued again and redelivered
+// with incremented Message.Delivery.
+// Once it's delivered maxDeliver times without ack, it's counted as
+// not acked and sent to dead letters with DeliveryNotAcked.
+// Non-positive maxDeliver means no limit. PublishAndWait waits for
+// message to be acked.
+//
+// Messages still awaiting ack when subscription is closed are dropped.
+// Wait is measured by real time, not by clock of WithClock.
+// Non-positive wait disables ack mode. Other subscriptions reject it
+// with ErrAckUnsupported: their handlers ack messages by returning.
+func WithAck(wait time.Duration, maxDeliver int) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.ackWait = max(wait, 0)
+		cfg.maxDeliver = max(maxDeliver, 0)
+	}
+}
+
+// WithSubscriptionInterceptors adds interceptors wrapping delivery to
+// this subscription, see DeliveryInterceptor. They run inside of ones
+// set by WithDeliveryInterceptors, the first one is the outermost.
+// Repeated use appends interceptors.
+func WithSubscriptionInterceptors(interceptors ...DeliveryInterceptor) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.interceptors = append(cfg.interceptors, interceptors...)
+	}
+}
+
+func newSubscribeConfig(defaults, opts []SubscribeOption) subscribeConfig {
+	cfg := subscribeConfig{batchSize: DefaultBatchSize, chanBuffer: DefaultChanBuffer}
+	for _, opt := range defaults {
+		opt(&cfg)
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
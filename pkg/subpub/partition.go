@@ -0,0 +1,636 @@
+package subpub
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotPartitioned is returned by SubscribeGroup
+// for subject not set up with WithPartitions.
+var ErrNotPartitioned = errors.New("subject is not partitioned")
+
+// DefaultRetention is max number of messages kept in every partition
+// for consumer groups unless configured otherwise.
+const DefaultRetention = 10000
+
+// PartitionPolicy splits subject into partitions, see WithPartitions.
+type PartitionPolicy struct {
+	// Partitions is number of partitions, at least 1.
+	Partitions int
+
+	// Retention is max number of messages kept in every partition
+	// for groups which haven't processed them yet. Once exceeded,
+	// the oldest messages are dropped and groups lagging behind skip
+	// them. Non-positive means DefaultRetention.
+	Retention int
+}
+
+// GroupMessage is message delivered to member of consumer group
+// along with its position in partitioned subject.
+type GroupMessage struct {
+	Message
+
+	Partition int
+	Offset    int64
+}
+
+// GroupHandler processes messages delivered to member of consumer group.
+// Context is cancelled once member has left the group with Unsubscribe.
+type GroupHandler func(ctx context.Context, msg GroupMessage)
+
+// partitionedTopic holds partitions of subject and groups consuming
+// them. Messages are kept in partitions only while some group
+// hasn't processed them.
+type partitionedTopic struct {
+	subject string
+	policy  PartitionPolicy
+
+	// ttl is default TTL of messages on subject.
+	ttl time.Duration
+
+	sys *system
+
+	// mut guards everything below, including groups and their
+	// members, conds of groups are bound to it.
+	mut        *sync.Mutex
+	partitions []*partition
+	groups     map[string]*group
+	// next is partition of the next message without key.
+	next int
+	// closed is set once system is closing,
+	// members stop and no new messages are accepted.
+	closed bool
+}
+
+type partition struct {
+	// log holds messages from offset base.
+	base int64
+	log  []envelope
+}
+
+// end returns offset of the next message in partition.
+func (p *partition) end() int64 {
+	return p.base + int64(len(p.log))
+}
+
+// group is consumer group, every partition of topic is assigned
+// to at most one of members and processed in order. Group stays with
+// its offsets once all members have left.
+type group struct {
+	name string
+	t    *partitionedTopic
+	cond *sync.Cond
+
+	// members are sorted by ID.
+	members []*member
+
+	// owners, offsets, busy and skipped counters are per partition.
+	// Offset is committed one: the next message to process.
+	owners  []*member
+	offsets []int64
+	// busy is set while owner processes message of partition,
+	// so that new owner waits for it after rebalance.
+	busy    []bool
+	expired []uint64
+	dropped []uint64
+}
+
+// member is subscription of consumer group.
+type member struct {
+	id int64
+	g  *group
+
+	// either handler or ch is set
+	handler GroupHandler
+	ch      chan GroupMessage
+
+	// deliver is chain of delivery interceptors ending with pass,
+	// position is message it delivers. Used by member goroutine only.
+	deliver  DeliveryFunc
+	position GroupMessage
+	// handed is set once message has reached handler or ch.
+	handed bool
+	// unsent is set if ch hasn't taken message
+	// before member has left.
+	unsent bool
+	// next is partition to look at first, so that
+	// partitions of member take turns.
+	next int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// paused and left are guarded by mut of topic.
+	paused bool
+	left   bool
+
+	done chan struct{}
+}
+
+// append puts message into partition chosen by key, or round robin
+// for empty key, and wakes members of groups up.
+//
+// Returns false if topic is closed.
+func (t *partitionedTopic) append(message interface{}, cfg publishConfig) bool {
+	t.mut.Lock()
+	ok := t.appendLocked(message, cfg)
+	t.mut.Unlock()
+
+	if ok {
+		t.wake()
+	}
+	return ok
+}
+
+// appendLocked does the same as append,
+// but t.mut must be held and caller must wake groups.
+func (t *partitionedTopic) appendLocked(message interface{}, cfg publishConfig) bool {
+	if t.closed {
+		return false
+	}
+
+	ttl := cfg.ttl
+	if ttl == 0 {
+		ttl = t.ttl
+	}
+	p := t.partitions[t.partitionOf(cfg.partitionKey)]
+	p.log = append(p.log, newEnvelope(message, cfg.headers, ttl, t.sys.clock))
+	t.trim()
+	return true
+}
+
+// partitionOf returns partition of key.
+func (t *partitionedTopic) partitionOf(key string) int {
+	if key == "" {
+		i := t.next
+		t.next = (t.next + 1) % len(t.partitions)
+		return i
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(t.partitions)))
+}
+
+// trim drops messages processed by every group and messages over
+// retention, moving lagging groups forward.
+func (t *partitionedTopic) trim() {
+	for i, p := range t.partitions {
+		keep := p.end()
+		for _, g := range t.groups {
+			keep = min(keep, g.offsets[i])
+		}
+		keep = max(keep, p.end()-int64(t.policy.Retention))
+
+		n := int(keep - p.base)
+		if n <= 0 {
+			continue
+		}
+		clear(p.log[:n])
+		p.log = p.log[n:]
+		p.base = keep
+
+		for _, g := range t.groups {
+			if g.offsets[i] < keep {
+				g.dropped[i] += uint64(keep - g.offsets[i])
+				g.offsets[i] = keep
+			}
+		}
+	}
+}
+
+// wake wakes members of every group up.
+func (t *partitionedTopic) wake() {
+	t.mut.Lock()
+	groups := make([]*group, 0, len(t.groups))
+	for _, g := range t.groups {
+		groups = append(groups, g)
+	}
+	t.mut.Unlock()
+
+	for _, g := range groups {
+		g.cond.Broadcast()
+	}
+}
+
+// join adds member to group, creating group if there is none.
+// New group starts from the end of every partition.
+func (t *partitionedTopic) join(name string, m *member) bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.closed {
+		return false
+	}
+
+	g, ok := t.groups[name]
+	if !ok {
+		g = t.newGroup(name)
+		for i, p := range t.partitions {
+			g.offsets[i] = p.end()
+		}
+	}
+
+	m.g = g
+	g.members = append(g.members, m)
+	sort.Slice(g.members, func(i, j int) bool { return g.members[i].id < g.members[j].id })
+	g.rebalance()
+	return true
+}
+
+// close stops members of every group once they have finished with
+// messages they are processing. Messages left in partitions stay
+// there with offsets of groups.
+//
+// On done ctx returns ctx.Err(), members keep stopping.
+func (t *partitionedTopic) close(ctx context.Context) error {
+	members := t.stop()
+
+	for _, m := range members {
+		select {
+		case <-m.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// abort stops members without waiting for them,
+// cancelling contexts of running handlers.
+func (t *partitionedTopic) abort() {
+	for _, m := range t.stop() {
+		m.cancel()
+	}
+}
+
+// stop closes topic and tells every member to stop.
+func (t *partitionedTopic) stop() []*member {
+	t.mut.Lock()
+	t.closed = true
+	var members []*member
+	for _, g := range t.groups {
+		for _, m := range g.members {
+			m.left = true
+		}
+		members = append(members, g.members...)
+	}
+	t.mut.Unlock()
+
+	t.wake()
+	return members
+}
+
+// rebalance assigns partitions to members by ranges,
+// so that every member gets contiguous partitions. With more
+// members than partitions the last ones get none.
+// Must be called with t.mut held.
+func (g *group) rebalance() {
+	n := len(g.owners)
+	for i := range g.owners {
+		g.owners[i] = nil
+		if len(g.members) > 0 {
+			g.owners[i] = g.members[i*len(g.members)/n]
+		}
+	}
+	g.cond.Broadcast()
+}
+
+// leave removes m from group and rebalances partitions.
+// Must be called with t.mut held.
+func (g *group) leave(m *member) {
+	for i, member := range g.members {
+		if member == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	g.rebalance()
+}
+
+// take returns the next message of partition owned by m,
+// which is not processed by previous owner anymore.
+// Must be called with t.mut held.
+func (m *member) take() (int, int64, envelope, bool) {
+	if m.paused || m.left {
+		return 0, 0, envelope{}, false
+	}
+
+	g := m.g
+	n := len(g.owners)
+	for k := range n {
+		i := (m.next + k) % n
+		p := g.t.partitions[i]
+		if g.owners[i] != m || g.busy[i] || g.offsets[i] >= p.end() {
+			continue
+		}
+		m.next = (i + 1) % n
+		offset := g.offsets[i]
+		return i, offset, p.log[offset-p.base], true
+	}
+	return 0, 0, envelope{}, false
+}
+
+// commit records that message at offset of partition is processed.
+// Must be called with t.mut held.
+func (g *group) commit(partition int, offset int64) {
+	g.busy[partition] = false
+	// retention could have moved offset further already
+	g.offsets[partition] = max(g.offsets[partition], offset+1)
+	g.t.trim()
+	g.cond.Broadcast()
+}
+
+// release lets partition go without committing message,
+// so that the next owner gets it again.
+// Must be called with t.mut held.
+func (g *group) release(partition int) {
+	g.busy[partition] = false
+	g.cond.Broadcast()
+}
+
+// run processes messages of partitions owned by member one by one
+// until it leaves group.
+func (m *member) run() {
+	defer close(m.done)
+	defer m.cancel()
+	if m.ch != nil {
+		defer close(m.ch)
+	}
+
+	t := m.g.t
+	for {
+		t.mut.Lock()
+		partition, offset, message, ok := m.take()
+		for !ok && !m.left {
+			m.g.cond.Wait()
+			partition, offset, message, ok = m.take()
+		}
+		if !ok {
+			t.mut.Unlock()
+			return
+		}
+		m.g.busy[partition] = true
+		t.mut.Unlock()
+
+		m.process(partition, offset, message)
+
+		t.mut.Lock()
+		if m.unsent {
+			m.g.release(partition)
+		} else {
+			m.g.commit(partition, offset)
+		}
+		t.mut.Unlock()
+	}
+}
+
+// process passes message through delivery interceptors to handler,
+// skipping it if expired.
+func (m *member) process(partition int, offset int64, message envelope) {
+	t := m.g.t
+
+	started := t.sys.clock.Now()
+	if message.expired(started) {
+		t.mut.Lock()
+		m.g.expired[partition]++
+		t.mut.Unlock()
+
+		t.sys.metrics.MessageSkipped(t.subject, DeliveryExpired)
+		t.sys.deadLetter(DeadLetter{
+			Subject:        t.subject,
+			SubscriptionID: m.id,
+			Msg:            message.payload,
+			Reason:         DeliveryExpired,
+			PublishedAt:    message.publishedAt,
+			ExpiresAt:      message.expiresAt,
+		})
+		return
+	}
+
+	m.position = GroupMessage{Partition: partition, Offset: offset}
+	m.handed = false
+	m.deliver(m.ctx, Message{
+		Subject: t.subject,
+		Data:    message.payload,
+		Headers: message.headers,
+	})
+	switch {
+	case m.handed:
+		t.sys.metrics.MessageDelivered(t.subject, t.sys.clock.Now().Sub(started))
+	case !m.unsent:
+		t.sys.metrics.MessageSkipped(t.subject, DeliveryFiltered)
+	}
+}
+
+// pass is the end of delivery interceptors chain.
+func (m *member) pass(ctx context.Context, msg Message) {
+	m.position.Message = msg
+	if m.ch == nil {
+		m.handler(ctx, m.position)
+		m.handed = true
+		return
+	}
+
+	select {
+	case m.ch <- m.position:
+		m.handed = true
+	case <-ctx.Done():
+		m.unsent = true
+	}
+}
+
+// Unsubscribe leaves group, partitions of member are assigned
+// to others. Cancels context of handler and waits for it to finish
+// with current message.
+func (m *member) Unsubscribe() {
+	t := m.g.t
+	t.mut.Lock()
+	if !m.left {
+		m.left = true
+		m.g.leave(m)
+	}
+	t.mut.Unlock()
+
+	m.cancel()
+	<-m.done
+}
+
+// Pause stops processing of partitions of member,
+// they stay assigned to it.
+func (m *member) Pause() {
+	m.setPaused(true)
+}
+
+// Resume continues processing of partitions of member.
+func (m *member) Resume() {
+	m.setPaused(false)
+}
+
+func (m *member) setPaused(paused bool) {
+	t := m.g.t
+	t.mut.Lock()
+	m.paused = paused
+	t.mut.Unlock()
+
+	m.g.cond.Broadcast()
+}
+
+// Err always returns nil, members are never evicted.
+func (m *member) Err() error {
+	return nil
+}
+
+func (t *partitionedTopic) stats() []GroupStats {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	stats := make([]GroupStats, 0, len(t.groups))
+	for _, g := range t.groups {
+		gs := GroupStats{
+			Subject:    t.subject,
+			Group:      g.name,
+			Members:    make([]int64, 0, len(g.members)),
+			Partitions: make([]PartitionStats, 0, len(t.partitions)),
+		}
+		for _, m := range g.members {
+			gs.Members = append(gs.Members, m.id)
+		}
+		for i, p := range t.partitions {
+			ps := PartitionStats{
+				Offset:  g.offsets[i],
+				End:     p.end(),
+				Expired: g.expired[i],
+				Dropped: g.dropped[i],
+			}
+			if g.owners[i] != nil {
+				ps.Owner = g.owners[i].id
+			}
+			gs.Partitions = append(gs.Partitions, ps)
+		}
+		stats = append(stats, gs)
+	}
+	return stats
+}
+
+// snapshot captures partitions and offsets of groups.
+func (t *partitionedTopic) snapshot() TopicSnapshot {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	ts := TopicSnapshot{
+		Subject:    t.subject,
+		Partitions: make([]PartitionSnapshot, 0, len(t.partitions)),
+		Groups:     make([]GroupSnapshot, 0, len(t.groups)),
+	}
+	for _, p := range t.partitions {
+		ps := PartitionSnapshot{Base: p.base, Messages: make([]RetainedMessage, 0, len(p.log))}
+		for _, message := range p.log {
+			ps.Messages = append(ps.Messages, RetainedMessage{
+				Data:        message.payload,
+				Headers:     message.headers,
+				PublishedAt: message.publishedAt,
+				ExpiresAt:   message.expiresAt,
+			})
+		}
+		ts.Partitions = append(ts.Partitions, ps)
+	}
+	for _, g := range t.groups {
+		ts.Groups = append(ts.Groups, GroupSnapshot{Group: g.name, Offsets: slices.Clone(g.offsets)})
+	}
+	sort.Slice(ts.Groups, func(i, j int) bool { return ts.Groups[i].Group < ts.Groups[j].Group })
+	return ts
+}
+
+// fresh reports whether nothing was published to topic
+// and no group has joined it. t.mut must be held.
+func (t *partitionedTopic) fresh() bool {
+	if len(t.groups) > 0 {
+		return false
+	}
+	for _, p := range t.partitions {
+		if p.end() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// restore replaces partitions and groups of fresh topic
+// with validated snapshot. t.mut must be held.
+func (t *partitionedTopic) restore(ts TopicSnapshot) {
+	for i, ps := range ts.Partitions {
+		p := t.partitions[i]
+		p.base = ps.Base
+		p.log = make([]envelope, 0, len(ps.Messages))
+		for _, message := range ps.Messages {
+			p.log = append(p.log, envelope{
+				payload:     message.Data,
+				headers:     message.Headers,
+				publishedAt: message.PublishedAt,
+				expiresAt:   message.ExpiresAt,
+			})
+		}
+	}
+	for _, gs := range ts.Groups {
+		g := t.newGroup(gs.Group)
+		copy(g.offsets, gs.Offsets)
+	}
+}
+
+// newGroup adds group without members. t.mut must be held.
+func (t *partitionedTopic) newGroup(name string) *group {
+	n := len(t.partitions)
+	g := &group{
+		name:    name,
+		t:       t,
+		cond:    sync.NewCond(t.mut),
+		owners:  make([]*member, n),
+		offsets: make([]int64, n),
+		busy:    make([]bool, n),
+		expired: make([]uint64, n),
+		dropped: make([]uint64, n),
+	}
+	t.groups[name] = g
+	return g
+}
+
+func newPartitionedTopic(subject string, policy PartitionPolicy, ttl time.Duration, sys *system) *partitionedTopic {
+	policy.Partitions = max(policy.Partitions, 1)
+	if policy.Retention <= 0 {
+		policy.Retention = DefaultRetention
+	}
+
+	t := &partitionedTopic{
+		subject: subject,
+		policy:  policy,
+		ttl:     ttl,
+		sys:     sys,
+		mut:     &sync.Mutex{},
+		groups:  make(map[string]*group),
+	}
+	for range policy.Partitions {
+		t.partitions = append(t.partitions, &partition{})
+	}
+	return t
+}
+
+// newMember creates member passing messages to handler,
+// or to ch if handler is nil.
+func newMember(id int64, handler GroupHandler, ch chan GroupMessage, interceptors []DeliveryInterceptor) *member {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), subscriptionIDKey{}, id))
+	m := &member{
+		id:      id,
+		handler: handler,
+		ch:      ch,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	m.deliver = chainDelivery(interceptors, m.pass)
+	return m
+}
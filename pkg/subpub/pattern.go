@@ -0,0 +1,57 @@
+package subpub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPattern is returned for malformed subject patterns.
+var ErrInvalidPattern = errors.New("invalid subject pattern")
+
+// Subject patterns are subjects made of tokens separated by dots,
+// where "*" token matches any single token and ">" as the last
+// token matches one or more tokens. E.g. "orders.*.created"
+// matches "orders.eu.created", and "orders.>" matches both
+// "orders.eu" and "orders.eu.created", but not "orders".
+const (
+	TokenSeparator = "."
+	AnyToken       = "*"
+	AnyTail        = ">"
+)
+
+// ValidatePattern checks that pattern has no empty tokens
+// and ">" is only the last token.
+func ValidatePattern(pattern string) error {
+	tokens := strings.Split(pattern, TokenSeparator)
+	for i, token := range tokens {
+		if token == "" {
+			return fmt.Errorf("%w %q: empty token", ErrInvalidPattern, pattern)
+		}
+		if token == AnyTail && i != len(tokens)-1 {
+			return fmt.Errorf("%w %q: %q must be the last token", ErrInvalidPattern, pattern, AnyTail)
+		}
+	}
+	return nil
+}
+
+// MatchSubject reports whether subject matches pattern,
+// subject without wildcards matches only itself.
+func MatchSubject(pattern, subject string) bool {
+	for {
+		patternToken, patternRest, patternMore := strings.Cut(pattern, TokenSeparator)
+		subjectToken, subjectRest, subjectMore := strings.Cut(subject, TokenSeparator)
+
+		switch {
+		case patternToken == AnyTail && !patternMore:
+			return subjectToken != ""
+		case patternToken == AnyToken && subjectToken == "":
+			return false
+		case patternToken != AnyToken && patternToken != subjectToken:
+			return false
+		case !patternMore || !subjectMore:
+			return patternMore == subjectMore
+		}
+		pattern, subject = patternRest, subjectRest
+	}
+}
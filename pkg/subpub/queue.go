@@ -0,0 +1,271 @@
+package subpub
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Priority of message, higher levels are served first.
+type Priority uint8
+
+const (
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityCritical
+
+	// PriorityLevels is the number of priority levels.
+	PriorityLevels = int(PriorityCritical) + 1
+)
+
+// queuePos is position of message in messageQueue. Index counts
+// every message ever queued on level, so it doesn't change as
+// messages in front of it are taken.
+type queuePos struct {
+	level Priority
+	index int
+}
+
+// messageQueue is a set of FIFO queues, one per priority level.
+//
+// Not synchronized, guarded by subscription mutex,
+// except for queued counters, which are read lock-free.
+type messageQueue struct {
+	levels [PriorityLevels][]envelope
+	// base holds index of the first message of every level.
+	base [PriorityLevels]int
+
+	// queued holds length of every level,
+	// so processor can check for preemption without lock.
+	queued [PriorityLevels]atomic.Int64
+
+	// conflated maps key to position of message with it,
+	// nil unless subscription is conflating.
+	conflated map[string]queuePos
+
+	// weights are nil for strict priority. Otherwise levels are
+	// served round-robin from the highest one, at most weight
+	// messages of level per turn.
+	weights []int
+	turn    Priority
+	served  int
+}
+
+// push appends message to its level or, if message has conflation
+// key already present in queue, replaces the older message in place.
+//
+// Returns replaced message and true if there was one.
+func (q *messageQueue) push(env envelope) (envelope, bool) {
+	if env.key != "" {
+		if pos, ok := q.conflated[env.key]; ok {
+			i := pos.index - q.base[pos.level]
+			replaced := q.levels[pos.level][i]
+			q.levels[pos.level][i] = env
+			return replaced, true
+		}
+		q.conflated[env.key] = queuePos{level: env.priority, index: q.base[env.priority] + len(q.levels[env.priority])}
+	}
+	q.levels[env.priority] = append(q.levels[env.priority], env)
+	q.queued[env.priority].Add(1)
+	return envelope{}, false
+}
+
+// conflates reports whether message with key
+// would replace queued one.
+func (q *messageQueue) conflates(key string) bool {
+	if key == "" {
+		return false
+	}
+	_, ok := q.conflated[key]
+	return ok
+}
+
+// evict removes the oldest message of the lowest
+// non-empty level, queue must not be empty.
+func (q *messageQueue) evict() envelope {
+	level := PriorityNormal
+	for len(q.levels[level]) == 0 {
+		level++
+	}
+	return q.take(level, 1)[0]
+}
+
+// oldest returns when the oldest message was queued,
+// zero if queue is empty or times are not recorded.
+func (q *messageQueue) oldest() time.Time {
+	var oldest time.Time
+	for level := range q.levels {
+		if len(q.levels[level]) == 0 {
+			continue
+		}
+		queuedAt := q.levels[level][0].queuedAt
+		if oldest.IsZero() || queuedAt.Before(oldest) {
+			oldest = queuedAt
+		}
+	}
+	return oldest
+}
+
+// len returns number of queued messages.
+//
+// Safe to call without lock.
+func (q *messageQueue) len() int {
+	n := int64(0)
+	for level := range q.queued {
+		n += q.queued[level].Load()
+	}
+	return int(n)
+}
+
+func (q *messageQueue) empty() bool {
+	for level := range q.levels {
+		if len(q.levels[level]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// next grabs batch of messages to process,
+// queue must not be empty.
+//
+// With strict priority it's the whole highest non-empty level,
+// otherwise at most what's left of the current level's turn.
+// Positive limit caps size of batch.
+func (q *messageQueue) next(limit int) (Priority, []envelope) {
+	if q.weights == nil {
+		level := q.highest()
+		return level, q.take(level, capped(len(q.levels[level]), limit))
+	}
+
+	for len(q.levels[q.turn]) == 0 || q.served >= q.weights[q.turn] {
+		q.served = 0
+		if q.turn == 0 {
+			q.turn = Priority(PriorityLevels - 1)
+		} else {
+			q.turn--
+		}
+	}
+	n := capped(min(q.weights[q.turn]-q.served, len(q.levels[q.turn])), limit)
+	q.served += n
+	return q.turn, q.take(q.turn, n)
+}
+
+// highest returns the highest non-empty level.
+func (q *messageQueue) highest() Priority {
+	for level := PriorityLevels - 1; level > 0; level-- {
+		if len(q.levels[level]) > 0 {
+			return Priority(level)
+		}
+	}
+	return PriorityNormal
+}
+
+// take removes first n messages of level.
+func (q *messageQueue) take(level Priority, n int) []envelope {
+	batch := q.levels[level][:n:n]
+	q.levels[level] = q.levels[level][n:]
+	if len(q.levels[level]) == 0 {
+		q.levels[level] = nil
+	}
+	q.queued[level].Add(int64(-n))
+
+	// key has only one message in queue, so it's the taken one
+	if q.conflated != nil {
+		for _, env := range batch {
+			if env.key != "" {
+				delete(q.conflated, env.key)
+			}
+		}
+	}
+	q.base[level] += n
+	return batch
+}
+
+// putBack returns unprocessed rest of batch
+// to the front of its level.
+//
+// Message whose key has been queued again meanwhile is stale,
+// so it's not put back. Returns such messages.
+func (q *messageQueue) putBack(level Priority, batch []envelope) []envelope {
+	var replaced []envelope
+	if q.conflated != nil {
+		kept := make([]envelope, 0, len(batch))
+		for _, env := range batch {
+			if q.conflates(env.key) {
+				replaced = append(replaced, env)
+				continue
+			}
+			kept = append(kept, env)
+		}
+		batch = kept
+	}
+
+	q.levels[level] = append(batch[:len(batch):len(batch)], q.levels[level]...)
+	q.queued[level].Add(int64(len(batch)))
+	q.base[level] -= len(batch)
+	if q.conflated != nil {
+		for i, env := range batch {
+			if env.key != "" {
+				q.conflated[env.key] = queuePos{level: level, index: q.base[level] + i}
+			}
+		}
+	}
+	return replaced
+}
+
+// preempted reports whether any level higher than given one
+// has messages. Always false with weighted priorities.
+//
+// Safe to call without lock.
+func (q *messageQueue) preempted(level Priority) bool {
+	if q.weights != nil {
+		return false
+	}
+	for higher := int(level) + 1; higher < PriorityLevels; higher++ {
+		if q.queued[higher].Load() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clear removes every message, returns removed ones.
+func (q *messageQueue) clear() []envelope {
+	var removed []envelope
+	for level := range q.levels {
+		removed = append(removed, q.levels[level]...)
+		q.base[level] += len(q.levels[level])
+		q.levels[level] = nil
+		q.queued[level].Store(0)
+	}
+	if q.conflated != nil {
+		clear(q.conflated)
+	}
+	return removed
+}
+
+func capped(n, limit int) int {
+	if limit > 0 {
+		return min(n, limit)
+	}
+	return n
+}
+
+func newMessageQueue(conflating bool, weights []int) *messageQueue {
+	q := &messageQueue{}
+	if conflating {
+		q.conflated = make(map[string]queuePos)
+	}
+	if weights != nil {
+		q.weights = make([]int, PriorityLevels)
+		for level := range q.weights {
+			q.weights[level] = 1
+			if level < len(weights) && weights[level] > 1 {
+				q.weights[level] = weights[level]
+			}
+		}
+		q.turn = Priority(PriorityLevels - 1)
+	}
+	return q
+}
@@ -0,0 +1,67 @@
+package subpub
+
+import (
+	"context"
+	"time"
+)
+
+// tokenBucket limits rate of deliveries of one subscription.
+//
+// Used by processor only, so it's not synchronized.
+// Nil bucket means no limit.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait blocks until there are n tokens in bucket
+// without taking them.
+//
+// Returns false if ctx is done first.
+func (b *tokenBucket) wait(ctx context.Context, n int) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		b.refill(time.Now())
+		if b.tokens >= float64(n) {
+			return true
+		}
+
+		lack := float64(n) - b.tokens
+		timer := time.NewTimer(time.Duration(lack / b.rate * float64(time.Second)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// take spends n tokens, which must be awaited by wait first.
+func (b *tokenBucket) take(n int) {
+	if b == nil {
+		return
+	}
+	b.tokens -= float64(n)
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
@@ -0,0 +1,276 @@
+package subpub
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrScheduledNotFound = errors.New("scheduled message not found")
+
+// ScheduledMessage is a message waiting for its delivery time.
+type ScheduledMessage struct {
+	ID        uint64
+	Subject   string
+	Msg       interface{}
+	DeliverAt time.Time
+
+	opts []PublishOption
+}
+
+// scheduleHeap is a min-heap of messages by delivery time,
+// ties are broken by ID to keep scheduling order.
+type scheduleHeap []*scheduledItem
+
+type scheduledItem struct {
+	ScheduledMessage
+	index int
+}
+
+func (h scheduleHeap) Len() int { return len(h) }
+
+func (h scheduleHeap) Less(i, j int) bool {
+	if h[i].DeliverAt.Equal(h[j].DeliverAt) {
+		return h[i].ID < h[j].ID
+	}
+	return h[i].DeliverAt.Before(h[j].DeliverAt)
+}
+
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x any) {
+	item := x.(*scheduledItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	item.index = -1
+	return item
+}
+
+// scheduler publishes messages at their delivery time.
+//
+// Its goroutine is started on the first scheduled message
+// and stopped on stop call, dropping whatever is left.
+type scheduler struct {
+	mut    *sync.Mutex
+	queue  scheduleHeap
+	byID   map[uint64]*scheduledItem
+	nextID uint64
+
+	publish func(subject string, msg interface{}, opts ...PublishOption) error
+
+	start   *sync.Once
+	started bool
+	stopped bool
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// Schedule adds message to the queue and returns its ID.
+//
+// Returns false if scheduler is already stopped.
+func (s *scheduler) Schedule(subject string, msg interface{}, at time.Time, opts []PublishOption) (uint64, bool) {
+	s.mut.Lock()
+	if s.stopped {
+		s.mut.Unlock()
+		return 0, false
+	}
+	s.nextID++
+	item := &scheduledItem{ScheduledMessage: ScheduledMessage{
+		ID:        s.nextID,
+		Subject:   subject,
+		Msg:       msg,
+		DeliverAt: at,
+		opts:      opts,
+	}}
+	heap.Push(&s.queue, item)
+	s.byID[item.ID] = item
+	s.started = true
+	s.mut.Unlock()
+
+	s.start.Do(func() { go s.run() })
+	s.notify()
+
+	return item.ID, true
+}
+
+// Cancel removes message with given id from the queue.
+func (s *scheduler) Cancel(id uint64) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	item, ok := s.byID[id]
+	if !ok {
+		return ErrScheduledNotFound
+	}
+	heap.Remove(&s.queue, item.index)
+	delete(s.byID, id)
+	return nil
+}
+
+// Pending returns messages waiting for delivery sorted by delivery time.
+func (s *scheduler) Pending() []ScheduledMessage {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	pending := make([]ScheduledMessage, 0, len(s.queue))
+	for _, item := range s.queue {
+		pending = append(pending, item.ScheduledMessage)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].DeliverAt.Equal(pending[j].DeliverAt) {
+			return pending[i].ID < pending[j].ID
+		}
+		return pending[i].DeliverAt.Before(pending[j].DeliverAt)
+	})
+	return pending
+}
+
+// Reserve checks that IDs of messages are free
+// and makes sure they are never given to new messages.
+func (s *scheduler) Reserve(messages []ScheduledSnapshot) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	ids := make(map[uint64]struct{}, len(messages))
+	for _, msg := range messages {
+		if _, ok := s.byID[msg.ID]; ok {
+			return fmt.Errorf("%w: scheduled message %d exists already", ErrInvalidSnapshot, msg.ID)
+		}
+		if _, ok := ids[msg.ID]; ok || msg.ID == 0 {
+			return fmt.Errorf("%w: invalid ID %d of scheduled message", ErrInvalidSnapshot, msg.ID)
+		}
+		ids[msg.ID] = struct{}{}
+	}
+	for _, msg := range messages {
+		s.nextID = max(s.nextID, msg.ID)
+	}
+	return nil
+}
+
+// Restore adds messages with IDs reserved by Reserve.
+//
+// Messages are dropped if scheduler is already stopped.
+func (s *scheduler) Restore(messages []ScheduledSnapshot) {
+	if len(messages) == 0 {
+		return
+	}
+
+	s.mut.Lock()
+	if s.stopped {
+		s.mut.Unlock()
+		return
+	}
+	for _, msg := range messages {
+		item := &scheduledItem{ScheduledMessage: ScheduledMessage{
+			ID:        msg.ID,
+			Subject:   msg.Subject,
+			Msg:       msg.Data,
+			DeliverAt: msg.DeliverAt,
+			opts:      msg.options(),
+		}}
+		heap.Push(&s.queue, item)
+		s.byID[item.ID] = item
+	}
+	s.started = true
+	s.mut.Unlock()
+
+	s.start.Do(func() { go s.run() })
+	s.notify()
+}
+
+// notify wakes run up to recalculate its timer.
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes due messages and sleeps until the next one.
+//
+// Blocking call, should be used in goroutine.
+func (s *scheduler) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mut.Lock()
+		var due []*scheduledItem
+		for len(s.queue) > 0 && !s.queue[0].DeliverAt.After(time.Now()) {
+			item := heap.Pop(&s.queue).(*scheduledItem)
+			delete(s.byID, item.ID)
+			due = append(due, item)
+		}
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].DeliverAt)
+		}
+		s.mut.Unlock()
+
+		for _, item := range due {
+			_ = s.publish(item.Subject, item.Msg, item.opts...)
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Stop drops all scheduled messages, stops scheduler goroutine
+// and waits for it to exit.
+//
+// Returns number of dropped messages.
+func (s *scheduler) Stop() int {
+	s.mut.Lock()
+	if s.stopped {
+		s.mut.Unlock()
+		return 0
+	}
+	s.stopped = true
+	started := s.started
+	dropped := len(s.queue)
+	s.queue = nil
+	s.byID = nil
+	s.mut.Unlock()
+
+	if started {
+		close(s.quit)
+		<-s.done
+	}
+	return dropped
+}
+
+func newScheduler(publish func(subject string, msg interface{}, opts ...PublishOption) error) *scheduler {
+	return &scheduler{
+		mut:     &sync.Mutex{},
+		byID:    make(map[uint64]*scheduledItem),
+		publish: publish,
+		start:   &sync.Once{},
+		wake:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
@@ -0,0 +1,157 @@
+package subpub
+
+import (
+	"context"
+	"sort"
+)
+
+// ShutdownReport describes what was left behind
+// when shutdown couldn't finish gracefully.
+type ShutdownReport struct {
+	// Forced is true if hard stop was performed.
+	Forced bool
+
+	// Subjects lists only subjects with at least one
+	// subscription that has something left, sorted by subject.
+	Subjects []SubjectReport
+}
+
+// Clean reports whether nothing was left behind.
+func (r ShutdownReport) Clean() bool {
+	return len(r.Subjects) == 0
+}
+
+// Undelivered returns total number of undelivered messages.
+func (r ShutdownReport) Undelivered() int64 {
+	var total int64
+	for _, subject := range r.Subjects {
+		for _, sub := range subject.Subscriptions {
+			total += sub.Undelivered
+		}
+	}
+	return total
+}
+
+// RunningHandlers returns total number of handlers
+// which were still running.
+func (r ShutdownReport) RunningHandlers() int {
+	var total int
+	for _, subject := range r.Subjects {
+		for _, sub := range subject.Subscriptions {
+			if sub.HandlerRunning {
+				total++
+			}
+		}
+	}
+	return total
+}
+
+type SubjectReport struct {
+	Subject string
+
+	// Subscriptions are sorted by ID.
+	Subscriptions []SubscriptionReport
+}
+
+type SubscriptionReport struct {
+	// ID of subscription, unique within system.
+	ID int64
+
+	// Undelivered is number of messages which never reached handler.
+	// In forced shutdown those are dropped.
+	Undelivered int64
+
+	// HandlerRunning is true if handler was still processing message.
+	// In forced shutdown it's detached with its context cancelled.
+	HandlerRunning bool
+}
+
+// Clean reports whether subscription has nothing left.
+func (r SubscriptionReport) Clean() bool {
+	return r.Undelivered == 0 && !r.HandlerRunning
+}
+
+type shutdownConfig struct {
+	hardStop bool
+}
+
+type ShutdownOption func(*shutdownConfig)
+
+// WithHardStop makes Shutdown stop forcibly once ctx is done:
+// queued messages are dropped, running handlers are detached
+// with their contexts cancelled and system becomes closed.
+func WithHardStop() ShutdownOption {
+	return func(cfg *shutdownConfig) {
+		cfg.hardStop = true
+	}
+}
+
+// Shutdown closes system the same way Close does,
+// but reports what was left behind if ctx is done first.
+//
+// On graceful shutdown returns empty report and nil.
+//
+// Otherwise returns ctx.Err() and report with undelivered messages
+// and running handlers at the moment ctx was done. Without hard stop
+// system stays draining and closing continues in background,
+// with WithHardStop system is closed right away.
+//
+// Unlike Close, Shutdown starts closing even if ctx is done already,
+// then the next Close call does the closing.
+func (s *subpub) Shutdown(ctx context.Context, opts ...ShutdownOption) (ShutdownReport, error) {
+	var cfg shutdownConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if s.state.Load() != StateClosed {
+		s.startClosing()
+	}
+	err := s.Close(ctx)
+	if err == nil {
+		return ShutdownReport{}, nil
+	}
+
+	if !cfg.hardStop {
+		return s.collectReport(func(b *broadcaster) []SubscriptionReport {
+			if b.state.Load() == StateClosed {
+				return nil
+			}
+			return b.report()
+		}), err
+	}
+
+	s.drain()
+
+	report := s.collectReport((*broadcaster).abort)
+	report.Forced = true
+	for _, t := range s.topics {
+		t.abort()
+	}
+
+	if s.state.Transition(StateDraining, StateClosed) {
+		s.emit(SysEvent{Type: SysCloseFinished})
+	}
+	return report, err
+}
+
+// collectReport builds report out of every broadcaster.
+func (s *subpub) collectReport(collect func(*broadcaster) []SubscriptionReport) ShutdownReport {
+	var report ShutdownReport
+	s.broadcasters.Range(func(key, value any) bool {
+		subs := collect(value.(*broadcaster))
+		if len(subs) == 0 {
+			return true
+		}
+		sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+		report.Subjects = append(report.Subjects, SubjectReport{
+			Subject:       key.(string),
+			Subscriptions: subs,
+		})
+		return true
+	})
+	sort.Slice(report.Subjects, func(i, j int) bool {
+		return report.Subjects[i].Subject < report.Subjects[j].Subject
+	})
+	return report
+}
@@ -0,0 +1,254 @@
+package subpub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrInvalidSnapshot is returned by Restore for snapshot
+// which doesn't fit the system.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot is state of system which outlives its subscriptions:
+// subjects, messages retained in partitions for consumer groups,
+// offsets of groups and scheduled messages. See SubPub.Snapshot.
+type Snapshot struct {
+	TakenAt time.Time
+
+	// Subjects are sorted by subject.
+	Subjects []SubjectSnapshot
+
+	// Topics are partitioned subjects, sorted by subject.
+	Topics []TopicSnapshot
+
+	// Scheduled are sorted by delivery time.
+	Scheduled []ScheduledSnapshot
+}
+
+type SubjectSnapshot struct {
+	Subject string
+
+	// Published is number of messages published to subject.
+	Published uint64
+}
+
+// TopicSnapshot is partitioned subject.
+type TopicSnapshot struct {
+	Subject string
+
+	// Partitions are indexed by partition.
+	Partitions []PartitionSnapshot
+
+	// Groups are sorted by group.
+	Groups []GroupSnapshot
+}
+
+type PartitionSnapshot struct {
+	// Base is offset of the first message.
+	Base int64
+
+	Messages []RetainedMessage
+}
+
+// RetainedMessage is message kept in partition.
+type RetainedMessage struct {
+	Data    interface{}
+	Headers map[string]string
+
+	// PublishedAt and ExpiresAt are set only for messages with TTL.
+	PublishedAt time.Time
+	ExpiresAt   time.Time
+}
+
+type GroupSnapshot struct {
+	Group string
+
+	// Offsets are committed offsets indexed by partition.
+	Offsets []int64
+}
+
+// ScheduledSnapshot is scheduled message along with
+// options it's published with.
+type ScheduledSnapshot struct {
+	ID        uint64
+	Subject   string
+	Data      interface{}
+	DeliverAt time.Time
+
+	Headers        map[string]string
+	TTL            time.Duration
+	IdempotencyKey string
+	Priority       Priority
+	PartitionKey   string
+}
+
+// options rebuilds options message is published with.
+func (m ScheduledSnapshot) options() []PublishOption {
+	var opts []PublishOption
+	if m.Headers != nil {
+		opts = append(opts, WithHeaders(m.Headers))
+	}
+	if m.TTL > 0 {
+		opts = append(opts, WithTTL(m.TTL))
+	}
+	if m.IdempotencyKey != "" {
+		opts = append(opts, WithIdempotencyKey(m.IdempotencyKey))
+	}
+	if m.Priority != 0 {
+		opts = append(opts, WithPriority(m.Priority))
+	}
+	if m.PartitionKey != "" {
+		opts = append(opts, WithPartitionKey(m.PartitionKey))
+	}
+	return opts
+}
+
+// Snapshot captures state of system, which is consistent per subject,
+// but not across subjects. Messages queued for subscriptions are not
+// captured, nor are idempotency keys of dedup windows.
+func (s *subpub) Snapshot() Snapshot {
+	snapshot := Snapshot{TakenAt: s.cfg.clock.Now()}
+
+	s.broadcasters.Range(func(key, value any) bool {
+		b := value.(*broadcaster)
+		snapshot.Subjects = append(snapshot.Subjects, SubjectSnapshot{
+			Subject:   b.subject,
+			Published: b.published.Load(),
+		})
+		return true
+	})
+	sort.Slice(snapshot.Subjects, func(i, j int) bool {
+		return snapshot.Subjects[i].Subject < snapshot.Subjects[j].Subject
+	})
+
+	for _, t := range s.topics {
+		snapshot.Topics = append(snapshot.Topics, t.snapshot())
+	}
+	sort.Slice(snapshot.Topics, func(i, j int) bool {
+		return snapshot.Topics[i].Subject < snapshot.Topics[j].Subject
+	})
+
+	for _, msg := range s.scheduler.Pending() {
+		cfg := newPublishConfig(msg.opts)
+		snapshot.Scheduled = append(snapshot.Scheduled, ScheduledSnapshot{
+			ID:        msg.ID,
+			Subject:   msg.Subject,
+			Data:      msg.Msg,
+			DeliverAt: msg.DeliverAt,
+
+			Headers:        cfg.headers,
+			TTL:            cfg.ttl,
+			IdempotencyKey: cfg.idempotencyKey,
+			Priority:       cfg.priority,
+			PartitionKey:   cfg.partitionKey,
+		})
+	}
+	return snapshot
+}
+
+// Restore loads snapshot into fresh system.
+//
+// Published counters of subjects are added to current ones. Partitioned
+// subjects of snapshot must be set up with WithPartitions with the same
+// number of partitions, and must have neither messages nor groups yet.
+// Groups are restored without members, so they continue from their
+// offsets once members join. Scheduled messages keep their IDs and
+// messages which are due already are published right away.
+//
+// Returns error wrapping ErrInvalidSnapshot if snapshot doesn't fit,
+// then nothing is restored, or *StateError if system is not open.
+func (s *subpub) Restore(snapshot Snapshot) error {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return s.stateErr("restore", "")
+	}
+
+	topics := make([]*partitionedTopic, 0, len(snapshot.Topics))
+	for _, ts := range snapshot.Topics {
+		t, ok := s.topics[ts.Subject]
+		if !ok {
+			return fmt.Errorf("%w: %q is not partitioned", ErrInvalidSnapshot, ts.Subject)
+		}
+		if err := ts.validate(len(t.partitions)); err != nil {
+			return err
+		}
+		topics = append(topics, t)
+	}
+
+	// topics are locked in order of subject, as in transactions
+	sort.Slice(topics, func(i, j int) bool { return topics[i].subject < topics[j].subject })
+	for i, t := range topics {
+		if i > 0 && t == topics[i-1] {
+			return fmt.Errorf("%w: %q is repeated", ErrInvalidSnapshot, t.subject)
+		}
+	}
+	for _, t := range topics {
+		t.mut.Lock()
+	}
+	unlock := func() {
+		for _, t := range topics {
+			t.mut.Unlock()
+		}
+	}
+	for _, t := range topics {
+		if !t.fresh() {
+			unlock()
+			return fmt.Errorf("%w: %q has messages or groups already", ErrInvalidSnapshot, t.subject)
+		}
+	}
+	if err := s.scheduler.Reserve(snapshot.Scheduled); err != nil {
+		unlock()
+		return err
+	}
+
+	for _, ts := range snapshot.Topics {
+		s.topics[ts.Subject].restore(ts)
+	}
+	unlock()
+	for _, t := range topics {
+		t.wake()
+	}
+
+	// topics without subscribers are not reported as created
+	for _, ss := range snapshot.Subjects {
+		newBroadcast := newBroadcaster(ss.Subject, s.cfg.ttl(ss.Subject), s.sys)
+		b, _ := s.broadcasters.LoadOrStore(ss.Subject, &newBroadcast)
+		b.(*broadcaster).published.Add(ss.Published)
+	}
+
+	s.scheduler.Restore(snapshot.Scheduled)
+	return nil
+}
+
+// validate checks snapshot of topic with given number of partitions.
+func (ts TopicSnapshot) validate(partitions int) error {
+	if len(ts.Partitions) != partitions {
+		return fmt.Errorf("%w: %q has %d partitions instead of %d", ErrInvalidSnapshot, ts.Subject, len(ts.Partitions), partitions)
+	}
+	for i, p := range ts.Partitions {
+		if p.Base < 0 {
+			return fmt.Errorf("%w: negative base of partition %d of %q", ErrInvalidSnapshot, i, ts.Subject)
+		}
+	}
+	groups := make(map[string]struct{}, len(ts.Groups))
+	for _, gs := range ts.Groups {
+		if _, ok := groups[gs.Group]; ok {
+			return fmt.Errorf("%w: group %q of %q is repeated", ErrInvalidSnapshot, gs.Group, ts.Subject)
+		}
+		groups[gs.Group] = struct{}{}
+		if len(gs.Offsets) != partitions {
+			return fmt.Errorf("%w: group %q of %q has %d offsets instead of %d", ErrInvalidSnapshot, gs.Group, ts.Subject, len(gs.Offsets), partitions)
+		}
+		for i, offset := range gs.Offsets {
+			p := ts.Partitions[i]
+			if offset < p.Base || offset > p.Base+int64(len(p.Messages)) {
+				return fmt.Errorf("%w: offset %d of group %q is out of partition %d of %q", ErrInvalidSnapshot, offset, gs.Group, i, ts.Subject)
+			}
+		}
+	}
+	return nil
+}
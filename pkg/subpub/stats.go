@@ -0,0 +1,174 @@
+package subpub
+
+import "sort"
+
+// Stats is a point-in-time snapshot of counters of subpub system.
+type Stats struct {
+	// Subjects are sorted by subject.
+	Subjects []SubjectStats
+
+	// Groups are consumer groups of partitioned subjects,
+	// sorted by subject and group.
+	Groups []GroupStats
+}
+
+type GroupStats struct {
+	Subject string
+	Group   string
+
+	// Members are IDs of subscriptions of group, sorted.
+	Members []int64
+
+	// Partitions are indexed by partition.
+	Partitions []PartitionStats
+}
+
+type PartitionStats struct {
+	// Owner is ID of member partition is assigned to,
+	// zero if group has no members.
+	Owner int64
+
+	// Offset is committed offset of group,
+	// the next message it processes.
+	Offset int64
+
+	// End is offset of the next message published to partition.
+	End int64
+
+	// Expired is number of messages skipped because of TTL.
+	Expired uint64
+
+	// Dropped is number of messages group has never got,
+	// because they were over retention of partition.
+	Dropped uint64
+}
+
+// Lag returns number of messages group hasn't processed yet.
+func (s PartitionStats) Lag() int64 {
+	return s.End - s.Offset
+}
+
+type SubjectStats struct {
+	Subject string
+	State   State
+
+	// Published is number of messages published to subject.
+	Published uint64
+
+	// Subscriptions are sorted by ID.
+	Subscriptions []SubscriptionStats
+}
+
+// Expired returns number of expired messages
+// over all subscriptions of subject.
+func (s SubjectStats) Expired() uint64 {
+	var total uint64
+	for _, sub := range s.Subscriptions {
+		total += sub.Expired
+	}
+	return total
+}
+
+type SubscriptionStats struct {
+	ID    int64
+	State State
+
+	// Paused is set between Pause and Resume.
+	Paused bool
+
+	// Queued is number of messages not yet passed to handler.
+	Queued int64
+
+	// Delivered is number of messages handler has finished with.
+	Delivered uint64
+
+	// Expired is number of messages skipped because of TTL.
+	Expired uint64
+
+	// Conflated is number of messages replaced
+	// by newer ones with the same key.
+	Conflated uint64
+
+	// Dropped is number of messages dropped by overflow policy
+	// of queue or channel, or abandoned on Unsubscribe
+	// of channel subscription.
+	Dropped uint64
+
+	// Filtered is number of messages
+	// short-circuited by delivery interceptors.
+	Filtered uint64
+
+	// Unacked is number of messages delivered in ack mode,
+	// which are not acked yet.
+	Unacked int
+
+	// Redelivered is number of messages delivered again,
+	// because they were not acked in time.
+	Redelivered uint64
+
+	// NotAcked is number of messages sent to dead letters,
+	// because they were not acked after max deliveries.
+	NotAcked uint64
+}
+
+// Stats collects counters of every subject and subscription.
+func (s *subpub) Stats() Stats {
+	var stats Stats
+	s.broadcasters.Range(func(key, value any) bool {
+		stats.Subjects = append(stats.Subjects, value.(*broadcaster).stats())
+		return true
+	})
+	sort.Slice(stats.Subjects, func(i, j int) bool {
+		return stats.Subjects[i].Subject < stats.Subjects[j].Subject
+	})
+
+	for _, t := range s.topics {
+		stats.Groups = append(stats.Groups, t.stats()...)
+	}
+	sort.Slice(stats.Groups, func(i, j int) bool {
+		if stats.Groups[i].Subject != stats.Groups[j].Subject {
+			return stats.Groups[i].Subject < stats.Groups[j].Subject
+		}
+		return stats.Groups[i].Group < stats.Groups[j].Group
+	})
+	return stats
+}
+
+func (b *broadcaster) stats() SubjectStats {
+	subs := *b.subscriptions.Load()
+	stats := SubjectStats{
+		Subject:       b.subject,
+		State:         b.state.Load(),
+		Published:     b.published.Load(),
+		Subscriptions: make([]SubscriptionStats, 0, len(subs)),
+	}
+	for _, sub := range subs {
+		stats.Subscriptions = append(stats.Subscriptions, sub.stats())
+	}
+	sort.Slice(stats.Subscriptions, func(i, j int) bool {
+		return stats.Subscriptions[i].ID < stats.Subscriptions[j].ID
+	})
+	return stats
+}
+
+func (s *subscription) stats() SubscriptionStats {
+	s.mut.Lock()
+	unacked := len(s.unacked)
+	s.mut.Unlock()
+
+	return SubscriptionStats{
+		ID:        s.id,
+		State:     s.state.Load(),
+		Paused:    s.paused.Load(),
+		Queued:    max(s.pending.Load(), 0),
+		Delivered: s.delivered.Load(),
+		Expired:   s.expired.Load(),
+		Conflated: s.replaced.Load(),
+		Dropped:   s.dropped.Load(),
+		Filtered:  s.filtered.Load(),
+
+		Unacked:     unacked,
+		Redelivered: s.redelivered.Load(),
+		NotAcked:    s.notAcked.Load(),
+	}
+}
@@ -3,101 +3,519 @@ package subpub
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	ErrClosed      = errors.New("subpub system is closed")
+	ErrDraining    = errors.New("subpub system is draining")
 	ErrTopicClosed = errors.New("this topic is closed")
+
+	// errDuplicate is never returned to user,
+	// duplicates are acknowledged as published.
+	errDuplicate = errors.New("duplicate message")
 )
 
 type subpub struct {
+	cfg config
+
 	// used sync.Map because I expect not a lot of topics
 	// but lots of publishing
 	broadcasters sync.Map // map[string]*broadcaster
-	closed       bool
+
+	// mut is taken for reading by every Subscribe/Publish
+	// and for writing only by Close to move out of StateOpen.
+	// So once Close has left StateOpen no operation is in flight
+	// and no new one can start.
+	mut   *sync.RWMutex
+	state *lifecycle
+
+	// closeMut serializes closing passes,
+	// so repeated Close calls continue the previous one.
+	closeMut *sync.Mutex
+
+	scheduler *scheduler
+	dedup     *deduplicator
+
+	// watchdog is nil unless enabled.
+	watchdog *watchdog
+
+	closeStarted *sync.Once
+
+	// sys is shared by all broadcasters.
+	sys *system
+
+	// mapping is replaced at runtime, nil if subjects are not mapped.
+	mapping *atomic.Pointer[SubjectMapping]
+
+	// topics are partitioned subjects, never changed after creation.
+	topics map[string]*partitionedTopic
+}
+
+// stateErr builds error for operation rejected in the current state.
+func (s *subpub) stateErr(op, subject string) error {
+	state := s.state.Load()
+	err := ErrClosed
+	if state == StateDraining {
+		err = ErrDraining
+	}
+	return &StateError{Op: op, Subject: subject, State: state, Err: err}
 }
 
 // Subscribe searches for broadcaster on given subject,
 // creates it if there is none and initializes new subscriber.
-func (s *subpub) Subscribe(subject string, cb MessageHandler) (Subscription, error) {
-	if s.closed {
-		return nil, ErrClosed
+//
+// Returns *StateError wrapping ErrDraining or ErrClosed
+// if system is not open, and ErrAckUnsupported for WithAck.
+func (s *subpub) Subscribe(subject string, cb MessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	return s.SubscribeContext(subject, func(_ context.Context, msg interface{}) {
+		cb(msg)
+	}, opts...)
+}
+
+// SubscribeContext does the same as Subscribe, but handler also
+// receives context of subscription.
+func (s *subpub) SubscribeContext(subject string, cb ContextMessageHandler, opts ...SubscribeOption) (Subscription, error) {
+	return s.subscribe(subject, handler{cb: cb}, newSubscribeConfig(s.cfg.subscribeDefaults, opts))
+}
+
+// SubscribeBatch does the same as Subscribe, but handler
+// receives queued messages in batches configured by WithBatching.
+// Every batch holds messages of the same priority.
+func (s *subpub) SubscribeBatch(subject string, cb BatchHandler, opts ...SubscribeOption) (Subscription, error) {
+	return s.subscribe(subject, handler{batch: cb}, newSubscribeConfig(s.cfg.subscribeDefaults, opts))
+}
+
+// subscribe creates subscription delivering messages to h.
+func (s *subpub) subscribe(subject string, h handler, cfg subscribeConfig) (Subscription, error) {
+	if cfg.ackWait > 0 && h.cb != nil {
+		return nil, fmt.Errorf("%w: %q", ErrAckUnsupported, subject)
+	}
+
+	sub, subscribers, err := s.register(subject, h, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// events are published only after s.mut is released
+	if subscribers == 1 {
+		s.emit(SysEvent{Type: SysTopicCreated, Subject: subject})
+	}
+	s.emit(SysEvent{Type: SysSubscriberJoined, Subject: subject, SubscriptionID: sub.id, Subscribers: subscribers})
+
+	return sub, nil
+}
+
+// register creates and starts subscription.
+//
+// Returns number of subscribers including it.
+func (s *subpub) register(subject string, h handler, cfg subscribeConfig) (*subscription, int, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return nil, 0, s.stateErr("subscribe", subject)
 	}
 
-	newBroadcast := newBroadcaster()
+	newBroadcast := newBroadcaster(subject, s.cfg.ttl(subject), s.sys)
 	bAny, _ := s.broadcasters.LoadOrStore(subject, &newBroadcast)
 	b := bAny.(*broadcaster)
 
 	id := b.GetNextId()
 
-	sub := newSubscription(id, cb, b)
+	cfg.watched = s.watchdog != nil
+	cfg.interceptors = append(slices.Clone(s.cfg.deliveryInterceptors), cfg.interceptors...)
+	sub := newSubscription(id, h, b, cfg)
 
-	b.RegisterSub(sub, id)
+	subscribers, err := b.RegisterSub(sub)
+	if err != nil {
+		return nil, 0, &StateError{Op: "subscribe", Subject: subject, State: b.state.Load(), Err: ErrTopicClosed}
+	}
 
 	sub.Start()
 
-	return sub, nil
+	return sub, subscribers, nil
 }
 
-// Publish passes subject to broadcaster on subject if there is any.
+// SubscribeGroup joins member to consumer group of partitioned
+// subject, see WithPartitions. Group is created on the first join,
+// starting from the end of every partition, and keeps its offsets
+// once all members have left.
 //
-// On empty subject returns nil.
+// Every partition is assigned to one member and its messages are
+// passed to handler one by one in order. Partitions are rebalanced
+// every time member joins or leaves, then partition moves to new
+// member only after the old one has finished with its message.
+// Offset of message is committed once handler returns.
+//
+// Options are ignored except delivery interceptors, and WithAck
+// is rejected with ErrAckUnsupported.
 //
-// If broadcaster was closed during Close call, but it wasn't successful
-// (context got canceled) returns ErrTopicClosed.
-func (s *subpub) Publish(subject string, msg interface{}) error {
-	if s.closed {
-		return ErrClosed
+// Returns error wrapping ErrNotPartitioned for other subjects,
+// and the same errors as Subscribe if system is not open.
+func (s *subpub) SubscribeGroup(subject, group string, cb GroupHandler, opts ...SubscribeOption) (Subscription, error) {
+	return s.join(subject, group, cb, nil, opts)
+}
+
+// SubscribeGroupChan does the same as SubscribeGroup, but delivers
+// messages to returned unbuffered channel, which is closed once
+// member has left the group or system is closed.
+//
+// Offset of message is committed once it's received from channel.
+// Message which wasn't received before Unsubscribe is delivered
+// to the next owner of its partition.
+func (s *subpub) SubscribeGroupChan(subject, group string, opts ...SubscribeOption) (<-chan GroupMessage, Subscription, error) {
+	ch := make(chan GroupMessage)
+	sub, err := s.join(subject, group, nil, ch, opts)
+	if err != nil {
+		return nil, nil, err
 	}
+	return ch, sub, nil
+}
 
-	bAny, ok := s.broadcasters.Load(subject)
+// join starts member of group passing messages to cb, or to ch if cb is nil.
+func (s *subpub) join(subject, group string, cb GroupHandler, ch chan GroupMessage, opts []SubscribeOption) (Subscription, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return nil, s.stateErr("subscribe", subject)
+	}
+	t, ok := s.topics[subject]
 	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotPartitioned, subject)
+	}
+
+	cfg := newSubscribeConfig(s.cfg.subscribeDefaults, opts)
+	if cfg.ackWait > 0 {
+		return nil, fmt.Errorf("%w: %q", ErrAckUnsupported, subject)
+	}
+	m := newMember(s.sys.nextID(), cb, ch, append(slices.Clone(s.cfg.deliveryInterceptors), cfg.interceptors...))
+	if !t.join(group, m) {
+		return nil, s.stateErr("subscribe", subject)
+	}
+	go m.run()
+
+	return m, nil
+}
+
+// Publish passes subject to broadcaster on subject if there is any.
+//
+// On empty subject returns nil.
+//
+// Returns *StateError wrapping ErrDraining or ErrClosed
+// if system is not open, or wrapping ErrTopicClosed
+// if only the topic is closed.
+func (s *subpub) Publish(subject string, msg interface{}, opts ...PublishOption) error {
+	return s.PublishContext(context.Background(), subject, msg, opts...)
+}
+
+// PublishContext does the same as Publish, but passes ctx to publish
+// interceptors, e.g. to continue trace of caller. Publishing never
+// waits, so cancellation of ctx doesn't matter to system itself.
+func (s *subpub) PublishContext(ctx context.Context, subject string, msg interface{}, opts ...PublishOption) error {
+	return s.intercept(ctx, subject, msg, newPublishConfig(opts), s.send, s.send)
+}
+
+// send does the same as Publish, but bypasses interceptors.
+func (s *subpub) send(subject string, msg interface{}, cfg publishConfig) error {
+	_, err := s.publish("publish", subject, msg, cfg, false)
+	if err == errDuplicate {
 		return nil
 	}
-	if err := bAny.(*broadcaster).Publish(msg); err != nil {
-		return ErrTopicClosed
+	return err
+}
+
+// publish passes msg to broadcaster on subject if there is any.
+//
+// Tracker is returned only if tracked is true
+// and there is broadcaster on subject.
+//
+// Returns errDuplicate if message with the same
+// idempotency key was already published.
+func (s *subpub) publish(op, subject string, msg interface{}, cfg publishConfig, tracked bool) (*deliveryTracker, error) {
+	tracker, dropped, err := s.publishLocked(op, subject, msg, cfg, tracked)
+
+	// dead letters are published only after s.mut is released,
+	// otherwise they would wait for Close waiting for publisher
+	for _, d := range dropped {
+		d.sub.overflow(&d.env)
 	}
-	return nil
+	return tracker, err
+}
+
+// publishLocked does the same as publish under s.mut,
+// returning messages dropped by overflow.
+func (s *subpub) publishLocked(op, subject string, msg interface{}, cfg publishConfig, tracked bool) (*deliveryTracker, []overflowed, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return nil, nil, s.stateErr(op, subject)
+	}
+
+	if cfg.idempotencyKey != "" && !s.dedup.Record(subject, cfg.idempotencyKey) {
+		return nil, nil, errDuplicate
+	}
+
+	var (
+		tracker *deliveryTracker
+		dropped []overflowed
+	)
+	if bAny, ok := s.broadcasters.Load(subject); ok {
+		b := bAny.(*broadcaster)
+		var err error
+		tracker, dropped, err = b.Publish(msg, cfg, tracked)
+		if err != nil {
+			// message is not published, so retry must not be deduplicated
+			if cfg.idempotencyKey != "" {
+				s.dedup.Forget(subject, cfg.idempotencyKey)
+			}
+			return nil, nil, &StateError{Op: op, Subject: subject, State: b.state.Load(), Err: ErrTopicClosed}
+		}
+	} else {
+		s.cfg.metrics.MessagePublished(subject)
+	}
+
+	// message goes to partition only once it's published, so that
+	// groups never get message publisher was told has failed;
+	// system is open, so topic is too
+	if t, ok := s.topics[subject]; ok {
+		t.append(msg, cfg)
+	}
+	return tracker, dropped, nil
+}
+
+// SubjectMapping returns current mapping of subjects,
+// nil if subjects are not mapped.
+func (s *subpub) SubjectMapping() *SubjectMapping {
+	return s.mapping.Load()
+}
+
+// SetSubjectMapping replaces mapping of subjects, nil disables it.
+// Messages already published are not affected.
+func (s *subpub) SetSubjectMapping(mapping *SubjectMapping) {
+	s.mapping.Store(mapping)
+}
+
+// PublishAt schedules msg to be published to subject at given time.
+// Time in the past means as soon as possible.
+//
+// Returns ID of scheduled message, which can be used to cancel it.
+// Errors are the same as for Publish, but are checked
+// at scheduling time only. If topic turns out to be closed
+// at delivery time, message is dropped.
+//
+// Options are applied at delivery time, so TTL
+// starts counting from there.
+func (s *subpub) PublishAt(subject string, msg interface{}, at time.Time, opts ...PublishOption) (uint64, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return 0, s.stateErr("publish at", subject)
+	}
+
+	id, ok := s.scheduler.Schedule(subject, msg, at, opts)
+	if !ok {
+		return 0, s.stateErr("publish at", subject)
+	}
+	return id, nil
+}
+
+// PublishAfter schedules msg to be published to subject after delay.
+//
+// Refer to PublishAt for details.
+func (s *subpub) PublishAfter(subject string, msg interface{}, delay time.Duration, opts ...PublishOption) (uint64, error) {
+	return s.PublishAt(subject, msg, time.Now().Add(delay), opts...)
+}
+
+// Scheduled returns messages waiting for delivery
+// sorted by delivery time.
+func (s *subpub) Scheduled() []ScheduledMessage {
+	return s.scheduler.Pending()
+}
+
+// CancelScheduled removes scheduled message with given id.
+//
+// Returns ErrScheduledNotFound if there is no such message,
+// including the case when it's already published.
+func (s *subpub) CancelScheduled(id uint64) error {
+	return s.scheduler.Cancel(id)
 }
 
 // Close initiates closing process on all the broadcasters.
 //
-// If context is done before Close call returns ctx.Err().
+// If context is done before Close call returns ctx.Err()
+// and system stays open.
+//
+// Otherwise, system moves to StateDraining: new subscriptions
+// and messages are rejected, queued messages are still delivered.
+// Scheduled messages which are not due yet are dropped.
 //
 // If context is done during closing of one of broadcasters,
-// no more broadcasters will be closed.
+// no more broadcasters will be closed and system stays draining.
 // Moreover, broadcaster will try to close after Close returns
-// in its own goroutine.
+// in its own goroutine. The next Close call waits for it
+// and continues with the rest.
+//
+// Once every broadcaster is closed, members of consumer groups
+// are stopped after finishing with their current messages,
+// the rest of messages stay in partitions. Then system moves
+// to StateClosed.
+// Calling Close on closed system returns nil.
 //
 // For guarantees on broadcaster closing refer to
 // the appropriate broadcaster.
 func (s *subpub) Close(ctx context.Context) error {
-	closed := make(chan struct{})
-
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
+	// checked before taking closeMut, because after
+	// hard stop it may be held by stuck closing pass forever
+	if s.state.Load() == StateClosed {
+		return nil
+	}
+
+	s.startClosing()
+
+	// buffered, so that goroutine never hangs
+	// if nobody waits for it anymore
+	closed := make(chan error, 1)
+
 	go func() {
+		s.closeMut.Lock()
+		defer s.closeMut.Unlock()
+
+		if s.state.Load() == StateClosed {
+			closed <- nil
+			return
+		}
+
+		var err error
 		s.broadcasters.Range(func(key, value any) bool {
-			err := value.(*broadcaster).Close(ctx)
+			err = value.(*broadcaster).Close(ctx)
 			return err == nil
 		})
-		if ctx.Err() == nil {
-			s.closed = true
-			closed <- struct{}{}
+		for _, t := range s.topics {
+			if err != nil {
+				break
+			}
+			err = t.close(ctx)
 		}
+		if err == nil && s.state.Transition(StateDraining, StateClosed) {
+			s.emit(SysEvent{Type: SysCloseFinished})
+		}
+		closed <- err
 	}()
 
 	select {
-	case <-closed:
-		return nil
+	case err := <-closed:
+		return err
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 }
 
-func newSubPub() *subpub {
-	return &subpub{broadcasters: sync.Map{}}
+// startClosing emits SysCloseStarted once
+// and moves system to StateDraining.
+func (s *subpub) startClosing() {
+	// published before draining, while subscribers may still get it
+	s.closeStarted.Do(func() {
+		s.emit(SysEvent{Type: SysCloseStarted})
+	})
+	s.drain()
+}
+
+// drain moves system to StateDraining
+// and stops scheduler.
+func (s *subpub) drain() {
+	s.mut.Lock()
+	s.state.Transition(StateOpen, StateDraining)
+	s.mut.Unlock()
+
+	s.scheduler.Stop()
+	s.watchdog.Stop()
+}
+
+// deadLetter publishes letter to dead-letter subject if it's set.
+func (s *subpub) deadLetter(letter DeadLetter) {
+	if s.cfg.deadLetterSubject == "" || letter.Subject == s.cfg.deadLetterSubject {
+		return
+	}
+	s.reportErr(s.send(s.cfg.deadLetterSubject, letter, publishConfig{}))
+}
+
+// reportErr logs error nobody else would see and passes it
+// to error handler. Nil errors and errors caused by closing
+// of the system are ignored.
+func (s *subpub) reportErr(err error) {
+	if err == nil || errors.Is(err, ErrDraining) || errors.Is(err, ErrClosed) {
+		return
+	}
+	s.cfg.logger.Error("subpub: internal publish failed", slog.String("error", err.Error()))
+	if s.cfg.onError != nil {
+		s.cfg.onError(err)
+	}
+}
+
+func newSubPub(opts ...Option) *subpub {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.tracing {
+		// tracing is the outermost, so spans cover other interceptors
+		t := newTracing(cfg.tracerProvider)
+		cfg.publishInterceptors = append([]PublishInterceptor{t.publish}, cfg.publishInterceptors...)
+		cfg.deliveryInterceptors = append([]DeliveryInterceptor{t.deliver}, cfg.deliveryInterceptors...)
+	}
+
+	s := &subpub{
+		cfg: cfg,
+
+		broadcasters: sync.Map{},
+		mut:          &sync.RWMutex{},
+		state:        &lifecycle{},
+		closeMut:     &sync.Mutex{},
+		closeStarted: &sync.Once{},
+		mapping:      &atomic.Pointer[SubjectMapping]{},
+	}
+	s.mapping.Store(cfg.mapping)
+
+	nextID := s.cfg.nextID
+	if nextID == nil {
+		ids := &atomic.Int64{}
+		nextID = func() int64 { return ids.Add(1) }
+	}
+	s.sys = &system{
+		deadLetter: s.deadLetter,
+		emit:       s.emit,
+		nextID:     nextID,
+		clock:      s.cfg.clock,
+		metrics:    s.cfg.metrics,
+	}
+
+	s.topics = make(map[string]*partitionedTopic, len(s.cfg.partitions))
+	for subject, policy := range s.cfg.partitions {
+		s.topics[subject] = newPartitionedTopic(subject, policy, s.cfg.ttl(subject), s.sys)
+	}
+
+	s.scheduler = newScheduler(func(subject string, msg interface{}, opts ...PublishOption) error {
+		err := s.Publish(subject, msg, opts...)
+		s.reportErr(err)
+		return err
+	})
+	s.dedup = newDeduplicator(s.cfg.dedup, s.cfg.clock)
+	if s.cfg.slowConsumer != nil {
+		s.watchdog = newWatchdog(*s.cfg.slowConsumer, s.checkSlowConsumers)
+		go s.watchdog.run()
+	}
+	return s
 }
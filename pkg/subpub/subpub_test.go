@@ -2,9 +2,14 @@ package subpub_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -14,6 +19,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Базовый тест подписки и публикации
@@ -160,6 +169,9 @@ func TestGracefulClose(t *testing.T) {
 	assert.ErrorIs(t, err, context.DeadlineExceeded, "Should respect context timeout")
 
 	wg.Wait()
+
+	err = sp.Close(context.Background())
+	assert.NoError(t, err, "Should finish interrupted close")
 }
 
 // Тест утечек горутин
@@ -292,3 +304,2982 @@ func TestHighload(t *testing.T) {
 		t.Fatal("High load test timeout")
 	}
 }
+
+func TestStuckSubscriberDoesNotBlockPublish(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	release := make(chan struct{})
+	stuck, err := sp.Subscribe("stuck", func(msg interface{}) {
+		<-release
+	})
+	require.NoError(t, err)
+
+	var received atomic.Int64
+	fast, err := sp.Subscribe("stuck", func(msg interface{}) {
+		received.Add(1)
+	})
+	require.NoError(t, err)
+	defer fast.Unsubscribe()
+
+	const messagesCount = 1000
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range messagesCount {
+			assert.NoError(t, sp.Publish("stuck", i))
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked by stuck subscriber")
+	}
+
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		sub, err := sp.Subscribe("stuck", func(msg interface{}) {})
+		assert.NoError(t, err)
+		sub.Unsubscribe()
+	}()
+
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe blocked by stuck subscriber")
+	}
+
+	assert.Eventually(t, func() bool { return received.Load() == messagesCount }, time.Second, 10*time.Millisecond)
+
+	close(release)
+	stuck.Unsubscribe()
+}
+
+const benchSubscribersCount = 10_000
+
+func subscribeMany(b *testing.B, sp subpub.SubPub, subject string, count int, cb subpub.MessageHandler) []subpub.Subscription {
+	b.Helper()
+
+	subs := make([]subpub.Subscription, 0, count)
+	for range count {
+		sub, err := sp.Subscribe(subject, cb)
+		require.NoError(b, err)
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+func BenchmarkPublish10kSubscribers(b *testing.B) {
+	sp := subpub.NewSubPub()
+	subscribeMany(b, sp, "bench", benchSubscribersCount, func(msg interface{}) {})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = sp.Publish("bench", i)
+	}
+	b.StopTimer()
+
+	require.NoError(b, sp.Close(context.Background()))
+}
+
+func BenchmarkPublish10kSubscribersOneStuck(b *testing.B) {
+	sp := subpub.NewSubPub()
+
+	release := make(chan struct{})
+	_, err := sp.Subscribe("bench", func(msg interface{}) { <-release })
+	require.NoError(b, err)
+	subscribeMany(b, sp, "bench", benchSubscribersCount-1, func(msg interface{}) {})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = sp.Publish("bench", i)
+	}
+	b.StopTimer()
+
+	close(release)
+	require.NoError(b, sp.Close(context.Background()))
+}
+
+func BenchmarkPublishParallel10kSubscribers(b *testing.B) {
+	sp := subpub.NewSubPub()
+	subscribeMany(b, sp, "bench", benchSubscribersCount, func(msg interface{}) {})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = sp.Publish("bench", nil)
+		}
+	})
+	b.StopTimer()
+
+	require.NoError(b, sp.Close(context.Background()))
+}
+
+func BenchmarkSubscribeChurnWhilePublishing10kSubscribers(b *testing.B) {
+	sp := subpub.NewSubPub()
+	subscribeMany(b, sp, "bench", benchSubscribersCount, func(msg interface{}) {})
+
+	stop := make(chan struct{})
+	publisherDone := make(chan struct{})
+	go func() {
+		defer close(publisherDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = sp.Publish("bench", nil)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sub, err := sp.Subscribe("bench", func(msg interface{}) {})
+		if err != nil {
+			b.Fatal(err)
+		}
+		sub.Unsubscribe()
+	}
+	b.StopTimer()
+
+	close(stop)
+	<-publisherDone
+	require.NoError(b, sp.Close(context.Background()))
+}
+
+func TestUnsubscribeIdempotent(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	sub, err := sp.Subscribe("idempotent", func(msg interface{}) {})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub.Unsubscribe()
+		}()
+	}
+	wg.Wait()
+
+	assert.NotPanics(t, sub.Unsubscribe)
+	require.NoError(t, sp.Close(context.Background()))
+	assert.NotPanics(t, sub.Unsubscribe)
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	_, err := sp.Subscribe("idempotent", func(msg interface{}) {})
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Close(context.Background()))
+	require.NoError(t, sp.Close(context.Background()))
+}
+
+func TestCloseResumesAfterTimeout(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	release := make(chan struct{})
+	_, err := sp.Subscribe("resume", func(msg interface{}) { <-release })
+	require.NoError(t, err)
+	require.NoError(t, sp.Publish("resume", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, sp.Close(ctx), context.DeadlineExceeded)
+
+	err = sp.Publish("resume", nil)
+	assert.ErrorIs(t, err, subpub.ErrDraining)
+	var stateErr *subpub.StateError
+	require.ErrorAs(t, err, &stateErr)
+	assert.Equal(t, subpub.StateDraining, stateErr.State)
+	assert.Equal(t, "resume", stateErr.Subject)
+
+	close(release)
+	require.NoError(t, sp.Close(context.Background()))
+
+	assert.ErrorIs(t, sp.Publish("resume", nil), subpub.ErrClosed)
+	_, err = sp.Subscribe("resume", func(msg interface{}) {})
+	assert.ErrorIs(t, err, subpub.ErrClosed)
+}
+
+func TestLifecycleStress(t *testing.T) {
+	const (
+		workers    = 20
+		iterations = 200
+		topics     = 5
+	)
+
+	allowed := func(err error) bool {
+		return err == nil ||
+			errors.Is(err, subpub.ErrClosed) ||
+			errors.Is(err, subpub.ErrDraining) ||
+			errors.Is(err, subpub.ErrTopicClosed)
+	}
+
+	for range 10 {
+		sp := subpub.NewSubPub()
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for i := range iterations {
+					subject := strconv.Itoa((w + i) % topics)
+					switch i % 3 {
+					case 0:
+						sub, err := sp.Subscribe(subject, func(msg interface{}) {})
+						assert.True(t, allowed(err), "unexpected error: %v", err)
+						if err == nil && i%2 == 0 {
+							sub.Unsubscribe()
+							sub.Unsubscribe()
+						}
+					default:
+						err := sp.Publish(subject, i)
+						assert.True(t, allowed(err), "unexpected error: %v", err)
+					}
+				}
+			}()
+		}
+
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				time.Sleep(time.Millisecond)
+				assert.NoError(t, sp.Close(context.Background()))
+			}()
+		}
+
+		close(start)
+		wg.Wait()
+
+		require.NoError(t, sp.Close(context.Background()))
+		assert.ErrorIs(t, sp.Publish("0", nil), subpub.ErrClosed)
+	}
+}
+
+func TestShutdownGraceful(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	_, err := sp.Subscribe("graceful", func(msg interface{}) {})
+	require.NoError(t, err)
+	require.NoError(t, sp.Publish("graceful", nil))
+
+	report, err := sp.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+}
+
+func TestShutdownReport(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	_, err := sp.Subscribe("report", func(msg interface{}) {
+		started <- struct{}{}
+		<-release
+	})
+	require.NoError(t, err)
+	_, err = sp.Subscribe("other", func(msg interface{}) {})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("report", i))
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report, err := sp.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, report.Forced)
+	require.Len(t, report.Subjects, 1)
+	assert.Equal(t, "report", report.Subjects[0].Subject)
+	require.Len(t, report.Subjects[0].Subscriptions, 1)
+	assert.Equal(t, int64(2), report.Subjects[0].Subscriptions[0].Undelivered)
+	assert.True(t, report.Subjects[0].Subscriptions[0].HandlerRunning)
+
+	close(release)
+	require.NoError(t, sp.Close(context.Background()))
+}
+
+func TestShutdownCancelled(t *testing.T) {
+	for _, hardStop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hard stop %v", hardStop), func(t *testing.T) {
+			var events []string
+			var mu sync.Mutex
+			record := func(e subpub.SysEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, e.Type)
+			}
+			sp := subpub.NewSubPub(subpub.WithHooks(subpub.Hooks{OnCloseStarted: record, OnCloseFinished: record}))
+
+			release := make(chan struct{})
+			started := make(chan struct{}, 2)
+			_, err := sp.Subscribe("cancelled", func(msg interface{}) {
+				started <- struct{}{}
+				<-release
+			})
+			require.NoError(t, err)
+			for i := range 2 {
+				require.NoError(t, sp.Publish("cancelled", i))
+			}
+			<-started
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var opts []subpub.ShutdownOption
+			if hardStop {
+				opts = append(opts, subpub.WithHardStop())
+			}
+			report, err := sp.Shutdown(ctx, opts...)
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, hardStop, report.Forced)
+			assert.Equal(t, int64(1), report.Undelivered())
+			assert.Equal(t, 1, report.RunningHandlers())
+			close(release)
+
+			// system is not left open
+			if hardStop {
+				assert.ErrorIs(t, sp.Publish("cancelled", nil), subpub.ErrClosed)
+			} else {
+				assert.ErrorIs(t, sp.Publish("cancelled", nil), subpub.ErrDraining)
+				require.NoError(t, sp.Close(context.Background()))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, []string{subpub.SysCloseStarted, subpub.SysCloseFinished}, events)
+		})
+	}
+}
+
+func TestShutdownHardStop(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var calls atomic.Int64
+	started := make(chan struct{}, 3)
+	cancelled := make(chan struct{})
+	_, err := sp.SubscribeContext("hard", func(ctx context.Context, msg interface{}) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-ctx.Done()
+		close(cancelled)
+	})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("hard", i))
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report, err := sp.Shutdown(ctx, subpub.WithHardStop())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, report.Forced)
+	assert.Equal(t, int64(2), report.Undelivered())
+	assert.Equal(t, 1, report.RunningHandlers())
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Handler context was not cancelled")
+	}
+
+	assert.ErrorIs(t, sp.Publish("hard", nil), subpub.ErrClosed)
+	require.NoError(t, sp.Close(context.Background()))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), calls.Load(), "Dropped messages were delivered")
+}
+
+func TestPublishAndWait(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var handled atomic.Int64
+	for range 3 {
+		sub, err := sp.Subscribe("wait", func(msg interface{}) {
+			time.Sleep(20 * time.Millisecond)
+			handled.Add(1)
+		})
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	report, err := sp.PublishAndWait(context.Background(), "wait", "data")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), handled.Load(), "Returned before handlers finished")
+	assert.Equal(t, 3, report.Delivered())
+	require.Len(t, report.Outcomes, 3)
+	for _, outcome := range report.Outcomes {
+		assert.Equal(t, subpub.DeliveryDone, outcome.Status)
+	}
+
+	report, err = sp.PublishAndWait(context.Background(), "nobody", "data")
+	require.NoError(t, err)
+	assert.Empty(t, report.Outcomes)
+}
+
+func TestPublishAndWaitTimeout(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	release := make(chan struct{})
+	slow, err := sp.Subscribe("wait", func(msg interface{}) { <-release })
+	require.NoError(t, err)
+	fast, err := sp.Subscribe("wait", func(msg interface{}) {})
+	require.NoError(t, err)
+	defer fast.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report, err := sp.PublishAndWait(ctx, "wait", "data")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, report.Delivered())
+	require.Len(t, report.Outcomes, 2)
+	assert.Equal(t, subpub.DeliveryPending, report.Outcomes[0].Status)
+	assert.Equal(t, subpub.DeliveryDone, report.Outcomes[1].Status)
+
+	close(release)
+	slow.Unsubscribe()
+}
+
+func TestPublishAndWaitDropped(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{}, 2)
+	_, err := sp.SubscribeContext("dropped", func(ctx context.Context, msg interface{}) {
+		started <- struct{}{}
+		<-ctx.Done()
+	})
+	require.NoError(t, err)
+	require.NoError(t, sp.Publish("dropped", "first"))
+	<-started
+
+	type result struct {
+		report subpub.DeliveryReport
+		err    error
+	}
+	waited := make(chan result)
+	go func() {
+		report, err := sp.PublishAndWait(context.Background(), "dropped", "second")
+		waited <- result{report, err}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sp.Shutdown(ctx, subpub.WithHardStop())
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case res := <-waited:
+		require.NoError(t, res.err)
+		require.Len(t, res.report.Outcomes, 1)
+		assert.Equal(t, subpub.DeliveryDropped, res.report.Outcomes[0].Status)
+	case <-time.After(time.Second):
+		t.Fatal("PublishAndWait hasn't returned after hard stop")
+	}
+}
+
+func TestPublishAfter(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	received := make(chan interface{}, 3)
+	sub, err := sp.Subscribe("delayed", func(msg interface{}) {
+		received <- msg
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	start := time.Now()
+	_, err = sp.PublishAfter("delayed", "second", 100*time.Millisecond)
+	require.NoError(t, err)
+	_, err = sp.PublishAfter("delayed", "first", 50*time.Millisecond)
+	require.NoError(t, err)
+	_, err = sp.PublishAt("delayed", "now", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+
+	for _, expected := range []string{"now", "first", "second"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, expected, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("Message %q not received", expected)
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond, "Delivered too early")
+	assert.Empty(t, sp.Scheduled())
+}
+
+func TestCancelScheduled(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var calls atomic.Int64
+	sub, err := sp.Subscribe("cancel", func(msg interface{}) {
+		calls.Add(1)
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	id, err := sp.PublishAfter("cancel", "data", 50*time.Millisecond)
+	require.NoError(t, err)
+
+	scheduled := sp.Scheduled()
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, id, scheduled[0].ID)
+	assert.Equal(t, "cancel", scheduled[0].Subject)
+	assert.Equal(t, "data", scheduled[0].Msg)
+
+	require.NoError(t, sp.CancelScheduled(id))
+	assert.ErrorIs(t, sp.CancelScheduled(id), subpub.ErrScheduledNotFound)
+	assert.Empty(t, sp.Scheduled())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, calls.Load(), "Cancelled message was delivered")
+}
+
+func TestCloseDropsScheduled(t *testing.T) {
+	initial := runtime.NumGoroutine()
+
+	sp := subpub.NewSubPub()
+
+	var calls atomic.Int64
+	_, err := sp.Subscribe("scheduled", func(msg interface{}) {
+		calls.Add(1)
+	})
+	require.NoError(t, err)
+
+	_, err = sp.PublishAfter("scheduled", "data", 50*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Close(context.Background()))
+	assert.Empty(t, sp.Scheduled())
+
+	_, err = sp.PublishAfter("scheduled", "data", time.Millisecond)
+	assert.ErrorIs(t, err, subpub.ErrClosed)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, calls.Load(), "Scheduled message was delivered after close")
+	assert.Equal(t, initial, runtime.NumGoroutine(), "Scheduler goroutine leaked")
+}
+
+func TestMessageTTL(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithDeadLetter("dead"))
+
+	var deadLetters []subpub.DeadLetter
+	var mu sync.Mutex
+	dead, err := sp.Subscribe("dead", func(msg interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		deadLetters = append(deadLetters, msg.(subpub.DeadLetter))
+	})
+	require.NoError(t, err)
+	defer dead.Unsubscribe()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	sub, err := sp.Subscribe("ttl", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Publish("ttl", "blocker"))
+	<-started
+	require.NoError(t, sp.Publish("ttl", "stale", subpub.WithTTL(20*time.Millisecond)))
+	require.NoError(t, sp.Publish("ttl", "fresh", subpub.WithTTL(time.Minute)))
+	require.NoError(t, sp.Publish("ttl", "forever"))
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	assert.Equal(t, []interface{}{"blocker", "fresh", "forever"}, received)
+	mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(deadLetters) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, "ttl", deadLetters[0].Subject)
+	assert.Equal(t, "stale", deadLetters[0].Msg)
+	assert.Equal(t, subpub.DeliveryExpired, deadLetters[0].Reason)
+	mu.Unlock()
+
+	stats := sp.Stats()
+	require.Len(t, stats.Subjects, 2)
+	assert.Equal(t, "ttl", stats.Subjects[1].Subject)
+	assert.Equal(t, uint64(4), stats.Subjects[1].Published)
+}
+
+func TestSubjectTTL(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithSubjectTTL("ticks", 20*time.Millisecond))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received atomic.Int64
+	sub, err := sp.Subscribe("ticks", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		received.Add(1)
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, sp.Publish("ticks", "blocker"))
+	<-started
+	for i := range 10 {
+		require.NoError(t, sp.Publish("ticks", i))
+	}
+	require.NoError(t, sp.Publish("ticks", "long", subpub.WithTTL(time.Minute)))
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		stats := sp.Stats().Subjects[0].Subscriptions[0]
+		return stats.Delivered+stats.Expired == 12
+	}, time.Second, 10*time.Millisecond)
+
+	stats := sp.Stats().Subjects[0]
+	assert.Equal(t, uint64(10), stats.Expired())
+	assert.Equal(t, uint64(2), stats.Subscriptions[0].Delivered)
+	assert.Equal(t, int64(2), received.Load())
+}
+
+func TestConflation(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("prices", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	}, subpub.WithConflationHeader("symbol"))
+	require.NoError(t, err)
+
+	publish := func(symbol string, price interface{}) {
+		require.NoError(t, sp.Publish("prices", price, subpub.WithHeaders(map[string]string{"symbol": symbol})))
+	}
+
+	require.NoError(t, sp.Publish("prices", "blocker"))
+	<-started
+
+	for i := range 100 {
+		publish("AAA", fmt.Sprintf("AAA-%d", i))
+		publish("BBB", fmt.Sprintf("BBB-%d", i))
+	}
+	require.NoError(t, sp.Publish("prices", "no key"))
+	publish("AAA", "AAA-last")
+
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, int64(3), stats.Queued)
+	assert.Equal(t, uint64(199), stats.Conflated)
+
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{"blocker", "AAA-last", "BBB-99", "no key"}, received)
+}
+
+func TestConflationWhileHandling(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	got := make(chan interface{}, 10)
+	release := make(chan struct{})
+	var received []interface{}
+	sub, err := sp.Subscribe("prices", func(msg interface{}) {
+		received = append(received, msg)
+		got <- msg
+		<-release
+	}, subpub.WithConflationHeader("symbol"))
+	require.NoError(t, err)
+
+	publish := func(symbol string, price interface{}) {
+		require.NoError(t, sp.Publish("prices", price, subpub.WithHeaders(map[string]string{"symbol": symbol})))
+	}
+
+	publish("z", "z0")
+	assert.Equal(t, "z0", <-got)
+	for _, symbol := range []string{"a", "b", "c"} {
+		publish(symbol, symbol+"1")
+	}
+	release <- struct{}{}
+	assert.Equal(t, "a1", <-got)
+
+	// values queued behind the one being handled are still replaced
+	for _, symbol := range []string{"a", "b", "c"} {
+		publish(symbol, symbol+"2")
+	}
+	close(release)
+	sub.Unsubscribe()
+
+	assert.Equal(t, []interface{}{"z0", "a1", "b2", "c2", "a2"}, received)
+}
+
+func TestConflationKeyExtractor(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	type update struct {
+		id    int
+		value int
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []update
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("updates", func(msg interface{}) {
+		u := msg.(update)
+		if u.id < 0 {
+			close(started)
+			<-release
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, u)
+	}, subpub.WithConflation(func(msg subpub.Message) string {
+		return strconv.Itoa(msg.Data.(update).id)
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Publish("updates", update{id: -1}))
+	<-started
+
+	for value := range 10 {
+		for id := range 3 {
+			require.NoError(t, sp.Publish("updates", update{id: id, value: value}))
+		}
+	}
+
+	waited := make(chan subpub.DeliveryReport)
+	go func() {
+		report, err := sp.PublishAndWait(context.Background(), "updates", update{id: 0, value: 50})
+		assert.NoError(t, err)
+		waited <- report
+	}()
+
+	assert.Eventually(t, func() bool {
+		return sp.Stats().Subjects[0].Subscriptions[0].Conflated == 28
+	}, time.Second, time.Millisecond)
+	require.NoError(t, sp.Publish("updates", update{id: 0, value: 100}))
+
+	select {
+	case report := <-waited:
+		require.Len(t, report.Outcomes, 1)
+		assert.Equal(t, subpub.DeliveryConflated, report.Outcomes[0].Status)
+	case <-time.After(time.Second):
+		t.Fatal("Replaced message is still awaited")
+	}
+
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []update{{0, 100}, {1, 9}, {2, 9}}, received)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithSubjectDedupWindow("short", 50*time.Millisecond))
+
+	var received atomic.Int64
+	for _, subject := range []string{"orders", "short"} {
+		sub, err := sp.Subscribe(subject, func(msg interface{}) {
+			received.Add(1)
+		})
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	for range 3 {
+		require.NoError(t, sp.Publish("orders", "order", subpub.WithIdempotencyKey("order-1")))
+	}
+	require.NoError(t, sp.Publish("orders", "order", subpub.WithIdempotencyKey("order-2")))
+	require.NoError(t, sp.Publish("orders", "order"))
+	require.NoError(t, sp.Publish("orders", "order"))
+
+	report, err := sp.PublishAndWait(context.Background(), "orders", "order", subpub.WithIdempotencyKey("order-1"))
+	require.NoError(t, err)
+	assert.True(t, report.Duplicate)
+	assert.Empty(t, report.Outcomes)
+
+	assert.Eventually(t, func() bool { return received.Load() == 4 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, sp.Publish("short", "event", subpub.WithIdempotencyKey("order-1")))
+	require.NoError(t, sp.Publish("short", "event", subpub.WithIdempotencyKey("order-1")))
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, sp.Publish("short", "event", subpub.WithIdempotencyKey("order-1")))
+
+	assert.Eventually(t, func() bool { return received.Load() == 6 }, time.Second, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(6), received.Load())
+}
+
+func TestIdempotencyKeyConcurrent(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var received atomic.Int64
+	sub, err := sp.Subscribe("concurrent", func(msg interface{}) {
+		received.Add(1)
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, sp.Publish("concurrent", "data", subpub.WithIdempotencyKey("key")))
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), received.Load())
+}
+
+func TestTxAtomicity(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	inventory, err := sp.Subscribe("inventory.reserved", func(msg interface{}) {
+		if msg == "blocker" {
+			close(blocked)
+			<-release
+		}
+	})
+	require.NoError(t, err)
+	require.NoError(t, sp.Publish("inventory.reserved", "blocker"))
+	<-blocked
+
+	inventoryQueued := func() int64 {
+		for _, subject := range sp.Stats().Subjects {
+			if subject.Subject == "inventory.reserved" {
+				return subject.Subscriptions[0].Queued
+			}
+		}
+		return 0
+	}
+
+	const txCount = 200
+
+	var partial, received atomic.Int64
+	orders, err := sp.Subscribe("order.created", func(msg interface{}) {
+		// inventory handler is stuck, so its queue holds
+		// every reservation committed so far
+		if inventoryQueued() < int64(msg.(int)+1) {
+			partial.Add(1)
+		}
+		received.Add(1)
+	})
+	require.NoError(t, err)
+	defer orders.Unsubscribe()
+
+	for i := range txCount {
+		tx := sp.Begin()
+		require.NoError(t, tx.Publish("order.created", i))
+		require.NoError(t, tx.Publish("inventory.reserved", i))
+		require.NoError(t, tx.Commit())
+	}
+
+	assert.Eventually(t, func() bool { return received.Load() == txCount }, time.Second, 10*time.Millisecond)
+	assert.Zero(t, partial.Load(), "Observed partial commit")
+	assert.Equal(t, int64(txCount), inventoryQueued())
+
+	close(release)
+	inventory.Unsubscribe()
+}
+
+func TestTxRollbackAndErrors(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var received atomic.Int64
+	sub, err := sp.Subscribe("tx", func(msg interface{}) {
+		received.Add(1)
+	})
+	require.NoError(t, err)
+
+	tx := sp.Begin()
+	require.NoError(t, tx.Publish("tx", 1))
+	tx.Rollback()
+	assert.ErrorIs(t, tx.Publish("tx", 2), subpub.ErrTxDone)
+	assert.ErrorIs(t, tx.Commit(), subpub.ErrTxDone)
+
+	tx = sp.Begin()
+	require.NoError(t, tx.Publish("tx", 1, subpub.WithIdempotencyKey("once")))
+	require.NoError(t, tx.Publish("tx", 2, subpub.WithIdempotencyKey("once")))
+	require.NoError(t, tx.Publish("tx", 3))
+	require.NoError(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), subpub.ErrTxDone)
+
+	sub.Unsubscribe()
+	assert.Equal(t, int64(2), received.Load())
+
+	tx = sp.Begin()
+	require.NoError(t, tx.Publish("tx", 1))
+	require.NoError(t, sp.Close(context.Background()))
+	assert.ErrorIs(t, tx.Commit(), subpub.ErrClosed)
+}
+
+func TestPriority(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("jobs", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Publish("jobs", "blocker"))
+	<-started
+
+	require.NoError(t, sp.Publish("jobs", "normal-1"))
+	require.NoError(t, sp.Publish("jobs", "high-1", subpub.WithPriority(subpub.PriorityHigh)))
+	require.NoError(t, sp.Publish("jobs", "critical-1", subpub.WithPriority(subpub.PriorityCritical)))
+	require.NoError(t, sp.Publish("jobs", "normal-2", subpub.WithPriority(subpub.PriorityNormal)))
+	require.NoError(t, sp.Publish("jobs", "high-2", subpub.WithPriority(subpub.PriorityHigh)))
+	require.NoError(t, sp.Publish("jobs", "critical-2", subpub.WithPriority(subpub.Priority(42))))
+
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{
+		"blocker",
+		"critical-1", "critical-2",
+		"high-1", "high-2",
+		"normal-1", "normal-2",
+	}, received)
+}
+
+func TestPriorityPreemption(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("jobs", func(msg interface{}) {
+		if msg == "normal-0" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("jobs", fmt.Sprintf("normal-%d", i)))
+	}
+	<-started
+
+	// processor has already grabbed normal messages,
+	// critical one must still jump ahead of the rest of them
+	require.NoError(t, sp.Publish("jobs", "critical", subpub.WithPriority(subpub.PriorityCritical)))
+	require.NoError(t, sp.Publish("jobs", "normal-3"))
+
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{"normal-0", "critical", "normal-1", "normal-2", "normal-3"}, received)
+}
+
+func TestPriorityWeights(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("jobs", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	}, subpub.WithPriorityWeights(1, 1, 3))
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Publish("jobs", "blocker"))
+	<-started
+
+	for i := range 2 {
+		require.NoError(t, sp.Publish("jobs", fmt.Sprintf("n%d", i)))
+	}
+	for i := range 2 {
+		require.NoError(t, sp.Publish("jobs", fmt.Sprintf("h%d", i), subpub.WithPriority(subpub.PriorityHigh)))
+	}
+	for i := range 7 {
+		require.NoError(t, sp.Publish("jobs", fmt.Sprintf("c%d", i), subpub.WithPriority(subpub.PriorityCritical)))
+	}
+
+	close(release)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{
+		"blocker",
+		"c0", "c1", "c2", "h0", "n0",
+		"c3", "c4", "c5", "h1", "n1",
+		"c6",
+	}, received)
+}
+
+func TestPriorityConflation(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("prices", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	}, subpub.WithConflationHeader("symbol"))
+	require.NoError(t, err)
+
+	publish := func(symbol string, price interface{}, priority subpub.Priority) {
+		require.NoError(t, sp.Publish("prices", price,
+			subpub.WithHeaders(map[string]string{"symbol": symbol}),
+			subpub.WithPriority(priority),
+		))
+	}
+
+	require.NoError(t, sp.Publish("prices", "blocker"))
+	<-started
+
+	publish("AAA", "AAA-1", subpub.PriorityNormal)
+	publish("BBB", "BBB-1", subpub.PriorityHigh)
+	publish("AAA", "AAA-2", subpub.PriorityHigh)
+	publish("BBB", "BBB-2", subpub.PriorityNormal)
+
+	close(release)
+	sub.Unsubscribe()
+
+	// replacement keeps place and priority of the queued message
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{"blocker", "BBB-2", "AAA-2"}, received)
+}
+
+func TestConflationPutBack(t *testing.T) {
+	metrics := &countingMetrics{}
+	sp := subpub.NewSubPub(subpub.WithMetrics(metrics))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.Subscribe("prices", func(msg interface{}) {
+		if msg == "blocker" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	}, subpub.WithConflationHeader("symbol"), subpub.WithRateLimit(5, 1))
+	require.NoError(t, err)
+
+	publish := func(price interface{}, priority subpub.Priority) {
+		require.NoError(t, sp.Publish("prices", price,
+			subpub.WithHeaders(map[string]string{"symbol": "AAA"}),
+			subpub.WithPriority(priority),
+		))
+	}
+
+	require.NoError(t, sp.Publish("prices", "blocker"))
+	<-started
+	publish("AAA-1", subpub.PriorityNormal)
+
+	// processor grabs AAA-1 and waits for rate limit,
+	// then it's preempted by newer value and put back
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	publish("AAA-2", subpub.PriorityHigh)
+	sub.Unsubscribe()
+
+	// stale value put back is replaced by newer one
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []interface{}{"blocker", "AAA-2"}, received)
+	assert.Equal(t, int64(1), metrics.skippedFor(subpub.DeliveryConflated))
+}
+
+func TestBatchHandler(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var sizes []int
+	var received []interface{}
+	var mu sync.Mutex
+	sub, err := sp.SubscribeBatch("rows", func(msgs []subpub.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(msgs))
+		for _, msg := range msgs {
+			assert.Equal(t, "rows", msg.Subject)
+			received = append(received, msg.Data)
+		}
+	}, subpub.WithBatching(10, time.Hour))
+	require.NoError(t, err)
+
+	expected := make([]interface{}, 0, 25)
+	for i := range 25 {
+		require.NoError(t, sp.Publish("rows", i))
+		expected = append(expected, i)
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 20
+	}, time.Second, 10*time.Millisecond)
+
+	// the rest is flushed on closing without lingering
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{10, 10, 5}, sizes)
+	assert.Equal(t, expected, received)
+}
+
+func TestBatchHandlerLinger(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	batches := make(chan []subpub.Message, 10)
+	sub, err := sp.SubscribeBatch("rows", func(msgs []subpub.Message) {
+		batches <- msgs
+	}, subpub.WithBatching(100, 200*time.Millisecond))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	start := time.Now()
+	for i := range 3 {
+		require.NoError(t, sp.Publish("rows", i))
+	}
+
+	select {
+	case msgs := <-batches:
+		assert.Len(t, msgs, 3)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch was not delivered after linger")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var received atomic.Int64
+	sub, err := sp.Subscribe("hooks", func(msg interface{}) {
+		received.Add(1)
+	}, subpub.WithRateLimit(50, 5))
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := range 20 {
+		require.NoError(t, sp.Publish("hooks", i))
+	}
+
+	// burst goes right away, the rest waits in queue
+	assert.Eventually(t, func() bool {
+		return received.Load() >= 5
+	}, time.Second, time.Millisecond)
+	assert.Greater(t, sp.Stats().Subjects[0].Subscriptions[0].Queued, int64(0))
+
+	sub.Unsubscribe()
+	assert.Equal(t, int64(20), received.Load())
+	// 15 messages above burst at 50 per second
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+}
+
+func TestRateLimitBatches(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var sizes []int
+	var mu sync.Mutex
+	sub, err := sp.SubscribeBatch("rows", func(msgs []subpub.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(msgs))
+	}, subpub.WithBatching(10, 0), subpub.WithRateLimit(1000, 4))
+	require.NoError(t, err)
+
+	for i := range 10 {
+		require.NoError(t, sp.Publish("rows", i))
+	}
+	sub.Unsubscribe()
+
+	// batch never exceeds burst
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for _, size := range sizes {
+		assert.LessOrEqual(t, size, 4)
+		total += size
+	}
+	assert.Equal(t, 10, total)
+}
+
+func TestSubscribeChan(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	msgs, sub, err := sp.SubscribeChan("events")
+	require.NoError(t, err)
+
+	for i := range 10 {
+		require.NoError(t, sp.Publish("events", i, subpub.WithHeaders(map[string]string{"n": strconv.Itoa(i)})))
+	}
+	for i := range 10 {
+		select {
+		case msg := <-msgs:
+			assert.Equal(t, "events", msg.Subject)
+			assert.Equal(t, i, msg.Data)
+			assert.Equal(t, strconv.Itoa(i), msg.Headers["n"])
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered to channel")
+		}
+	}
+
+	sub.Unsubscribe()
+	_, ok := <-msgs
+	assert.False(t, ok, "channel must be closed on Unsubscribe")
+}
+
+func TestSubscribeChanClosedWithSystem(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	msgs, _, err := sp.SubscribeChan("events", subpub.WithChanBuffer(0, subpub.OverflowBlock))
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("events", i))
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- sp.Close(context.Background())
+	}()
+
+	// closing waits for consumer to take everything
+	var received []interface{}
+	for msg := range msgs {
+		received = append(received, msg.Data)
+	}
+	assert.Equal(t, []interface{}{0, 1, 2}, received)
+	assert.NoError(t, <-closed)
+}
+
+func TestSubscribeChanOverflow(t *testing.T) {
+	cases := []struct {
+		overflow subpub.Overflow
+		expected []interface{}
+	}{
+		{overflow: subpub.OverflowDropNewest, expected: []interface{}{0, 1}},
+		{overflow: subpub.OverflowDropOldest, expected: []interface{}{3, 4}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.overflow.String(), func(t *testing.T) {
+			sp := subpub.NewSubPub()
+
+			msgs, sub, err := sp.SubscribeChan("events", subpub.WithChanBuffer(2, c.overflow))
+			require.NoError(t, err)
+
+			for i := range 5 {
+				require.NoError(t, sp.Publish("events", i))
+			}
+			assert.Eventually(t, func() bool {
+				stats := sp.Stats().Subjects[0].Subscriptions[0]
+				return stats.Queued == 0 && stats.Dropped == 3
+			}, time.Second, time.Millisecond)
+
+			sub.Unsubscribe()
+
+			var received []interface{}
+			for msg := range msgs {
+				received = append(received, msg.Data)
+			}
+			assert.Equal(t, c.expected, received)
+		})
+	}
+}
+
+func TestSubscribeChanSlowConsumer(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	msgs, slow, err := sp.SubscribeChan("events", subpub.WithChanBuffer(1, subpub.OverflowBlock))
+	require.NoError(t, err)
+
+	var received atomic.Int64
+	fast, err := sp.Subscribe("events", func(msg interface{}) {
+		received.Add(1)
+	})
+	require.NoError(t, err)
+	defer fast.Unsubscribe()
+
+	for i := range 100 {
+		require.NoError(t, sp.Publish("events", i))
+	}
+
+	// nobody reads channel, but other subscriber doesn't care
+	assert.Eventually(t, func() bool {
+		return received.Load() == 100
+	}, time.Second, time.Millisecond)
+
+	// and Unsubscribe doesn't wait for consumer
+	unsubscribed := make(chan struct{})
+	go func() {
+		slow.Unsubscribe()
+		close(unsubscribed)
+	}()
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe waits for channel consumer")
+	}
+
+	// only buffered message is left
+	var left []interface{}
+	for msg := range msgs {
+		left = append(left, msg.Data)
+	}
+	assert.Equal(t, []interface{}{0}, left)
+}
+
+func TestPauseResume(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	received := make(chan interface{}, 10)
+	sub, err := sp.Subscribe("db", func(msg interface{}) {
+		received <- msg
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, sp.Publish("db", 0))
+	assert.Equal(t, 0, <-received)
+
+	sub.Pause()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, sp.Publish("db", i))
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("message %v delivered while paused", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.True(t, stats.Paused)
+	assert.Equal(t, int64(5), stats.Queued)
+
+	sub.Resume()
+	for i := 1; i <= 5; i++ {
+		assert.Equal(t, i, <-received)
+	}
+	assert.False(t, sp.Stats().Subjects[0].Subscriptions[0].Paused)
+}
+
+func TestPauseInTheMiddleOfBatch(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	received := make(chan interface{}, 10)
+	sub, err := sp.Subscribe("db", func(msg interface{}) {
+		if msg == 0 {
+			close(started)
+			<-release
+		}
+		received <- msg
+	})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("db", i))
+	}
+	<-started
+
+	// processor has grabbed all three already
+	sub.Pause()
+	close(release)
+	assert.Equal(t, 0, <-received)
+
+	select {
+	case msg := <-received:
+		t.Fatalf("message %v delivered while paused", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// closing ignores pause
+	sub.Unsubscribe()
+	assert.Equal(t, 1, <-received)
+	assert.Equal(t, 2, <-received)
+}
+
+func TestQueueLimit(t *testing.T) {
+	cases := []struct {
+		overflow subpub.Overflow
+		expected []interface{}
+	}{
+		{overflow: subpub.OverflowDropNewest, expected: []interface{}{0, 1, 2}},
+		{overflow: subpub.OverflowDropOldest, expected: []interface{}{2, 3, 4}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.overflow.String(), func(t *testing.T) {
+			sp := subpub.NewSubPub(subpub.WithDeadLetter("dead"))
+
+			var dead []interface{}
+			var mu sync.Mutex
+			deadSub, err := sp.Subscribe("dead", func(msg interface{}) {
+				letter := msg.(subpub.DeadLetter)
+				assert.Equal(t, subpub.DeliveryDropped, letter.Reason)
+				mu.Lock()
+				defer mu.Unlock()
+				dead = append(dead, letter.Msg)
+			})
+			require.NoError(t, err)
+
+			var received []interface{}
+			sub, err := sp.Subscribe("db", func(msg interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, msg)
+			}, subpub.WithQueueLimit(3, c.overflow))
+			require.NoError(t, err)
+
+			sub.Pause()
+			for i := range 5 {
+				require.NoError(t, sp.Publish("db", i))
+			}
+			stats := sp.Stats().Subjects[0].Subscriptions[0]
+			assert.Equal(t, int64(3), stats.Queued)
+			assert.Equal(t, uint64(2), stats.Dropped)
+
+			sub.Resume()
+			sub.Unsubscribe()
+			deadSub.Unsubscribe()
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, c.expected, received)
+			assert.Len(t, dead, 2)
+		})
+	}
+}
+
+// closingMetrics starts Close once message is dropped by overflow
+// and gives it time to wait for system lock.
+type closingMetrics struct {
+	countingMetrics
+	once  sync.Once
+	close func()
+}
+
+func (m *closingMetrics) MessageSkipped(subject string, reason subpub.DeliveryStatus) {
+	m.countingMetrics.MessageSkipped(subject, reason)
+	if reason == subpub.DeliveryDropped {
+		m.once.Do(func() {
+			go m.close()
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+}
+
+func TestOverflowWhileClosing(t *testing.T) {
+	cases := map[string]func(sp subpub.SubPub) error{
+		"publish": func(sp subpub.SubPub) error {
+			return sp.Publish("jobs", "overflow")
+		},
+		"commit": func(sp subpub.SubPub) error {
+			tx := sp.Begin()
+			if err := tx.Publish("jobs", "overflow"); err != nil {
+				return err
+			}
+			return tx.Commit()
+		},
+	}
+
+	for name, publish := range cases {
+		t.Run(name, func(t *testing.T) {
+			metrics := &closingMetrics{}
+			sp := subpub.NewSubPub(subpub.WithDeadLetter("dead"), subpub.WithMetrics(metrics))
+			closed := make(chan error, 1)
+			metrics.close = func() {
+				closed <- sp.Close(context.Background())
+			}
+
+			sub, err := sp.Subscribe("jobs", func(interface{}) {}, subpub.WithQueueLimit(1, subpub.OverflowDropNewest))
+			require.NoError(t, err)
+			sub.Pause()
+			require.NoError(t, sp.Publish("jobs", "queued"))
+
+			// dead letter of overflow mustn't wait for Close,
+			// which waits for publisher
+			published := make(chan error, 1)
+			go func() {
+				published <- publish(sp)
+			}()
+			select {
+			case err := <-published:
+				assert.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("Publish has deadlocked with Close")
+			}
+			select {
+			case err := <-closed:
+				assert.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("Close has deadlocked with Publish")
+			}
+		})
+	}
+}
+
+func TestWatchdogWarns(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithWatchdog(subpub.SlowConsumerPolicy{
+		MaxQueued: 5,
+		Interval:  10 * time.Millisecond,
+	}))
+	defer sp.Close(context.Background())
+
+	events, eventsSub, err := sp.SubscribeChan(subpub.SlowConsumerSubject)
+	require.NoError(t, err)
+	defer eventsSub.Unsubscribe()
+
+	sub, err := sp.Subscribe("reports", func(msg interface{}) {})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	sub.Pause()
+	for i := range 10 {
+		require.NoError(t, sp.Publish("reports", i))
+	}
+
+	select {
+	case msg := <-events:
+		event := msg.Data.(subpub.SlowConsumer)
+		assert.Equal(t, "reports", event.Subject)
+		assert.Equal(t, int64(10), event.Queued)
+		assert.Contains(t, event.Reason, "10 messages queued")
+		assert.False(t, event.Evicted)
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not reported")
+	}
+
+	// reported once per episode, and subscription is kept
+	select {
+	case msg := <-events:
+		t.Fatalf("slow consumer reported twice: %v", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, sub.Err())
+
+	sub.Resume()
+	assert.Eventually(t, func() bool {
+		return sp.Stats().Subjects[1].Subscriptions[0].Queued == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestWatchdogEvicts(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithWatchdog(subpub.SlowConsumerPolicy{
+		MaxHandlerTime: 20 * time.Millisecond,
+		Evict:          true,
+		Interval:       10 * time.Millisecond,
+	}))
+	defer sp.Close(context.Background())
+
+	events, eventsSub, err := sp.SubscribeChan(subpub.SlowConsumerSubject)
+	require.NoError(t, err)
+	defer eventsSub.Unsubscribe()
+
+	sub, err := sp.SubscribeContext("reports", func(ctx context.Context, msg interface{}) {
+		<-ctx.Done()
+	})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("reports", i))
+	}
+
+	select {
+	case msg := <-events:
+		event := msg.Data.(subpub.SlowConsumer)
+		assert.Contains(t, event.Reason, "handler runs for")
+		assert.True(t, event.Evicted)
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not reported")
+	}
+
+	assert.ErrorIs(t, sub.Err(), subpub.ErrSlowConsumer)
+	// handler context is cancelled, so Unsubscribe doesn't hang
+	sub.Unsubscribe()
+
+	for _, subject := range sp.Stats().Subjects {
+		if subject.Subject == "reports" {
+			assert.Empty(t, subject.Subscriptions)
+		}
+	}
+}
+
+func TestWatchdogEvictsChan(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithWatchdog(subpub.SlowConsumerPolicy{
+		MaxQueueAge: 20 * time.Millisecond,
+		Evict:       true,
+		Interval:    10 * time.Millisecond,
+	}))
+	defer sp.Close(context.Background())
+
+	msgs, sub, err := sp.SubscribeChan("reports", subpub.WithChanBuffer(0, subpub.OverflowBlock))
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, sp.Publish("reports", i))
+	}
+
+	// nobody reads channel, so it's evicted and channel is closed
+	assert.Eventually(t, func() bool {
+		return errors.Is(sub.Err(), subpub.ErrSlowConsumer)
+	}, time.Second, time.Millisecond)
+
+	select {
+	case _, ok := <-msgs:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel was not closed on eviction")
+	}
+}
+
+func TestSysEvents(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	var events []subpub.SysEvent
+	var mu sync.Mutex
+	collect := func(msg interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, msg.(subpub.SysEvent))
+	}
+	var sysSubs []subpub.Subscription
+	for _, subject := range []string{subpub.SysTopicCreated, subpub.SysTopicRemoved, subpub.SysSubscriberJoined, subpub.SysSubscriberLeft, subpub.SysCloseStarted} {
+		sub, err := sp.Subscribe(subject, collect)
+		require.NoError(t, err)
+		sysSubs = append(sysSubs, sub)
+	}
+
+	first, err := sp.Subscribe("orders", func(msg interface{}) {})
+	require.NoError(t, err)
+	second, err := sp.Subscribe("orders", func(msg interface{}) {})
+	require.NoError(t, err)
+	first.Unsubscribe()
+	second.Unsubscribe()
+
+	require.NoError(t, sp.Close(context.Background()))
+
+	type event struct {
+		Type        string
+		Subject     string
+		ID          int64
+		Subscribers int
+	}
+	expected := []event{
+		{subpub.SysTopicCreated, "orders", 0, 0},
+		{subpub.SysSubscriberJoined, "orders", 6, 1},
+		{subpub.SysSubscriberJoined, "orders", 7, 2},
+		{subpub.SysSubscriberLeft, "orders", 6, 1},
+		{subpub.SysSubscriberLeft, "orders", 7, 0},
+		{subpub.SysTopicRemoved, "orders", 0, 0},
+		{subpub.SysCloseStarted, "", 0, 0},
+	}
+
+	// events of different $SYS subjects are delivered independently
+	mu.Lock()
+	defer mu.Unlock()
+	got := make([]event, 0, len(events))
+	for _, e := range events {
+		assert.False(t, e.Time.IsZero())
+		got = append(got, event{e.Type, e.Subject, e.SubscriptionID, e.Subscribers})
+	}
+	sort.Slice(got, func(i, j int) bool {
+		return slices.Index(expected, got[i]) < slices.Index(expected, got[j])
+	})
+	assert.Equal(t, expected, got)
+}
+
+func TestHooks(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	record := func(e subpub.SysEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e.Type+" "+e.Subject)
+	}
+
+	sp := subpub.NewSubPub(subpub.WithHooks(subpub.Hooks{
+		OnTopicCreated:     record,
+		OnTopicRemoved:     record,
+		OnSubscriberJoined: record,
+		OnSubscriberLeft:   record,
+		OnCloseStarted:     record,
+		OnCloseFinished:    record,
+	}))
+
+	// no events for $SYS subjects themselves
+	sysSub, err := sp.Subscribe(subpub.SysSubscriberJoined, func(msg interface{}) {})
+	require.NoError(t, err)
+	defer sysSub.Unsubscribe()
+
+	sub, err := sp.Subscribe("orders", func(msg interface{}) {})
+	require.NoError(t, err)
+	sub.Unsubscribe()
+
+	// topic is created again by the next subscriber
+	_, err = sp.Subscribe("orders", func(msg interface{}) {})
+	require.NoError(t, err)
+	// topic without subscribers is not removed on close
+	empty, err := sp.Subscribe("empty", func(msg interface{}) {})
+	require.NoError(t, err)
+	empty.Unsubscribe()
+
+	require.NoError(t, sp.Close(context.Background()))
+	require.NoError(t, sp.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		subpub.SysTopicCreated + " orders",
+		subpub.SysSubscriberJoined + " orders",
+		subpub.SysSubscriberLeft + " orders",
+		subpub.SysTopicRemoved + " orders",
+		subpub.SysTopicCreated + " orders",
+		subpub.SysSubscriberJoined + " orders",
+		subpub.SysTopicCreated + " empty",
+		subpub.SysSubscriberJoined + " empty",
+		subpub.SysSubscriberLeft + " empty",
+		subpub.SysTopicRemoved + " empty",
+		subpub.SysCloseStarted + " ",
+		subpub.SysTopicRemoved + " orders",
+		subpub.SysCloseFinished + " ",
+	}, events)
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type countingMetrics struct {
+	// published by subject, as $SYS events are published too
+	published sync.Map
+	delivered atomic.Int64
+	skipped   sync.Map
+}
+
+func (m *countingMetrics) MessagePublished(subject string) {
+	count, _ := m.published.LoadOrStore(subject, &atomic.Int64{})
+	count.(*atomic.Int64).Add(1)
+}
+
+func (m *countingMetrics) publishedOn(subject string) int64 {
+	count, ok := m.published.Load(subject)
+	if !ok {
+		return 0
+	}
+	return count.(*atomic.Int64).Load()
+}
+
+func (m *countingMetrics) MessageDelivered(string, time.Duration) { m.delivered.Add(1) }
+
+func (m *countingMetrics) MessageSkipped(_ string, reason subpub.DeliveryStatus) {
+	count, _ := m.skipped.LoadOrStore(reason, &atomic.Int64{})
+	count.(*atomic.Int64).Add(1)
+}
+
+func (m *countingMetrics) skippedFor(reason subpub.DeliveryStatus) int64 {
+	count, ok := m.skipped.Load(reason)
+	if !ok {
+		return 0
+	}
+	return count.(*atomic.Int64).Load()
+}
+
+func TestClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sp := subpub.NewSubPub(subpub.WithClock(clock), subpub.WithDedupWindow(time.Minute))
+
+	received := make(chan interface{}, 10)
+	sub, err := sp.Subscribe("db", func(msg interface{}) {
+		received <- msg
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	// TTL runs out only by clock of system
+	sub.Pause()
+	require.NoError(t, sp.Publish("db", "stale", subpub.WithTTL(time.Second)))
+	clock.Advance(2 * time.Second)
+	require.NoError(t, sp.Publish("db", "fresh", subpub.WithTTL(time.Second)))
+	sub.Resume()
+	assert.Equal(t, "fresh", <-received)
+
+	// so does dedup window
+	require.NoError(t, sp.Publish("db", 1, subpub.WithIdempotencyKey("k")))
+	require.NoError(t, sp.Publish("db", 2, subpub.WithIdempotencyKey("k")))
+	clock.Advance(2 * time.Minute)
+	require.NoError(t, sp.Publish("db", 3, subpub.WithIdempotencyKey("k")))
+	assert.Equal(t, 1, <-received)
+	assert.Equal(t, 3, <-received)
+
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+	sp := subpub.NewSubPub(subpub.WithMetrics(metrics))
+
+	// published with no subscribers is still published
+	require.NoError(t, sp.Publish("db", 0))
+
+	var wg sync.WaitGroup
+	sub, err := sp.Subscribe("db", func(msg interface{}) {
+		wg.Done()
+	})
+	require.NoError(t, err)
+
+	sub.Pause()
+	wg.Add(3)
+	require.NoError(t, sp.Publish("db", 1, subpub.WithTTL(time.Nanosecond)))
+	require.NoError(t, sp.Publish("db", 2))
+	require.NoError(t, sp.Publish("db", 3))
+	require.NoError(t, sp.Publish("db", 4))
+	time.Sleep(time.Millisecond)
+	sub.Resume()
+	wg.Wait()
+	sub.Unsubscribe()
+
+	assert.Equal(t, int64(5), metrics.publishedOn("db"))
+	assert.Equal(t, int64(1), metrics.publishedOn(subpub.SysSubscriberJoined))
+	assert.Equal(t, int64(3), metrics.delivered.Load())
+	assert.Equal(t, int64(1), metrics.skippedFor(subpub.DeliveryExpired))
+}
+
+func TestIDGenerator(t *testing.T) {
+	var next atomic.Int64
+	next.Store(100)
+	sp := subpub.NewSubPub(subpub.WithIDGenerator(func() int64 {
+		return next.Add(10)
+	}))
+
+	for _, subject := range []string{"a", "b"} {
+		sub, err := sp.Subscribe(subject, func(msg interface{}) {})
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	stats := sp.Stats()
+	assert.Equal(t, int64(110), stats.Subjects[0].Subscriptions[0].ID)
+	assert.Equal(t, int64(120), stats.Subjects[1].Subscriptions[0].ID)
+}
+
+func TestSubscribeDefaults(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithSubscribeDefaults(
+		subpub.WithQueueLimit(2, subpub.OverflowDropOldest),
+	))
+
+	received := make(chan interface{}, 10)
+	limited, err := sp.Subscribe("db", func(msg interface{}) {
+		received <- msg
+	})
+	require.NoError(t, err)
+	defer limited.Unsubscribe()
+
+	// options of subscription take precedence
+	unlimited, err := sp.Subscribe("db", func(msg interface{}) {}, subpub.WithQueueLimit(0, subpub.OverflowBlock))
+	require.NoError(t, err)
+	defer unlimited.Unsubscribe()
+
+	limited.Pause()
+	unlimited.Pause()
+	for i := range 5 {
+		require.NoError(t, sp.Publish("db", i))
+	}
+
+	queued := map[uint64]int64{}
+	for _, stats := range sp.Stats().Subjects[0].Subscriptions {
+		queued[stats.Dropped] = stats.Queued
+	}
+	assert.Equal(t, map[uint64]int64{3: 2, 0: 5}, queued)
+
+	limited.Resume()
+	assert.Equal(t, 3, <-received)
+	assert.Equal(t, 4, <-received)
+}
+
+func TestPublishInterceptors(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	trace := func(name string) subpub.PublishInterceptor {
+		return func(ctx context.Context, subject string, msg subpub.Message, next subpub.PublishFunc) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return next(ctx, subject, msg)
+		}
+	}
+	errForbidden := errors.New("forbidden")
+	auth := func(ctx context.Context, subject string, msg subpub.Message, next subpub.PublishFunc) error {
+		if subject == "secret" {
+			return errForbidden
+		}
+		return next(ctx, subject, msg)
+	}
+	enrich := func(ctx context.Context, subject string, msg subpub.Message, next subpub.PublishFunc) error {
+		headers := map[string]string{"enriched": "yes"}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		msg.Headers = headers
+		msg.Data = fmt.Sprint(msg.Data, "!")
+		return next(ctx, subject, msg)
+	}
+
+	sp := subpub.NewSubPub(
+		subpub.WithPublishInterceptors(trace("first"), auth),
+		subpub.WithPublishInterceptors(enrich, trace("last")),
+	)
+
+	msgs, sub, err := sp.SubscribeChan("db")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	secret, err := sp.Subscribe("secret", func(msg interface{}) {
+		t.Errorf("unexpected message %v", msg)
+	})
+	require.NoError(t, err)
+	defer secret.Unsubscribe()
+
+	headers := map[string]string{"id": "1"}
+	require.NoError(t, sp.Publish("db", "hi", subpub.WithHeaders(headers)))
+	msg := <-msgs
+	assert.Equal(t, "hi!", msg.Data)
+	assert.Equal(t, map[string]string{"id": "1", "enriched": "yes"}, msg.Headers)
+	assert.Equal(t, map[string]string{"id": "1"}, headers)
+
+	// short-circuit returns error to publisher
+	require.ErrorIs(t, sp.Publish("secret", "hi"), errForbidden)
+	_, err = sp.PublishAndWait(context.Background(), "secret", "hi")
+	require.ErrorIs(t, err, errForbidden)
+	tx := sp.Begin()
+	require.ErrorIs(t, tx.Publish("secret", "hi"), errForbidden)
+	require.NoError(t, tx.Publish("db", "tx"))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, "tx!", (<-msgs).Data)
+
+	report, err := sp.PublishAndWait(context.Background(), "db", "wait")
+	require.NoError(t, err)
+	assert.Len(t, report.Outcomes, 1)
+	assert.Equal(t, "wait!", (<-msgs).Data)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"first", "last",
+		"first", "first", "first",
+		"first", "last",
+		"first", "last",
+	}, order)
+}
+
+func TestDeliveryInterceptors(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	trace := func(name string) subpub.DeliveryInterceptor {
+		return func(ctx context.Context, msg subpub.Message, next subpub.DeliveryFunc) {
+			if msg.Subject == "db" {
+				mu.Lock()
+				order = append(order, name+" "+fmt.Sprint(msg.Data))
+				mu.Unlock()
+			}
+			next(ctx, msg)
+		}
+	}
+	// skips odd numbers
+	even := func(ctx context.Context, msg subpub.Message, next subpub.DeliveryFunc) {
+		if msg.Data.(int)%2 == 0 {
+			next(ctx, msg)
+		}
+	}
+
+	sp := subpub.NewSubPub(
+		subpub.WithDeadLetter("dead"),
+		subpub.WithDeliveryInterceptors(trace("global")),
+	)
+
+	letters := make(chan subpub.DeadLetter, 10)
+	dead, err := sp.Subscribe("dead", func(msg interface{}) {
+		letters <- msg.(subpub.DeadLetter)
+	})
+	require.NoError(t, err)
+	defer dead.Unsubscribe()
+
+	received := make(chan interface{}, 10)
+	sub, err := sp.Subscribe("db", func(msg interface{}) {
+		received <- msg
+	}, subpub.WithSubscriptionInterceptors(even, trace("sub")))
+	require.NoError(t, err)
+
+	for i := range 4 {
+		require.NoError(t, sp.Publish("db", i))
+	}
+	assert.Equal(t, 0, <-received)
+	assert.Equal(t, 2, <-received)
+
+	letter := <-letters
+	assert.Equal(t, 1, letter.Msg)
+	assert.Equal(t, subpub.DeliveryFiltered, letter.Reason)
+	assert.Equal(t, 3, (<-letters).Msg)
+
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, uint64(2), stats.Delivered)
+	assert.Equal(t, uint64(2), stats.Filtered)
+	sub.Unsubscribe()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"global 0", "sub 0",
+		"global 1",
+		"global 2", "sub 2",
+		"global 3",
+	}, order)
+}
+
+func TestDeliveryInterceptorsBatch(t *testing.T) {
+	sp := subpub.NewSubPub()
+
+	batches := make(chan []interface{}, 10)
+	sub, err := sp.SubscribeBatch("rows", func(msgs []subpub.Message) {
+		var batch []interface{}
+		for _, msg := range msgs {
+			batch = append(batch, msg.Data)
+		}
+		batches <- batch
+	}, subpub.WithBatching(3, time.Hour), subpub.WithSubscriptionInterceptors(
+		func(ctx context.Context, msg subpub.Message, next subpub.DeliveryFunc) {
+			if msg.Data.(int)%2 == 0 {
+				msg.Data = msg.Data.(int) * 10
+				next(ctx, msg)
+			}
+		},
+	))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	sub.Pause()
+	for i := range 6 {
+		require.NoError(t, sp.Publish("rows", i))
+	}
+	sub.Resume()
+
+	// filtered messages still count towards batch size
+	assert.Equal(t, []interface{}{0, 20}, <-batches)
+	assert.Equal(t, []interface{}{40}, <-batches)
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, uint64(3), stats.Delivered)
+	assert.Equal(t, uint64(3), stats.Filtered)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	sp := subpub.NewSubPub(subpub.WithTracing(provider))
+
+	handled := make(chan trace.SpanContext, 1)
+	sub, err := sp.SubscribeContext("orders", func(ctx context.Context, msg interface{}) {
+		handled <- trace.SpanContextFromContext(ctx)
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	msgs, chanSub, err := sp.SubscribeChan("orders")
+	require.NoError(t, err)
+	defer chanSub.Unsubscribe()
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, sp.PublishContext(ctx, "orders", 1))
+	root.End()
+
+	handlerSpan := <-handled
+	msg := <-msgs
+	chanSpan := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(
+		context.Background(), propagation.MapCarrier(msg.Headers),
+	))
+
+	require.Eventually(t, func() bool {
+		return len(exporter.GetSpans()) == 4
+	}, time.Second, 10*time.Millisecond)
+	spans := map[string][]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+
+	send := spans["send orders"]
+	require.Len(t, send, 1)
+	assert.Equal(t, trace.SpanKindProducer, send[0].SpanKind)
+	assert.Equal(t, root.SpanContext().SpanID(), send[0].Parent.SpanID())
+
+	process := spans["process orders"]
+	require.Len(t, process, 2)
+	var processIDs []trace.SpanID
+	for _, span := range process {
+		assert.Equal(t, trace.SpanKindConsumer, span.SpanKind)
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, send[0].SpanContext.SpanID(), span.Parent.SpanID())
+		processIDs = append(processIDs, span.SpanContext.SpanID())
+	}
+	assert.Contains(t, processIDs, handlerSpan.SpanID())
+	assert.Contains(t, processIDs, chanSpan.SpanID())
+	assert.NotEqual(t, handlerSpan.SpanID(), chanSpan.SpanID())
+}
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		match   bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.eu", false},
+		{"orders.eu", "orders", false},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.eu.removed", false},
+		{"orders.>", "orders.eu", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"*", "", false},
+		{"orders.*", "orders.", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, subpub.MatchSubject(tt.pattern, tt.subject), "%q ~ %q", tt.pattern, tt.subject)
+	}
+
+	require.NoError(t, subpub.ValidatePattern("orders.*.>"))
+	require.ErrorIs(t, subpub.ValidatePattern("orders..eu"), subpub.ErrInvalidPattern)
+	require.ErrorIs(t, subpub.ValidatePattern("orders.>.eu"), subpub.ErrInvalidPattern)
+	require.ErrorIs(t, subpub.ValidatePattern(""), subpub.ErrInvalidPattern)
+}
+
+func TestSubjectMapping(t *testing.T) {
+	mapping, err := subpub.CompileMapping(subpub.MappingRules{
+		Maps: []subpub.MapRule{
+			{Source: "old.*.>", Destinations: []subpub.WeightedSubject{{Subject: "new.$2.$1"}}},
+		},
+		Mirrors: []subpub.MirrorRule{
+			{Source: "new.>", Destination: "audit.$1"},
+		},
+	})
+	require.NoError(t, err)
+	sp := subpub.NewSubPub(subpub.WithSubjectMapping(mapping))
+
+	msgs, sub, err := sp.SubscribeChan("new.orders.created.eu")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	audit, auditSub, err := sp.SubscribeChan("audit.orders.created.eu")
+	require.NoError(t, err)
+	defer auditSub.Unsubscribe()
+
+	require.NoError(t, sp.Publish("old.eu.orders.created", "publish"))
+	report, err := sp.PublishAndWait(context.Background(), "old.eu.orders.created", "wait")
+	require.NoError(t, err)
+	assert.Equal(t, "new.orders.created.eu", report.Subject)
+	assert.Len(t, report.Outcomes, 1)
+	tx := sp.Begin()
+	require.NoError(t, tx.Publish("old.eu.orders.created", "tx"))
+	require.NoError(t, tx.Commit())
+
+	for _, data := range []string{"publish", "wait", "tx"} {
+		msg := <-msgs
+		assert.Equal(t, "new.orders.created.eu", msg.Subject)
+		assert.Equal(t, data, msg.Data)
+		assert.Equal(t, data, (<-audit).Data)
+	}
+
+	// reloaded mapping applies to following messages
+	assert.Same(t, mapping, sp.SubjectMapping())
+	sp.SetSubjectMapping(nil)
+	assert.Nil(t, sp.SubjectMapping())
+	require.NoError(t, sp.Publish("new.orders.created.eu", "direct"))
+	assert.Equal(t, "direct", (<-msgs).Data)
+	select {
+	case msg := <-audit:
+		t.Fatalf("unexpected mirrored %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubjectMappingWeights(t *testing.T) {
+	mapping, err := subpub.CompileMapping(subpub.MappingRules{
+		Maps: []subpub.MapRule{{Source: "orders", Destinations: []subpub.WeightedSubject{
+			{Subject: "orders.stable", Weight: 3},
+			{Subject: "orders.canary", Weight: 1},
+			{Subject: "orders.never", Weight: 0},
+		}}},
+	})
+	require.NoError(t, err)
+	sp := subpub.NewSubPub(subpub.WithSubjectMapping(mapping))
+
+	counts := map[string]int{}
+	for _, subject := range []string{"orders.stable", "orders.canary", "orders.never"} {
+		_, err := sp.Subscribe(subject, func(interface{}) {})
+		require.NoError(t, err)
+	}
+
+	const messages = 1000
+	for range messages {
+		report, err := sp.PublishAndWait(context.Background(), "orders", "order")
+		require.NoError(t, err)
+		counts[report.Subject]++
+	}
+	assert.Zero(t, counts["orders.never"])
+	assert.Equal(t, messages, counts["orders.stable"]+counts["orders.canary"])
+	assert.InDelta(t, messages/4, counts["orders.canary"], messages/10)
+}
+
+func TestCompileMapping(t *testing.T) {
+	invalid := []subpub.MappingRules{
+		{Maps: []subpub.MapRule{{Source: "orders..eu", Destinations: []subpub.WeightedSubject{{Subject: "new"}}}}},
+		{Maps: []subpub.MapRule{{Source: "orders"}}},
+		{Maps: []subpub.MapRule{{Source: "orders.*", Destinations: []subpub.WeightedSubject{{Subject: "new.*"}}}}},
+		{Maps: []subpub.MapRule{{Source: "orders.*", Destinations: []subpub.WeightedSubject{{Subject: "new.$2"}}}}},
+		{Maps: []subpub.MapRule{{Source: "orders", Destinations: []subpub.WeightedSubject{{Subject: "new", Weight: -1}}}}},
+		{Mirrors: []subpub.MirrorRule{{Source: "orders.>", Destination: "copy.$0"}}},
+	}
+	for _, rules := range invalid {
+		_, err := subpub.CompileMapping(rules)
+		require.ErrorIs(t, err, subpub.ErrInvalidMapping, "%+v", rules)
+	}
+
+	rules := subpub.MappingRules{Mirrors: []subpub.MirrorRule{{Source: "orders.>", Destination: "copy.$1"}}}
+	mapping, err := subpub.CompileMapping(rules)
+	require.NoError(t, err)
+	assert.Equal(t, rules, mapping.Rules())
+}
+
+func TestPartitionsOrder(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 4}))
+
+	var mu sync.Mutex
+	byKey := map[string][]int{}
+	partitionOf := map[string]int{}
+	var wg sync.WaitGroup
+	handler := func(ctx context.Context, msg subpub.GroupMessage) {
+		defer wg.Done()
+		key := msg.Headers["key"]
+		mu.Lock()
+		defer mu.Unlock()
+		byKey[key] = append(byKey[key], msg.Data.(int))
+		if p, ok := partitionOf[key]; ok {
+			assert.Equal(t, p, msg.Partition, "key %s moved between partitions", key)
+		}
+		partitionOf[key] = msg.Partition
+	}
+
+	for range 2 {
+		sub, err := sp.SubscribeGroup("orders", "billing", handler)
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	const messages = 200
+	wg.Add(messages)
+	for i := range messages {
+		key := strconv.Itoa(i % 10)
+		require.NoError(t, sp.Publish("orders", i, subpub.WithPartitionKey(key), subpub.WithHeaders(map[string]string{"key": key})))
+	}
+	wg.Wait()
+
+	mu.Lock()
+	for key, values := range byKey {
+		assert.True(t, sort.IntsAreSorted(values), "key %s out of order: %v", key, values)
+		assert.Len(t, values, messages/10)
+	}
+	mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		stats := sp.Stats().Groups
+		if len(stats) != 1 {
+			return false
+		}
+		for _, p := range stats[0].Partitions {
+			if p.Lag() != 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := sp.SubscribeGroup("users", "billing", handler)
+	require.ErrorIs(t, err, subpub.ErrNotPartitioned)
+}
+
+func TestGroupRebalance(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 4}))
+
+	received := make(chan subpub.GroupMessage, 100)
+	handler := func(ctx context.Context, msg subpub.GroupMessage) {
+		received <- msg
+	}
+
+	first, err := sp.SubscribeGroup("orders", "billing", handler)
+	require.NoError(t, err)
+	owners := func() []int64 {
+		var ids []int64
+		for _, p := range sp.Stats().Groups[0].Partitions {
+			ids = append(ids, p.Owner)
+		}
+		return ids
+	}
+	firstID := sp.Stats().Groups[0].Members[0]
+	assert.Equal(t, []int64{firstID, firstID, firstID, firstID}, owners())
+
+	second, err := sp.SubscribeGroup("orders", "billing", handler)
+	require.NoError(t, err)
+	secondID := sp.Stats().Groups[0].Members[1]
+	assert.Equal(t, []int64{firstID, firstID, secondID, secondID}, owners())
+
+	first.Unsubscribe()
+	assert.Equal(t, []int64{secondID, secondID, secondID, secondID}, owners())
+
+	// offsets stay with group while it has no members
+	second.Unsubscribe()
+	assert.Equal(t, []int64{0, 0, 0, 0}, owners())
+	for i := range 4 {
+		require.NoError(t, sp.Publish("orders", i))
+	}
+	// messages without key are spread round robin
+	for _, p := range sp.Stats().Groups[0].Partitions {
+		assert.Equal(t, int64(1), p.Lag())
+	}
+
+	third, err := sp.SubscribeGroup("orders", "billing", handler)
+	require.NoError(t, err)
+	defer third.Unsubscribe()
+	seen := map[int]int64{}
+	for range 4 {
+		msg := <-received
+		seen[msg.Partition] = msg.Offset
+	}
+	assert.Equal(t, map[int]int64{0: 0, 1: 0, 2: 0, 3: 0}, seen)
+
+	// other group starts from the end
+	other, err := sp.SubscribeGroup("orders", "audit", func(ctx context.Context, msg subpub.GroupMessage) {
+		t.Errorf("unexpected message %v", msg)
+	})
+	require.NoError(t, err)
+	other.Unsubscribe()
+}
+
+func TestPartitionRetention(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 1, Retention: 2}))
+
+	sub, err := sp.SubscribeGroup("orders", "billing", func(ctx context.Context, msg subpub.GroupMessage) {})
+	require.NoError(t, err)
+	sub.Unsubscribe()
+
+	for i := range 5 {
+		require.NoError(t, sp.Publish("orders", i))
+	}
+	partition := sp.Stats().Groups[0].Partitions[0]
+	assert.Equal(t, int64(3), partition.Offset)
+	assert.Equal(t, int64(5), partition.End)
+	assert.Equal(t, uint64(3), partition.Dropped)
+
+	received := make(chan subpub.GroupMessage, 2)
+	sub, err = sp.SubscribeGroup("orders", "billing", func(ctx context.Context, msg subpub.GroupMessage) {
+		received <- msg
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	assert.Equal(t, 3, (<-received).Data)
+	assert.Equal(t, 4, (<-received).Data)
+}
+
+func TestGroupsTx(t *testing.T) {
+	sp := subpub.NewSubPub(
+		subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 2}),
+		subpub.WithPartitions("payments", subpub.PartitionPolicy{Partitions: 2}),
+	)
+
+	received := make(chan subpub.GroupMessage, 2)
+	for _, subject := range []string{"orders", "payments"} {
+		sub, err := sp.SubscribeGroup(subject, "billing", func(ctx context.Context, msg subpub.GroupMessage) {
+			received <- msg
+		})
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	tx := sp.Begin()
+	require.NoError(t, tx.Publish("orders", "order", subpub.WithPartitionKey("42")))
+	require.NoError(t, tx.Publish("payments", "payment", subpub.WithPartitionKey("42")))
+	require.NoError(t, tx.Commit())
+
+	var data []interface{}
+	for range 2 {
+		data = append(data, (<-received).Data)
+	}
+	assert.ElementsMatch(t, []interface{}{"order", "payment"}, data)
+
+	require.NoError(t, sp.Close(context.Background()))
+	_, err := sp.SubscribeGroup("orders", "billing", func(ctx context.Context, msg subpub.GroupMessage) {})
+	require.ErrorIs(t, err, subpub.ErrClosed)
+}
+
+func TestSubscribeGroupChan(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 1}))
+
+	first, firstSub, err := sp.SubscribeGroupChan("orders", "billing")
+	require.NoError(t, err)
+	require.NoError(t, sp.Publish("orders", "order"))
+	msg := <-first
+	assert.Equal(t, "order", msg.Data)
+	assert.Equal(t, int64(0), msg.Offset)
+
+	// message not received before leaving goes to the next member
+	require.NoError(t, sp.Publish("orders", "unread"))
+	require.Eventually(t, func() bool {
+		return sp.Stats().Groups[0].Partitions[0].End == 2
+	}, time.Second, time.Millisecond)
+	firstSub.Unsubscribe()
+	_, ok := <-first
+	assert.False(t, ok)
+
+	second, secondSub, err := sp.SubscribeGroupChan("orders", "billing")
+	require.NoError(t, err)
+	msg = <-second
+	assert.Equal(t, "unread", msg.Data)
+	assert.Equal(t, int64(1), msg.Offset)
+
+	// channel is closed with system
+	require.NoError(t, sp.Close(context.Background()))
+	_, ok = <-second
+	assert.False(t, ok)
+	secondSub.Unsubscribe()
+}
+
+func TestGroupMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+	sp := subpub.NewSubPub(
+		subpub.WithMetrics(metrics),
+		subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 1}),
+	)
+	defer sp.Close(context.Background())
+
+	msgs, sub, err := sp.SubscribeGroupChan("orders", "billing", subpub.WithSubscriptionInterceptors(
+		func(ctx context.Context, msg subpub.Message, next subpub.DeliveryFunc) {
+			if msg.Data != "filtered" {
+				next(ctx, msg)
+			}
+		},
+	))
+	require.NoError(t, err)
+
+	require.NoError(t, sp.Publish("orders", "filtered"))
+	require.NoError(t, sp.Publish("orders", "order"))
+	assert.Equal(t, "order", (<-msgs).Data)
+
+	// message member has left with is not delivered
+	require.NoError(t, sp.Publish("orders", "unread"))
+	time.Sleep(10 * time.Millisecond)
+	sub.Unsubscribe()
+
+	assert.Equal(t, int64(1), metrics.delivered.Load())
+	assert.Equal(t, int64(1), metrics.skippedFor(subpub.DeliveryFiltered))
+}
+
+func TestAck(t *testing.T) {
+	sp := subpub.NewSubPub()
+	defer sp.Close(context.Background())
+
+	msgs, sub, err := sp.SubscribeChan("jobs", subpub.WithAck(20*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, sp.Publish("jobs", "first"))
+	require.NoError(t, sp.Publish("jobs", "second"))
+
+	msg := <-msgs
+	assert.Equal(t, "first", msg.Data)
+	assert.Equal(t, 1, msg.Delivery)
+	assert.True(t, msg.Ack())
+	assert.False(t, msg.Ack(), "message is acked only once")
+
+	// not acked in time, so delivered again
+	msg = <-msgs
+	assert.Equal(t, "second", msg.Data)
+	assert.Equal(t, 1, msg.Delivery)
+	redelivered := <-msgs
+	assert.Equal(t, "second", redelivered.Data)
+	assert.Equal(t, 2, redelivered.Delivery)
+	assert.False(t, msg.Ack(), "previous delivery can't be acked")
+	assert.True(t, redelivered.Ack())
+
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, uint64(2), stats.Delivered)
+	assert.Equal(t, uint64(1), stats.Redelivered)
+	assert.Equal(t, 0, stats.Unacked)
+
+	// messages of other subscriptions don't need ack
+	other, otherSub, err := sp.SubscribeChan("jobs")
+	require.NoError(t, err)
+	defer otherSub.Unsubscribe()
+	require.NoError(t, sp.Publish("jobs", "third"))
+	msg = <-other
+	assert.Equal(t, 0, msg.Delivery)
+	assert.False(t, msg.Ack())
+	assert.True(t, (<-msgs).Ack())
+}
+
+func TestAckMaxDeliver(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithDeadLetter("dead"))
+	defer sp.Close(context.Background())
+
+	letters := make(chan subpub.DeadLetter, 1)
+	dead, err := sp.Subscribe("dead", func(msg interface{}) {
+		letters <- msg.(subpub.DeadLetter)
+	})
+	require.NoError(t, err)
+	defer dead.Unsubscribe()
+
+	msgs, sub, err := sp.SubscribeChan("jobs", subpub.WithAck(10*time.Millisecond, 3))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	reports := make(chan subpub.DeliveryReport)
+	go func() {
+		report, _ := sp.PublishAndWait(context.Background(), "jobs", "job")
+		reports <- report
+	}()
+
+	for i := range 3 {
+		msg := <-msgs
+		assert.Equal(t, i+1, msg.Delivery)
+	}
+
+	report := <-reports
+	require.Len(t, report.Outcomes, 1)
+	assert.Equal(t, subpub.DeliveryNotAcked, report.Outcomes[0].Status)
+
+	letter := <-letters
+	assert.Equal(t, "job", letter.Msg)
+	assert.Equal(t, subpub.DeliveryNotAcked, letter.Reason)
+
+	stats := sp.Stats().Subjects[1].Subscriptions[0]
+	assert.Equal(t, uint64(2), stats.Redelivered)
+	assert.Equal(t, uint64(1), stats.NotAcked)
+	assert.Equal(t, uint64(0), stats.Delivered)
+
+	select {
+	case msg := <-msgs:
+		t.Fatalf("message %v delivered after max deliveries", msg.Data)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestAckBatch(t *testing.T) {
+	sp := subpub.NewSubPub()
+	defer sp.Close(context.Background())
+
+	batches := make(chan []subpub.Message, 10)
+	sub, err := sp.SubscribeBatch("jobs", func(msgs []subpub.Message) {
+		batches <- msgs
+	}, subpub.WithBatching(2, 50*time.Millisecond), subpub.WithAck(20*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, sp.Publish("jobs", "first"))
+	require.NoError(t, sp.Publish("jobs", "second"))
+
+	batch := <-batches
+	require.Len(t, batch, 2)
+	assert.Equal(t, 1, batch[0].Delivery)
+	assert.True(t, batch[0].AwaitsAck())
+	assert.True(t, batch[0].Ack())
+
+	// the rest of batch is redelivered once ack wait is over
+	batch = <-batches
+	require.Len(t, batch, 1)
+	assert.Equal(t, "second", batch[0].Data)
+	assert.Equal(t, 2, batch[0].Delivery)
+	assert.True(t, batch[0].Ack())
+
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, uint64(2), stats.Delivered)
+	assert.Equal(t, uint64(1), stats.Redelivered)
+	assert.Equal(t, 0, stats.Unacked)
+}
+
+func TestAckUnsupported(t *testing.T) {
+	sp := subpub.NewSubPub(subpub.WithPartitions("orders", subpub.PartitionPolicy{Partitions: 2}))
+	defer sp.Close(context.Background())
+
+	ack := subpub.WithAck(time.Second, 0)
+	_, err := sp.Subscribe("jobs", func(interface{}) {}, ack)
+	assert.ErrorIs(t, err, subpub.ErrAckUnsupported)
+	_, err = sp.SubscribeContext("jobs", func(context.Context, interface{}) {}, ack)
+	assert.ErrorIs(t, err, subpub.ErrAckUnsupported)
+	_, err = sp.SubscribeGroup("orders", "workers", func(context.Context, subpub.GroupMessage) {}, ack)
+	assert.ErrorIs(t, err, subpub.ErrAckUnsupported)
+	assert.Empty(t, sp.Stats().Subjects)
+}
+
+func TestAckPublishAndWait(t *testing.T) {
+	sp := subpub.NewSubPub()
+	defer sp.Close(context.Background())
+
+	msgs, sub, err := sp.SubscribeChan("jobs", subpub.WithAck(time.Minute, 0))
+	require.NoError(t, err)
+
+	reports := make(chan subpub.DeliveryReport)
+	go func() {
+		report, _ := sp.PublishAndWait(context.Background(), "jobs", "job")
+		reports <- report
+	}()
+
+	msg := <-msgs
+	select {
+	case <-reports:
+		t.Fatal("publisher must wait for ack")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, 1, sp.Stats().Subjects[0].Subscriptions[0].Unacked)
+	assert.True(t, msg.Ack())
+	assert.Equal(t, 1, (<-reports).Delivered())
+
+	// unacked message is dropped on Unsubscribe
+	go func() {
+		report, _ := sp.PublishAndWait(context.Background(), "jobs", "job")
+		reports <- report
+	}()
+	msg = <-msgs
+	sub.Unsubscribe()
+	assert.Equal(t, subpub.DeliveryDropped, (<-reports).Outcomes[0].Status)
+	assert.False(t, msg.Ack())
+}
+
+func TestExactlyOnce(t *testing.T) {
+	sp := subpub.NewSubPub()
+	defer sp.Close(context.Background())
+
+	msgs, sub, err := sp.SubscribeChan("payments", subpub.WithAck(10*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	var processed []string
+	failures := 1
+	process := subpub.ExactlyOnce(subpub.NewMemoryStore(10), time.Minute, func(ctx context.Context, msg subpub.Message) error {
+		// fails partway once, so message is redelivered
+		if failures > 0 {
+			failures--
+			return errors.New("failed")
+		}
+		processed = append(processed, msg.Data.(string))
+		return nil
+	})
+
+	id := func(id string) subpub.PublishOption {
+		return subpub.WithHeaders(map[string]string{subpub.MessageIDHeader: id})
+	}
+	require.NoError(t, sp.Publish("payments", "first", id("1")))
+	assert.EqualError(t, process(context.Background(), <-msgs), "failed")
+	msg := <-msgs
+	assert.Equal(t, 2, msg.Delivery)
+	assert.NoError(t, process(context.Background(), msg))
+
+	require.NoError(t, sp.Publish("payments", "copy of first", id("1")))
+	require.NoError(t, sp.Publish("payments", "second", id("2")))
+	require.NoError(t, sp.Publish("payments", "no id"))
+	assert.NoError(t, process(context.Background(), <-msgs))
+	assert.NoError(t, process(context.Background(), <-msgs))
+	assert.ErrorIs(t, process(context.Background(), <-msgs), subpub.ErrNoMessageID)
+	assert.Equal(t, []string{"first", "second"}, processed)
+
+	// every processed or suppressed message is acked,
+	// only message without ID is left
+	stats := sp.Stats().Subjects[0].Subscriptions[0]
+	assert.Equal(t, uint64(3), stats.Delivered)
+	assert.Equal(t, 1, stats.Unacked)
+}
+
+func TestExactlyOnceWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	var calls atomic.Int32
+	process := subpub.ExactlyOnce(subpub.NewMemoryStore(10), time.Minute, func(ctx context.Context, msg subpub.Message) error {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}, subpub.WithIDFunc(func(msg subpub.Message) string {
+		return msg.Data.(string)
+	}), subpub.WithStoreClock(clock))
+
+	// concurrent copies are processed one at a time
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, process(context.Background(), subpub.Message{Data: "id"}))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	clock.Advance(time.Minute)
+	require.NoError(t, process(context.Background(), subpub.Message{Data: "id"}))
+	assert.Equal(t, int32(2), calls.Load(), "ID is forgotten after window")
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := subpub.NewMemoryStore(2)
+
+	require.NoError(t, store.Record("a", now.Add(time.Minute)))
+	require.NoError(t, store.Record("b", now.Add(time.Minute)))
+	// a is used recently, so b is evicted
+	seen, err := store.Seen("a", now)
+	require.NoError(t, err)
+	assert.True(t, seen)
+	require.NoError(t, store.Record("c", now.Add(time.Minute)))
+	assert.Equal(t, 2, store.Len())
+
+	for id, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		seen, err := store.Seen(id, now)
+		require.NoError(t, err)
+		assert.Equal(t, expected, seen, id)
+	}
+
+	seen, err = store.Seen("a", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, seen, "expired ID is not seen")
+}
+
+func TestFileStore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	path := t.TempDir() + "/processed"
+
+	store, err := subpub.OpenFileStore(path, clock)
+	require.NoError(t, err)
+	require.NoError(t, store.Record("short", clock.Now().Add(time.Second)))
+	require.NoError(t, store.Record("with \"quotes\"\n", clock.Now().Add(time.Minute)))
+	require.NoError(t, store.Close())
+	assert.Error(t, store.Record("closed", clock.Now().Add(time.Minute)))
+
+	// line torn by crash is skipped
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString("123 \"torn")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	clock.Advance(time.Second)
+	store, err = subpub.OpenFileStore(path, clock)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for id, expected := range map[string]bool{"short": false, "with \"quotes\"\n": true, "torn": false} {
+		seen, err := store.Seen(id, clock.Now())
+		require.NoError(t, err)
+		assert.Equal(t, expected, seen, id)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "short", "expired IDs are compacted")
+
+	require.NoError(t, os.WriteFile(path, []byte("garbage\n1 \"id\"\n"), 0o600))
+	_, err = subpub.OpenFileStore(path, clock)
+	assert.ErrorIs(t, err, subpub.ErrCorruptStore)
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	path := t.TempDir() + "/processed"
+
+	store, err := subpub.OpenFileStore(path, clock)
+	require.NoError(t, err)
+	defer store.Close()
+
+	const n = 1024
+	for i := range n {
+		require.NoError(t, store.Record("old"+strconv.Itoa(i), clock.Now().Add(time.Second)))
+	}
+	clock.Advance(time.Minute)
+	for i := range n - 1 {
+		require.NoError(t, store.Record("new"+strconv.Itoa(i), clock.Now().Add(time.Hour)))
+	}
+	grown, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// file has doubled, so expired IDs are dropped from it
+	require.NoError(t, store.Record("last", clock.Now().Add(time.Hour)))
+	compacted, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, compacted.Size(), grown.Size(), "file has shrunk")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "old")
+	assert.Equal(t, n, strings.Count(string(data), "\n"))
+
+	seen, err := store.Seen("old0", clock.Now())
+	require.NoError(t, err)
+	assert.False(t, seen)
+	seen, err = store.Seen("last", clock.Now())
+	require.NoError(t, err)
+	assert.True(t, seen)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	policy := subpub.WithPartitions("jobs", subpub.PartitionPolicy{Partitions: 2})
+	sp := subpub.NewSubPub(policy)
+
+	msgs, sub, err := sp.SubscribeGroupChan("jobs", "workers")
+	require.NoError(t, err)
+	for i := range 4 {
+		require.NoError(t, sp.Publish("jobs", i, subpub.WithPartitionKey("key"), subpub.WithHeaders(map[string]string{"n": strconv.Itoa(i)})))
+	}
+	// group has processed one message, three are retained
+	<-msgs
+	sub.Unsubscribe()
+	require.Eventually(t, func() bool {
+		groups := sp.Stats().Groups
+		return len(groups) == 1 && len(groups[0].Members) == 0
+	}, time.Second, time.Millisecond)
+
+	id, err := sp.PublishAfter("later", "scheduled", time.Hour, subpub.WithTTL(time.Minute), subpub.WithPriority(subpub.PriorityHigh))
+	require.NoError(t, err)
+
+	snapshot := sp.Snapshot()
+	require.NoError(t, sp.Close(context.Background()))
+
+	require.Len(t, snapshot.Subjects, 0, "no subject has subscribers")
+	require.Len(t, snapshot.Topics, 1)
+	require.Len(t, snapshot.Topics[0].Groups, 1)
+	require.Len(t, snapshot.Scheduled, 1)
+	assert.Equal(t, subpub.ScheduledSnapshot{
+		ID:        id,
+		Subject:   "later",
+		Data:      "scheduled",
+		DeliverAt: snapshot.Scheduled[0].DeliverAt,
+		TTL:       time.Minute,
+		Priority:  subpub.PriorityHigh,
+	}, snapshot.Scheduled[0])
+
+	restored := subpub.NewSubPub(policy)
+	defer restored.Close(context.Background())
+	require.NoError(t, restored.Restore(snapshot))
+
+	assert.Equal(t, snapshot.Scheduled, restored.Snapshot().Scheduled)
+	next, err := restored.PublishAfter("later", "next", time.Hour)
+	require.NoError(t, err)
+	assert.Greater(t, next, id, "IDs of restored messages are not reused")
+
+	// group continues from its offset
+	msgs, sub, err = restored.SubscribeGroupChan("jobs", "workers")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	for i := 1; i < 4; i++ {
+		msg := <-msgs
+		assert.Equal(t, i, msg.Data)
+		assert.Equal(t, strconv.Itoa(i), msg.Headers["n"])
+		assert.Equal(t, int64(i), msg.Offset)
+	}
+
+	assert.ErrorIs(t, restored.Restore(snapshot), subpub.ErrInvalidSnapshot, "system is not fresh")
+	assert.ErrorIs(t, subpub.NewSubPub().Restore(snapshot), subpub.ErrInvalidSnapshot, "subject is not partitioned")
+}
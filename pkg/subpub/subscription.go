@@ -1,144 +1,637 @@
 package subpub
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// handler is what subscription delivers messages to,
+// exactly one field is set.
+type handler struct {
+	cb    ContextMessageHandler
+	batch BatchHandler
+	sink  *chanSink
+}
+
 type subscription struct {
-	id       int64
-	receiver chan interface{}
-	cb       MessageHandler
-	b        *broadcaster
+	id  int64
+	b   *broadcaster
+	cfg subscribeConfig
+
+	handler handler
+
+	// limiter is nil unless subscription is rate limited.
+	limiter *tokenBucket
+
+	// deliver is chain of delivery interceptors ending with pass.
+	// status and batched are its results, used by processor only.
+	deliver DeliveryFunc
+	status  DeliveryStatus
+	batched []Message
+
+	// ctx is passed to every handler call,
+	// cancelled once subscription is closed or aborted.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mut guards queue, unacked and transitions
+	// out of StateOpen, cond is bound to it.
+	mut   *sync.Mutex
+	cond  *sync.Cond
+	state *lifecycle
+	queue *messageQueue
+
+	// unacked are messages awaiting ack by their ID in ack mode.
+	unacked map[uint64]*unacked
+	ackSeq  uint64
+
+	// pending counts messages not yet passed to handler,
+	// both in queue and in batch grabbed by processor.
+	pending *atomic.Int64
+	running *atomic.Bool
+	aborted *atomic.Bool
 
-	active *atomic.Bool
+	// paused is set under mut, but read by processor without it.
+	paused *atomic.Bool
 
-	mut          *sync.Mutex
-	cond         *sync.Cond
-	queueLen     *atomic.Int64
-	messageQueue []interface{}
+	// inflightQueuedAt and handlerStarted are unix nanos of when
+	// message being processed was queued and when handler was
+	// called with it, zero if there is none. Used by watchdog.
+	inflightQueuedAt *atomic.Int64
+	handlerStarted   *atomic.Int64
+
+	// slow is set by watchdog while subscription is slow.
+	slow *atomic.Bool
+	// err is set once subscription is evicted.
+	err *atomic.Pointer[error]
+
+	delivered *atomic.Uint64
+	expired   *atomic.Uint64
+	replaced  *atomic.Uint64
+	dropped   *atomic.Uint64
+	filtered  *atomic.Uint64
+
+	redelivered *atomic.Uint64
+	notAcked    *atomic.Uint64
 
-	receiverClosed  chan struct{}
 	processorClosed chan struct{}
 }
 
-// messageReceiver receives messages and
-// moves them to the internal queue.
+// enqueue appends message to the internal queue
+// and wakes processor up.
 //
-// Stops on closing of s.receiver.
+// Never waits for handler, only for short critical section
+// shared with processor.
 //
-// Blocking call, should be used in goroutine.
-func (s *subscription) messageReceiver() {
-	for message := range s.receiver {
-		s.mut.Lock()
-		s.messageQueue = append(s.messageQueue, message)
-		s.queueLen.Add(1)
-		s.mut.Unlock()
+// Returns false if subscription is not open anymore. If queue is full,
+// returns message dropped by overflow policy, which must be passed to
+// overflow by caller once mut of system is released, if it's held.
+func (s *subscription) enqueue(message envelope) (bool, *envelope) {
+	key := s.conflationKey(message)
+
+	s.mut.Lock()
+	ok, dropped := s.enqueueLocked(message, key)
+	s.mut.Unlock()
+
+	if ok {
 		s.cond.Signal()
 	}
-	s.receiverClosed <- struct{}{}
+	return ok, dropped
+}
+
+// conflationKey returns key of message for conflating subscription,
+// empty string otherwise.
+func (s *subscription) conflationKey(message envelope) string {
+	if s.cfg.conflationKey == nil {
+		return ""
+	}
+	return s.cfg.conflationKey(Message{
+		Subject: s.b.subject,
+		Data:    message.payload,
+		Headers: message.headers,
+	})
+}
+
+// enqueueLocked does the same as enqueue, but s.mut must be held
+// and processor must be signalled by caller.
+//
+// If queue is full, returns message dropped by overflow policy,
+// which must be passed to overflow by caller once s.mut is released.
+func (s *subscription) enqueueLocked(message envelope, key string) (bool, *envelope) {
+	if s.state.Load() != StateOpen {
+		return false, nil
+	}
+	message.key = key
+	if s.cfg.watched {
+		message.queuedAt = s.b.sys.clock.Now()
+	}
+
+	var dropped *envelope
+	if s.cfg.queueLimit > 0 && !s.queue.conflates(key) && s.queue.len() >= s.cfg.queueLimit {
+		if s.cfg.queueOverflow != OverflowDropOldest {
+			return true, &message
+		}
+		evicted := s.queue.evict()
+		s.pending.Add(-1)
+		dropped = &evicted
+	}
+
+	if replaced, ok := s.queue.push(message); ok {
+		s.conflate(replaced)
+		return true, dropped
+	}
+	s.pending.Add(1)
+	return true, dropped
+}
+
+// conflate skips message replaced by newer one with the same key.
+func (s *subscription) conflate(replaced envelope) {
+	s.replaced.Add(1)
+	s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryConflated)
+	replaced.tracker.finish(s.id, DeliveryConflated)
+}
+
+// overflowed is message dropped by overflow policy of subscription.
+type overflowed struct {
+	sub *subscription
+	env envelope
+}
+
+// overflow drops message which didn't fit into queue,
+// nil message is a no-op.
+//
+// Must not be called with mut of system held,
+// as dead letter is published.
+func (s *subscription) overflow(message *envelope) {
+	if message == nil {
+		return
+	}
+	s.dropped.Add(1)
+	s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryDropped)
+	message.tracker.finish(s.id, DeliveryDropped)
+	s.deadLetter(*message, DeliveryDropped)
+}
+
+// claim marks next message from grabbed batch as delivered.
+//
+// Returns false if subscription was aborted, in which case
+// message is already counted as dropped by abort.
+func (s *subscription) claim() bool {
+	if s.aborted.Load() {
+		return false
+	}
+	// abort swaps pending to 0, so going below 0
+	// means abort has counted this message as dropped
+	return s.pending.Add(-1) >= 0
 }
 
 // queueProcessor processes queue.
 //
 // Stops on two conditions met at the same time:
-//  1. messageQueue must be empty;
-//  2. s.active == false.
+//  1. queue must be empty;
+//  2. subscription is not open.
+//
+// After those criteria met call to s.cond.Signal() will stop processor
+// and move subscription to StateClosed.
 //
-// After those criteria met call to s.cond.L.Signal() will stop processor.
+// Processor grabs messages by batches of the same priority.
+// If message of higher priority arrives while batch is processed,
+// the rest of batch is put back to queue to be served after it.
 //
 // Blocking call, should be used in goroutine.
 func (s *subscription) queueProcessor() {
 	for {
-		if !s.active.Load() {
-			if s.queueLen.Load() == 0 {
-				break
+		s.mut.Lock()
+		for (s.queue.empty() || s.paused.Load()) && s.state.Load() == StateOpen {
+			s.cond.Wait()
+		}
+		if s.queue.empty() {
+			s.mut.Unlock()
+			break
+		}
+		s.linger()
+		if s.pausing() {
+			// paused while lingering
+			s.mut.Unlock()
+			continue
+		}
+		level, batch := s.queue.next(s.cfg.grabLimit(s.handler.batch != nil))
+		s.mut.Unlock()
+
+		if s.handler.batch != nil {
+			s.processBatch(batch)
+		} else {
+			s.process(level, batch)
+		}
+	}
+	s.state.Transition(StateDraining, StateClosed)
+	s.cancel()
+	s.dropUnacked()
+	if s.handler.sink != nil {
+		close(s.handler.sink.ch)
+	}
+	close(s.processorClosed)
+}
+
+// process passes messages of batch to handler one by one.
+func (s *subscription) process(level Priority, batch []envelope) {
+	for i, message := range batch {
+		if !message.expired(s.b.sys.clock.Now()) {
+			s.limiter.wait(s.ctx, 1)
+		}
+		if s.queue.preempted(level) || s.pausing() {
+			s.mut.Lock()
+			stale := s.queue.putBack(level, batch[i:])
+			s.mut.Unlock()
+			for _, replaced := range stale {
+				s.pending.Add(-1)
+				s.conflate(replaced)
 			}
+			return
+		}
+		if !s.claim() {
+			s.dropAll(batch[i:])
+			return
+		}
+		started := s.b.sys.clock.Now()
+		if message.expired(started) {
+			s.expire(message)
+			continue
+		}
+		s.limiter.take(1)
+		s.running.Store(true)
+		s.track(message.queuedAt, started)
+		status := s.intercept(message)
+		s.track(time.Time{}, time.Time{})
+		s.running.Store(false)
+		if status == DeliveryPending {
+			// outcome is known once message is acked
+			continue
+		}
+		if status == DeliveryDone {
+			s.delivered.Add(1)
+			s.b.sys.metrics.MessageDelivered(s.b.subject, s.b.sys.clock.Now().Sub(started))
+		}
+		message.tracker.finish(s.id, status)
+	}
+}
+
+// track records message handler is called with for watchdog,
+// zero times mean handler has returned.
+func (s *subscription) track(queuedAt, started time.Time) {
+	if !s.cfg.watched {
+		return
+	}
+	if started.IsZero() {
+		s.handlerStarted.Store(0)
+		s.inflightQueuedAt.Store(0)
+		return
+	}
+	s.inflightQueuedAt.Store(queuedAt.UnixNano())
+	s.handlerStarted.Store(started.UnixNano())
+}
+
+// handle passes message to handler or channel.
+//
+// Returns DeliveryDropped if channel had no room for it.
+func (s *subscription) handle(ctx context.Context, msg Message) DeliveryStatus {
+	sink := s.handler.sink
+	if sink == nil {
+		s.handler.cb(ctx, msg.Data)
+		return DeliveryDone
+	}
+
+	sent, evicted := sink.send(ctx, msg)
+	if evicted != nil {
+		s.unawait(*evicted)
+		s.dropped.Add(1)
+		s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryDropped)
+		s.b.sys.deadLetter(DeadLetter{
+			Subject:        s.b.subject,
+			SubscriptionID: s.id,
+			Msg:            evicted.Data,
+			Reason:         DeliveryDropped,
+		})
+	}
+	if !sent {
+		return DeliveryDropped
+	}
+	return DeliveryDone
+}
+
+// intercept passes message through delivery interceptors to handler,
+// or to batch collected for batch handler. Message which hasn't
+// reached the end of chain is counted and sent to dead letters.
+//
+// In ack mode returns DeliveryPending for message passed
+// to channel or to batch.
+func (s *subscription) intercept(message envelope) DeliveryStatus {
+	msg := s.message(message)
+	if s.acking() {
+		msg = s.await(message, msg)
+	}
+
+	s.status = DeliveryFiltered
+	s.deliver(s.ctx, msg)
+
+	status := s.status
+	if msg.ack != nil {
+		if status == DeliveryDone && s.handler.batch != nil {
+			status = DeliveryPending
 		} else {
-			s.cond.L.Lock()
-			for s.queueLen.Load() == 0 && s.active.Load() {
-				s.cond.Wait()
+			status = s.awaitAck(msg.ack.id, status)
+		}
+	}
+
+	switch status {
+	case DeliveryDone, DeliveryPending:
+		return status
+	case DeliveryFiltered:
+		s.filtered.Add(1)
+	default:
+		s.dropped.Add(1)
+	}
+	s.b.sys.metrics.MessageSkipped(s.b.subject, status)
+	s.deadLetter(message, status)
+	return status
+}
+
+// pass is the end of delivery interceptors chain.
+func (s *subscription) pass(ctx context.Context, msg Message) {
+	if s.handler.batch != nil {
+		s.batched = append(s.batched, msg)
+		s.status = DeliveryDone
+		return
+	}
+	s.status = s.handle(ctx, msg)
+}
+
+// message makes Message out of queued one.
+func (s *subscription) message(message envelope) Message {
+	return Message{
+		Subject: s.b.subject,
+		Data:    message.payload,
+		Headers: message.headers,
+	}
+}
+
+// processBatch passes the whole batch to batch handler at once,
+// skipping expired messages.
+func (s *subscription) processBatch(batch []envelope) {
+	s.limiter.wait(s.ctx, len(batch))
+
+	now := s.b.sys.clock.Now()
+	delivered := make([]envelope, 0, len(batch))
+	s.batched = make([]Message, 0, len(batch))
+	for i, message := range batch {
+		if !s.claim() {
+			s.dropAll(batch[i:])
+			break
+		}
+		if message.expired(now) {
+			s.expire(message)
+			continue
+		}
+		if status := s.intercept(message); status == DeliveryDone || status == DeliveryPending {
+			delivered = append(delivered, message)
+		}
+	}
+	msgs := s.batched
+	s.batched = nil
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.limiter.take(len(msgs))
+	s.running.Store(true)
+	started := s.b.sys.clock.Now()
+	s.track(delivered[0].queuedAt, started)
+	s.handler.batch(msgs)
+	s.track(time.Time{}, time.Time{})
+	s.running.Store(false)
+	if s.acking() {
+		// outcomes are known once messages are acked
+		for _, msg := range msgs {
+			if msg.ack != nil {
+				s.awaitAck(msg.ack.id, DeliveryDone)
 			}
-			s.cond.L.Unlock()
 		}
+		return
+	}
+	s.delivered.Add(uint64(len(msgs)))
+	handlerTime := s.b.sys.clock.Now().Sub(started)
+	for _, message := range delivered {
+		s.b.sys.metrics.MessageDelivered(s.b.subject, handlerTime)
+		message.tracker.finish(s.id, DeliveryDone)
+	}
+}
+
+// linger waits for batch of batching subscription to fill up,
+// s.mut must be held and queue must not be empty.
+//
+// Returns right away if subscription is not open.
+func (s *subscription) linger() {
+	if s.handler.batch == nil || s.cfg.linger <= 0 {
+		return
+	}
 
+	deadline := time.Now().Add(s.cfg.linger)
+	timer := time.AfterFunc(s.cfg.linger, func() {
+		// taking mutex guarantees processor is either waiting
+		// or yet to check deadline, so wakeup isn't lost
 		s.mut.Lock()
-		copiedQueue := s.messageQueue
-		s.messageQueue = make([]interface{}, 0)
-		s.queueLen.Store(0)
 		s.mut.Unlock()
+		s.cond.Signal()
+	})
+	defer timer.Stop()
 
-		for _, message := range copiedQueue {
-			s.cb(message)
-		}
+	for s.queue.len() < s.cfg.batchSize && s.state.Load() == StateOpen && time.Now().Before(deadline) {
+		s.cond.Wait()
 	}
-	s.processorClosed <- struct{}{}
 }
 
 func (s *subscription) Start() {
-	go s.messageReceiver()
 	go s.queueProcessor()
 }
 
-// UnsubscribeNoLock does the same as Unsubscribe, but
-// doesn't take lock for broadcaster.subscriptions.
+// Pause stops passing messages to handler until Resume.
 //
-// Used primarily on broadcaster closing.
-func (s *subscription) UnsubscribeNoLock() {
-	s.b.UnregisterSubNoLock(s.id)
-
-	// stop receiver
-	close(s.receiver)
+// Messages keep being queued, subject to WithQueueLimit.
+// Doesn't wait for running handler. Closing subscription
+// ignores pause, so queued messages are still delivered.
+func (s *subscription) Pause() {
+	s.mut.Lock()
+	s.paused.Store(true)
+	s.mut.Unlock()
+}
 
-	// wait
-	<-s.receiverClosed
+// Resume resumes delivery of messages after Pause.
+func (s *subscription) Resume() {
+	s.mut.Lock()
+	s.paused.Store(false)
+	s.mut.Unlock()
 
-	// stop handler
-	s.active.Store(false)
 	s.cond.Signal()
+}
+
+// pausing reports whether processor should stop
+// in the middle of batch because of Pause.
+func (s *subscription) pausing() bool {
+	return s.paused.Load() && s.state.Load() == StateOpen
+}
+
+// drain moves subscription to StateDraining and wakes processor up.
+//
+// Returns false if subscription was not open.
+func (s *subscription) drain() bool {
+	s.mut.Lock()
+	first := s.state.Transition(StateOpen, StateDraining)
+	s.mut.Unlock()
+
+	if first {
+		s.cond.Signal()
+	}
+	return first
+}
 
-	// wait
+// stop drains subscription without removing it from broadcaster
+// and waits for processor to stop.
+//
+// Used on broadcaster closing, so that subscription stays
+// visible for shutdown report until it's closed.
+func (s *subscription) stop() {
+	s.drain()
 	<-s.processorClosed
 }
 
-// Unsubscribe deletes subscription from broadcaster map
-// and stops receiver and processor.
+// Unsubscribe deletes subscription from broadcaster
+// and stops processor.
+//
+// Waits for processor to drain the queue and stop.
+// Safe to call multiple times and concurrently,
+// every call returns once subscription is closed.
+//
+// Must not be called from the subscription's own handler.
 //
-// Waits for receiver and processor to be stopped.
+// Channel subscription doesn't wait for consumer:
+// messages not yet sent to channel are dropped.
 func (s *subscription) Unsubscribe() {
-	s.b.UnregisterSub(s.id)
+	if s.handler.sink != nil {
+		s.handler.sink.abandon()
+	}
+	if s.drain() {
+		s.b.UnregisterSub(s.id)
+	}
 
-	// stop receiver
-	close(s.receiver)
+	<-s.processorClosed
+}
+
+// abort drops queued messages and cancels handler context
+// without waiting for running handler.
+//
+// Processor exits as soon as running handler returns.
+func (s *subscription) abort() SubscriptionReport {
+	s.mut.Lock()
+	s.state.Transition(StateOpen, StateDraining)
+	dropped := s.queue.clear()
+	first := s.aborted.CompareAndSwap(false, true)
+	s.mut.Unlock()
 
-	// wait
-	<-s.receiverClosed
+	s.dropAll(dropped)
 
-	// stop handler
-	s.active.Store(false)
+	s.cancel()
 	s.cond.Signal()
 
-	// wait
-	<-s.processorClosed
+	report := SubscriptionReport{ID: s.id, HandlerRunning: s.running.Load()}
+	if first {
+		report.Undelivered = max(s.pending.Swap(0), 0)
+	}
+	return report
+}
+
+// expire skips message with run out TTL
+// and sends it to dead letters.
+func (s *subscription) expire(message envelope) {
+	s.expired.Add(1)
+	s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryExpired)
+	message.tracker.finish(s.id, DeliveryExpired)
+	s.deadLetter(message, DeliveryExpired)
+}
+
+// deadLetter sends message skipped for reason to dead letters.
+func (s *subscription) deadLetter(message envelope, reason DeliveryStatus) {
+	s.b.sys.deadLetter(DeadLetter{
+		Subject:        s.b.subject,
+		SubscriptionID: s.id,
+		Msg:            message.payload,
+		Reason:         reason,
+		PublishedAt:    message.publishedAt,
+		ExpiresAt:      message.expiresAt,
+	})
+}
+
+// dropAll notifies publishers waiting for messages
+// that those will never reach handler.
+func (s *subscription) dropAll(messages []envelope) {
+	for _, message := range messages {
+		s.b.sys.metrics.MessageSkipped(s.b.subject, DeliveryDropped)
+		message.tracker.finish(s.id, DeliveryDropped)
+	}
 }
 
-func newSubscription(id int64, cb MessageHandler, b *broadcaster) *subscription {
-	sub := &subscription{
-		id:       id,
-		receiver: make(chan interface{}, 1),
-		cb:       cb,
-		b:        b,
+// report describes what subscription hasn't done yet.
+func (s *subscription) report() SubscriptionReport {
+	return SubscriptionReport{
+		ID:             s.id,
+		Undelivered:    max(s.pending.Load(), 0),
+		HandlerRunning: s.running.Load(),
+	}
+}
+
+func newSubscription(id int64, h handler, b *broadcaster, cfg subscribeConfig) *subscription {
+	mut := &sync.Mutex{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), subscriptionIDKey{}, id))
+	s := &subscription{
+		id:  id,
+		b:   b,
+		cfg: cfg,
+
+		handler: h,
+		limiter: newTokenBucket(cfg.rate, cfg.burst),
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		mut:   mut,
+		cond:  sync.NewCond(mut),
+		state: &lifecycle{},
+		queue: newMessageQueue(cfg.conflationKey != nil, cfg.priorityWeights),
+
+		unacked: make(map[uint64]*unacked),
+
+		pending: &atomic.Int64{},
+		running: &atomic.Bool{},
+		aborted: &atomic.Bool{},
+		paused:  &atomic.Bool{},
+
+		inflightQueuedAt: &atomic.Int64{},
+		handlerStarted:   &atomic.Int64{},
+		slow:             &atomic.Bool{},
+		err:              &atomic.Pointer[error]{},
 
-		active: &atomic.Bool{},
+		delivered: &atomic.Uint64{},
+		expired:   &atomic.Uint64{},
+		replaced:  &atomic.Uint64{},
+		dropped:   &atomic.Uint64{},
+		filtered:  &atomic.Uint64{},
 
-		mut:          &sync.Mutex{},
-		cond:         sync.NewCond(&sync.Mutex{}),
-		queueLen:     &atomic.Int64{},
-		messageQueue: make([]interface{}, 0),
+		redelivered: &atomic.Uint64{},
+		notAcked:    &atomic.Uint64{},
 
-		receiverClosed:  make(chan struct{}),
 		processorClosed: make(chan struct{}),
 	}
-	sub.active.Store(true)
-	return sub
+	s.deliver = chainDelivery(cfg.interceptors, s.pass)
+	return s
 }
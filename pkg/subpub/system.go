@@ -0,0 +1,61 @@
+package subpub
+
+import "time"
+
+// Clock tells time for timestamps of system: TTL of messages,
+// dedup windows, watchdog and events. Timers of scheduler,
+// rate limits and batching always use real time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Metrics receives counters of messages as they change.
+//
+// Methods are called by publishers and processors concurrently,
+// so they must be safe for concurrent use and must not block.
+type Metrics interface {
+	// MessagePublished is called for every message
+	// accepted on subject, even if it has no subscribers.
+	MessagePublished(subject string)
+
+	// MessageDelivered is called once handler has finished with
+	// message, for batch handler it's time of the whole batch.
+	MessageDelivered(subject string, handlerTime time.Duration)
+
+	// MessageSkipped is called for message which won't
+	// reach handler of subscription for given reason.
+	MessageSkipped(subject string, reason DeliveryStatus)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) MessagePublished(string) {}
+
+func (noopMetrics) MessageDelivered(string, time.Duration) {}
+
+func (noopMetrics) MessageSkipped(string, DeliveryStatus) {}
+
+// system is what broadcasters and subscriptions
+// need from subpub system they belong to.
+type system struct {
+	// deadLetter is called for every message skipped by subscription.
+	deadLetter func(DeadLetter)
+
+	// emit is called for lifecycle events of topic,
+	// never with mutex of broadcaster held.
+	emit func(SysEvent)
+
+	// nextID returns ID of new subscription. IDs are unique
+	// system-wide and define lock order of subscriptions
+	// in transactions.
+	nextID func() int64
+
+	clock   Clock
+	metrics Metrics
+}
@@ -0,0 +1,93 @@
+package subpub
+
+import (
+	"context"
+	"maps"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Kry0z1/subpub/pkg/subpub"
+
+// SubscriptionIDKey is attribute of delivery spans
+// holding ID of subscription.
+const SubscriptionIDKey = attribute.Key("subpub.subscription.id")
+
+// traceContext propagates trace context
+// in headers of messages as W3C traceparent.
+var traceContext = propagation.TraceContext{}
+
+// subscriptionIDKey is key of subscription ID in context of subscription.
+type subscriptionIDKey struct{}
+
+// tracing creates spans for publishing and delivery
+// of messages, see WithTracing.
+type tracing struct {
+	tracer trace.Tracer
+}
+
+func newTracing(provider trace.TracerProvider) *tracing {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &tracing{tracer: provider.Tracer(tracerName)}
+}
+
+// publish is PublishInterceptor creating producer span
+// and injecting its context into headers of message.
+func (t *tracing) publish(ctx context.Context, subject string, msg Message, next PublishFunc) error {
+	ctx, span := t.tracer.Start(ctx, "send "+subject,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("subpub"),
+			semconv.MessagingDestinationName(subject),
+			semconv.MessagingOperationTypeSend,
+		),
+	)
+	defer span.End()
+
+	msg.Headers = inject(ctx, msg.Headers)
+	err := next(ctx, subject, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// deliver is DeliveryInterceptor creating consumer span, child of
+// one found in headers of message. Its context is passed to handler
+// and injected into headers, so it reaches channel and batch
+// subscribers too.
+func (t *tracing) deliver(ctx context.Context, msg Message, next DeliveryFunc) {
+	parent := traceContext.Extract(ctx, propagation.MapCarrier(msg.Headers))
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("subpub"),
+		semconv.MessagingDestinationName(msg.Subject),
+		semconv.MessagingOperationTypeProcess,
+	}
+	if id, ok := ctx.Value(subscriptionIDKey{}).(int64); ok {
+		attrs = append(attrs, SubscriptionIDKey.Int64(id))
+	}
+	ctx, span := t.tracer.Start(parent, "process "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	msg.Headers = inject(ctx, msg.Headers)
+	next(ctx, msg)
+}
+
+// inject returns copy of headers with trace context of ctx.
+func inject(ctx context.Context, headers map[string]string) map[string]string {
+	injected := make(map[string]string, len(headers)+1)
+	maps.Copy(injected, headers)
+	traceContext.Inject(ctx, propagation.MapCarrier(injected))
+	return injected
+}
@@ -0,0 +1,204 @@
+package subpub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+type txMessage struct {
+	subject string
+	msg     interface{}
+	cfg     publishConfig
+}
+
+type tx struct {
+	s *subpub
+
+	mut    *sync.Mutex
+	staged []txMessage
+	done   bool
+}
+
+// Publish stages msg to be published to subject on commit,
+// publish interceptors are run right away.
+func (t *tx) Publish(subject string, msg interface{}, opts ...PublishOption) error {
+	return t.s.intercept(context.Background(), subject, msg, newPublishConfig(opts), t.stage, t.stage)
+}
+
+func (t *tx) stage(subject string, msg interface{}, cfg publishConfig) error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.done {
+		return ErrTxDone
+	}
+	t.staged = append(t.staged, txMessage{subject: subject, msg: msg, cfg: cfg})
+	return nil
+}
+
+// Rollback discards staged messages.
+// Safe to call after Commit, then it does nothing.
+func (t *tx) Rollback() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.done = true
+	t.staged = nil
+}
+
+// Commit publishes all staged messages at once.
+//
+// Either every message is put into queues of all current
+// subscribers of its subject, or none is. Queues of all affected
+// subscriptions are locked together (in order of subscription ID)
+// while messages are added, so no handler can get any message of
+// transaction before every other subscriber has all of its own.
+//
+// Messages to partitioned subjects are appended to their partitions
+// at the same time. Messages to the same subject keep staging order.
+// Messages with idempotency key already seen are skipped,
+// the same way Publish does.
+//
+// Errors are the same as for Publish, in which case
+// nothing is published. Either way transaction is done.
+func (t *tx) Commit() error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	staged := t.staged
+	t.staged = nil
+
+	return t.s.commit(staged)
+}
+
+// txDelivery is one message for one subscription.
+type txDelivery struct {
+	sub *subscription
+	env envelope
+	key string
+}
+
+func (s *subpub) commit(staged []txMessage) error {
+	overflowed, err := s.commitLocked(staged)
+
+	// dead letters are published only after s.mut is released,
+	// otherwise they would wait for Close waiting for committer
+	for _, d := range overflowed {
+		d.sub.overflow(&d.env)
+	}
+	return err
+}
+
+// commitLocked does the same as commit under s.mut,
+// returning messages dropped by overflow.
+func (s *subpub) commitLocked(staged []txMessage) ([]overflowed, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.state.Load() != StateOpen {
+		return nil, s.stateErr("commit", "")
+	}
+
+	var recorded []txMessage
+	forget := func() {
+		for _, m := range recorded {
+			s.dedup.Forget(m.subject, m.cfg.idempotencyKey)
+		}
+	}
+
+	var (
+		deliveries   []txDelivery
+		broadcasters []*broadcaster
+		appended     []txMessage
+	)
+	for _, m := range staged {
+		if m.cfg.idempotencyKey != "" {
+			if !s.dedup.Record(m.subject, m.cfg.idempotencyKey) {
+				continue
+			}
+			recorded = append(recorded, m)
+		}
+		if _, ok := s.topics[m.subject]; ok {
+			appended = append(appended, m)
+		}
+
+		bAny, ok := s.broadcasters.Load(m.subject)
+		if !ok {
+			continue
+		}
+		b := bAny.(*broadcaster)
+		if b.state.Load() != StateOpen {
+			forget()
+			return nil, &StateError{Op: "commit", Subject: m.subject, State: b.state.Load(), Err: ErrTopicClosed}
+		}
+
+		broadcasters = append(broadcasters, b)
+		env := b.envelope(m.msg, m.cfg)
+		for _, sub := range *b.subscriptions.Load() {
+			deliveries = append(deliveries, txDelivery{sub: sub, env: env, key: sub.conflationKey(env)})
+		}
+	}
+
+	subs := make([]*subscription, 0, len(deliveries))
+	seen := make(map[*subscription]struct{}, len(deliveries))
+	for _, d := range deliveries {
+		if _, ok := seen[d.sub]; !ok {
+			seen[d.sub] = struct{}{}
+			subs = append(subs, d.sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].id < subs[j].id })
+
+	// partitioned subjects are locked in order of subject
+	var topics []*partitionedTopic
+	for _, m := range appended {
+		if t := s.topics[m.subject]; !slices.Contains(topics, t) {
+			topics = append(topics, t)
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].subject < topics[j].subject })
+
+	for _, sub := range subs {
+		sub.mut.Lock()
+	}
+	for _, t := range topics {
+		t.mut.Lock()
+	}
+	var dropped []overflowed
+	for _, d := range deliveries {
+		if _, env := d.sub.enqueueLocked(d.env, d.key); env != nil {
+			dropped = append(dropped, overflowed{sub: d.sub, env: *env})
+		}
+	}
+	for _, m := range appended {
+		// system is open, so topics are too
+		s.topics[m.subject].appendLocked(m.msg, m.cfg)
+	}
+	for _, t := range topics {
+		t.mut.Unlock()
+		t.wake()
+	}
+	for _, sub := range subs {
+		sub.mut.Unlock()
+		sub.cond.Signal()
+	}
+	for _, b := range broadcasters {
+		b.published.Add(1)
+		b.sys.metrics.MessagePublished(b.subject)
+	}
+	return dropped, nil
+}
+
+// Begin starts new transaction.
+func (s *subpub) Begin() Tx {
+	return &tx{s: s, mut: &sync.Mutex{}}
+}
@@ -0,0 +1,209 @@
+package subpub
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// SlowConsumerSubject receives SlowConsumer events
+	// if watchdog is enabled with WithWatchdog.
+	SlowConsumerSubject = "$SYS.slow_consumer"
+
+	// DefaultWatchdogInterval is how often watchdog
+	// checks subscriptions unless configured otherwise.
+	DefaultWatchdogInterval = time.Second
+)
+
+var ErrSlowConsumer = errors.New("evicted as slow consumer")
+
+// SlowConsumerPolicy sets thresholds for watchdog,
+// zero threshold is not checked.
+type SlowConsumerPolicy struct {
+	// MaxQueued is max number of messages waiting for handler.
+	MaxQueued int64
+	// MaxQueueAge is max time the oldest message waits for handler.
+	MaxQueueAge time.Duration
+	// MaxHandlerTime is max time handler may spend on one message.
+	MaxHandlerTime time.Duration
+
+	// Evict makes watchdog abort slow subscription: its queue
+	// is dropped, handler context cancelled and subscription
+	// removed, while Subscription.Err tells why.
+	Evict bool
+
+	// Interval is time between checks,
+	// DefaultWatchdogInterval if zero.
+	Interval time.Duration
+}
+
+// SlowConsumer is published to SlowConsumerSubject once subscription
+// exceeds threshold of SlowConsumerPolicy. It's published again
+// only after subscription has caught up.
+type SlowConsumer struct {
+	Subject        string
+	SubscriptionID int64
+
+	// Reason describes exceeded threshold.
+	Reason string
+
+	Queued      int64
+	QueueAge    time.Duration
+	HandlerTime time.Duration
+
+	// Evicted is set if subscription was evicted.
+	Evicted bool
+}
+
+// watchdog periodically checks all subscriptions
+// against SlowConsumerPolicy.
+type watchdog struct {
+	policy SlowConsumerPolicy
+	check  func()
+
+	stop *sync.Once
+	quit chan struct{}
+	done chan struct{}
+}
+
+// run checks subscriptions every interval until Stop.
+//
+// Blocking call, should be used in goroutine.
+func (w *watchdog) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// Stop stops watchdog goroutine and waits for it to exit.
+// Nil watchdog is a no-op.
+func (w *watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	w.stop.Do(func() {
+		close(w.quit)
+	})
+	<-w.done
+}
+
+// violation returns description of exceeded threshold,
+// empty string if there is none.
+func (p SlowConsumerPolicy) violation(queued int64, age, handling time.Duration) string {
+	switch {
+	case p.MaxQueued > 0 && queued > p.MaxQueued:
+		return fmt.Sprintf("%d messages queued, max is %d", queued, p.MaxQueued)
+	case p.MaxQueueAge > 0 && age > p.MaxQueueAge:
+		return fmt.Sprintf("oldest message queued for %s, max is %s", age, p.MaxQueueAge)
+	case p.MaxHandlerTime > 0 && handling > p.MaxHandlerTime:
+		return fmt.Sprintf("handler runs for %s, max is %s", handling, p.MaxHandlerTime)
+	default:
+		return ""
+	}
+}
+
+// checkSlowConsumers runs one pass of watchdog.
+func (s *subpub) checkSlowConsumers() {
+	policy := s.watchdog.policy
+	s.broadcasters.Range(func(key, value any) bool {
+		for _, sub := range *value.(*broadcaster).subscriptions.Load() {
+			queued, age, handling := sub.health()
+			reason := policy.violation(queued, age, handling)
+			if reason == "" {
+				sub.slow.Store(false)
+				continue
+			}
+			if sub.slow.Swap(true) {
+				continue
+			}
+
+			s.cfg.logger.Warn("subpub: slow consumer",
+				slog.String("subject", sub.b.subject),
+				slog.Int64("subscription", sub.id),
+				slog.String("reason", reason),
+				slog.Bool("evicted", policy.Evict),
+			)
+			if policy.Evict {
+				sub.evict(fmt.Errorf("%w: %s", ErrSlowConsumer, reason))
+			}
+			s.reportErr(s.send(SlowConsumerSubject, SlowConsumer{
+				Subject:        sub.b.subject,
+				SubscriptionID: sub.id,
+				Reason:         reason,
+				Queued:         queued,
+				QueueAge:       age,
+				HandlerTime:    handling,
+				Evicted:        policy.Evict,
+			}, publishConfig{}))
+		}
+		return true
+	})
+}
+
+func newWatchdog(policy SlowConsumerPolicy, check func()) *watchdog {
+	if policy.Interval <= 0 {
+		policy.Interval = DefaultWatchdogInterval
+	}
+	return &watchdog{
+		policy: policy,
+		check:  check,
+		stop:   &sync.Once{},
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// health returns number of queued messages, how long the oldest
+// of them waits and for how long handler is running.
+func (s *subscription) health() (int64, time.Duration, time.Duration) {
+	now := s.b.sys.clock.Now()
+
+	oldest := s.inflightQueuedAt.Load()
+	s.mut.Lock()
+	if front := s.queue.oldest(); !front.IsZero() && (oldest == 0 || front.UnixNano() < oldest) {
+		oldest = front.UnixNano()
+	}
+	s.mut.Unlock()
+
+	var age, handling time.Duration
+	if oldest != 0 {
+		age = now.Sub(time.Unix(0, oldest))
+	}
+	if started := s.handlerStarted.Load(); started != 0 {
+		handling = now.Sub(time.Unix(0, started))
+	}
+	return max(s.pending.Load(), 0), age, handling
+}
+
+// evict aborts subscription and removes it from broadcaster.
+//
+// err is returned by Err afterwards.
+func (s *subscription) evict(err error) {
+	if !s.err.CompareAndSwap(nil, &err) {
+		return
+	}
+	s.abort()
+	s.b.UnregisterSub(s.id)
+}
+
+// Err returns error subscription was closed by system with,
+// nil if it's open or was closed by Unsubscribe or Close.
+func (s *subscription) Err() error {
+	if err := s.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
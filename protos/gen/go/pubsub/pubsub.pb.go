@@ -9,7 +9,8 @@ package pubsubv1
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
-	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -23,8 +24,18 @@ const (
 )
 
 type SubscribeRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Сколько сообщений может ждать доставки, при переполнении отбрасываются самые старые (0 — без ограничения)
+	MaxQueued uint32 `protobuf:"varint,2,opt,name=max_queued,json=maxQueued,proto3" json:"max_queued,omitempty"`
+	// Вступить в группу потребителей секционированного ключа: каждая секция
+	// читается по порядку одним участником группы (max_queued не используется)
+	Group string `protobuf:"bytes,3,opt,name=group,proto3" json:"group,omitempty"`
+	// Каждое сообщение нужно подтвердить кадром ack, иначе по истечении ack_wait
+	// оно доставляется снова (только для SubscribeStream, не вместе с group)
+	AckWait *durationpb.Duration `protobuf:"bytes,4,opt,name=ack_wait,json=ackWait,proto3" json:"ack_wait,omitempty"`
+	// Сколько раз доставлять неподтверждённое сообщение, прежде чем отбросить его (0 — без ограничения)
+	MaxDeliver    uint32 `protobuf:"varint,5,opt,name=max_deliver,json=maxDeliver,proto3" json:"max_deliver,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -66,17 +77,290 @@ func (x *SubscribeRequest) GetKey() string {
 	return ""
 }
 
-type PublishRequest struct {
+func (x *SubscribeRequest) GetMaxQueued() uint32 {
+	if x != nil {
+		return x.MaxQueued
+	}
+	return 0
+}
+
+func (x *SubscribeRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetAckWait() *durationpb.Duration {
+	if x != nil {
+		return x.AckWait
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetMaxDeliver() uint32 {
+	if x != nil {
+		return x.MaxDeliver
+	}
+	return 0
+}
+
+type SubscribeFrame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Frame:
+	//
+	//	*SubscribeFrame_Subscribe
+	//	*SubscribeFrame_Pause
+	//	*SubscribeFrame_Resume
+	//	*SubscribeFrame_Ack
+	Frame         isSubscribeFrame_Frame `protobuf_oneof:"frame"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeFrame) Reset() {
+	*x = SubscribeFrame{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeFrame) ProtoMessage() {}
+
+func (x *SubscribeFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeFrame.ProtoReflect.Descriptor instead.
+func (*SubscribeFrame) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubscribeFrame) GetFrame() isSubscribeFrame_Frame {
+	if x != nil {
+		return x.Frame
+	}
+	return nil
+}
+
+func (x *SubscribeFrame) GetSubscribe() *SubscribeRequest {
+	if x != nil {
+		if x, ok := x.Frame.(*SubscribeFrame_Subscribe); ok {
+			return x.Subscribe
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeFrame) GetPause() *PauseFrame {
+	if x != nil {
+		if x, ok := x.Frame.(*SubscribeFrame_Pause); ok {
+			return x.Pause
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeFrame) GetResume() *ResumeFrame {
+	if x != nil {
+		if x, ok := x.Frame.(*SubscribeFrame_Resume); ok {
+			return x.Resume
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeFrame) GetAck() *AckFrame {
+	if x != nil {
+		if x, ok := x.Frame.(*SubscribeFrame_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+type isSubscribeFrame_Frame interface {
+	isSubscribeFrame_Frame()
+}
+
+type SubscribeFrame_Subscribe struct {
+	// Только первым кадром
+	Subscribe *SubscribeRequest `protobuf:"bytes,1,opt,name=subscribe,proto3,oneof"`
+}
+
+type SubscribeFrame_Pause struct {
+	// Приостановить доставку, сообщения копятся на сервере
+	Pause *PauseFrame `protobuf:"bytes,2,opt,name=pause,proto3,oneof"`
+}
+
+type SubscribeFrame_Resume struct {
+	// Возобновить доставку, начиная с накопленных сообщений
+	Resume *ResumeFrame `protobuf:"bytes,3,opt,name=resume,proto3,oneof"`
+}
+
+type SubscribeFrame_Ack struct {
+	// Подтвердить обработку сообщений
+	Ack *AckFrame `protobuf:"bytes,4,opt,name=ack,proto3,oneof"`
+}
+
+func (*SubscribeFrame_Subscribe) isSubscribeFrame_Frame() {}
+
+func (*SubscribeFrame_Pause) isSubscribeFrame_Frame() {}
+
+func (*SubscribeFrame_Resume) isSubscribeFrame_Frame() {}
+
+func (*SubscribeFrame_Ack) isSubscribeFrame_Frame() {}
+
+type PauseFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseFrame) Reset() {
+	*x = PauseFrame{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseFrame) ProtoMessage() {}
+
+func (x *PauseFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseFrame.ProtoReflect.Descriptor instead.
+func (*PauseFrame) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{2}
+}
+
+type ResumeFrame struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Data          string                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeFrame) Reset() {
+	*x = ResumeFrame{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeFrame) ProtoMessage() {}
+
+func (x *ResumeFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeFrame.ProtoReflect.Descriptor instead.
+func (*ResumeFrame) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{3}
+}
+
+type AckFrame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ack_id подтверждаемых событий, устаревшие и неизвестные пропускаются
+	AckIds        []uint64 `protobuf:"varint,1,rep,packed,name=ack_ids,json=ackIds,proto3" json:"ack_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckFrame) Reset() {
+	*x = AckFrame{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckFrame) ProtoMessage() {}
+
+func (x *AckFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckFrame.ProtoReflect.Descriptor instead.
+func (*AckFrame) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AckFrame) GetAckIds() []uint64 {
+	if x != nil {
+		return x.AckIds
+	}
+	return nil
+}
+
+type PublishRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data  string                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Ждать, пока все текущие подписчики обработают сообщение
+	WaitForDelivery bool `protobuf:"varint,3,opt,name=wait_for_delivery,json=waitForDelivery,proto3" json:"wait_for_delivery,omitempty"`
+	// Опубликовать не раньше этого момента (нельзя вместе с wait_for_delivery)
+	DeliverAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=deliver_at,json=deliverAt,proto3" json:"deliver_at,omitempty"`
+	// Время жизни сообщения в очереди подписчика, устаревшие сообщения пропускаются
+	Ttl *durationpb.Duration `protobuf:"bytes,5,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// Повтор с тем же ключом в пределах окна дедупликации подтверждается, но не доставляется
+	IdempotencyKey string `protobuf:"bytes,6,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	// Сообщения с одним ключом секционирования попадают в одну секцию
+	PartitionKey  string `protobuf:"bytes,7,opt,name=partition_key,json=partitionKey,proto3" json:"partition_key,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PublishRequest) Reset() {
 	*x = PublishRequest{}
-	mi := &file_pubsub_pubsub_proto_msgTypes[1]
+	mi := &file_pubsub_pubsub_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -88,7 +372,7 @@ func (x *PublishRequest) String() string {
 func (*PublishRequest) ProtoMessage() {}
 
 func (x *PublishRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pubsub_pubsub_proto_msgTypes[1]
+	mi := &file_pubsub_pubsub_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -101,7 +385,7 @@ func (x *PublishRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PublishRequest.ProtoReflect.Descriptor instead.
 func (*PublishRequest) Descriptor() ([]byte, []int) {
-	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{1}
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *PublishRequest) GetKey() string {
@@ -118,16 +402,113 @@ func (x *PublishRequest) GetData() string {
 	return ""
 }
 
+func (x *PublishRequest) GetWaitForDelivery() bool {
+	if x != nil {
+		return x.WaitForDelivery
+	}
+	return false
+}
+
+func (x *PublishRequest) GetDeliverAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeliverAt
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetTtl() *durationpb.Duration {
+	if x != nil {
+		return x.Ttl
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetPartitionKey() string {
+	if x != nil {
+		return x.PartitionKey
+	}
+	return ""
+}
+
+type PublishResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Число подписчиков, обработавших сообщение (только при wait_for_delivery)
+	Delivered uint32 `protobuf:"varint,1,opt,name=delivered,proto3" json:"delivered,omitempty"`
+	// Идентификатор отложенного сообщения (только при deliver_at)
+	ScheduledId   uint64 `protobuf:"varint,2,opt,name=scheduled_id,json=scheduledId,proto3" json:"scheduled_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishResponse) Reset() {
+	*x = PublishResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishResponse) ProtoMessage() {}
+
+func (x *PublishResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishResponse.ProtoReflect.Descriptor instead.
+func (*PublishResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PublishResponse) GetDelivered() uint32 {
+	if x != nil {
+		return x.Delivered
+	}
+	return 0
+}
+
+func (x *PublishResponse) GetScheduledId() uint64 {
+	if x != nil {
+		return x.ScheduledId
+	}
+	return 0
+}
+
 type Event struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          string                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Data  string                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// W3C trace context доставки (traceparent, tracestate), если на сервере включена трассировка
+	TraceContext map[string]string `protobuf:"bytes,2,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Секция и смещение сообщения (только для подписки группы)
+	Partition uint32 `protobuf:"varint,3,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offset    int64  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`
+	// Идентификатор для подтверждения и номер попытки доставки (только при ack_wait)
+	AckId         uint64 `protobuf:"varint,5,opt,name=ack_id,json=ackId,proto3" json:"ack_id,omitempty"`
+	Delivery      uint32 `protobuf:"varint,6,opt,name=delivery,proto3" json:"delivery,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Event) Reset() {
 	*x = Event{}
-	mi := &file_pubsub_pubsub_proto_msgTypes[2]
+	mi := &file_pubsub_pubsub_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -139,7 +520,7 @@ func (x *Event) String() string {
 func (*Event) ProtoMessage() {}
 
 func (x *Event) ProtoReflect() protoreflect.Message {
-	mi := &file_pubsub_pubsub_proto_msgTypes[2]
+	mi := &file_pubsub_pubsub_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -152,7 +533,7 @@ func (x *Event) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Event.ProtoReflect.Descriptor instead.
 func (*Event) Descriptor() ([]byte, []int) {
-	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{2}
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Event) GetData() string {
@@ -162,67 +543,1777 @@ func (x *Event) GetData() string {
 	return ""
 }
 
-var File_pubsub_pubsub_proto protoreflect.FileDescriptor
-
-const file_pubsub_pubsub_proto_rawDesc = "" +
-	"\n" +
-	"\x13pubsub/pubsub.proto\x1a\x1bgoogle/protobuf/empty.proto\"$\n" +
-	"\x10SubscribeRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"6\n" +
-	"\x0ePublishRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
-	"\x04data\x18\x02 \x01(\tR\x04data\"\x1b\n" +
-	"\x05Event\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\tR\x04data2f\n" +
-	"\x06PubSub\x12(\n" +
-	"\tSubscribe\x12\x11.SubscribeRequest\x1a\x06.Event0\x01\x122\n" +
-	"\aPublish\x12\x0f.PublishRequest\x1a\x16.google.protobuf.EmptyB\x1bZ\x19Kry0z1.pubsub.v1;pubsubv1b\x06proto3"
-
-var (
-	file_pubsub_pubsub_proto_rawDescOnce sync.Once
-	file_pubsub_pubsub_proto_rawDescData []byte
-)
-
-func file_pubsub_pubsub_proto_rawDescGZIP() []byte {
-	file_pubsub_pubsub_proto_rawDescOnce.Do(func() {
-		file_pubsub_pubsub_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pubsub_pubsub_proto_rawDesc), len(file_pubsub_pubsub_proto_rawDesc)))
-	})
-	return file_pubsub_pubsub_proto_rawDescData
+func (x *Event) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
 }
 
-var file_pubsub_pubsub_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
-var file_pubsub_pubsub_proto_goTypes = []any{
-	(*SubscribeRequest)(nil), // 0: SubscribeRequest
-	(*PublishRequest)(nil),   // 1: PublishRequest
-	(*Event)(nil),            // 2: Event
-	(*emptypb.Empty)(nil),    // 3: google.protobuf.Empty
+func (x *Event) GetPartition() uint32 {
+	if x != nil {
+		return x.Partition
+	}
+	return 0
 }
-var file_pubsub_pubsub_proto_depIdxs = []int32{
-	0, // 0: PubSub.Subscribe:input_type -> SubscribeRequest
-	1, // 1: PubSub.Publish:input_type -> PublishRequest
-	2, // 2: PubSub.Subscribe:output_type -> Event
-	3, // 3: PubSub.Publish:output_type -> google.protobuf.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+
+func (x *Event) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
 }
 
-func init() { file_pubsub_pubsub_proto_init() }
+func (x *Event) GetAckId() uint64 {
+	if x != nil {
+		return x.AckId
+	}
+	return 0
+}
+
+func (x *Event) GetDelivery() uint32 {
+	if x != nil {
+		return x.Delivery
+	}
+	return 0
+}
+
+type TxMessage struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Key            string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data           string                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Ttl            *durationpb.Duration   `protobuf:"bytes,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	IdempotencyKey string                 `protobuf:"bytes,4,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	PartitionKey   string                 `protobuf:"bytes,5,opt,name=partition_key,json=partitionKey,proto3" json:"partition_key,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *TxMessage) Reset() {
+	*x = TxMessage{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TxMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TxMessage) ProtoMessage() {}
+
+func (x *TxMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TxMessage.ProtoReflect.Descriptor instead.
+func (*TxMessage) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TxMessage) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *TxMessage) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *TxMessage) GetTtl() *durationpb.Duration {
+	if x != nil {
+		return x.Ttl
+	}
+	return nil
+}
+
+func (x *TxMessage) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *TxMessage) GetPartitionKey() string {
+	if x != nil {
+		return x.PartitionKey
+	}
+	return ""
+}
+
+type PublishTxRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*TxMessage           `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishTxRequest) Reset() {
+	*x = PublishTxRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishTxRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishTxRequest) ProtoMessage() {}
+
+func (x *PublishTxRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishTxRequest.ProtoReflect.Descriptor instead.
+func (*PublishTxRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *PublishTxRequest) GetMessages() []*TxMessage {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type PublishTxResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishTxResponse) Reset() {
+	*x = PublishTxResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishTxResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishTxResponse) ProtoMessage() {}
+
+func (x *PublishTxResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishTxResponse.ProtoReflect.Descriptor instead.
+func (*PublishTxResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{10}
+}
+
+type Schema struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Токены через точку: * — любой один токен, > в конце — один и более токенов
+	Pattern string `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	// Types that are valid to be assigned to Definition:
+	//
+	//	*Schema_JsonSchema
+	//	*Schema_Proto
+	Definition    isSchema_Definition `protobuf_oneof:"definition"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Schema) Reset() {
+	*x = Schema{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Schema) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Schema) ProtoMessage() {}
+
+func (x *Schema) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Schema.ProtoReflect.Descriptor instead.
+func (*Schema) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Schema) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *Schema) GetDefinition() isSchema_Definition {
+	if x != nil {
+		return x.Definition
+	}
+	return nil
+}
+
+func (x *Schema) GetJsonSchema() string {
+	if x != nil {
+		if x, ok := x.Definition.(*Schema_JsonSchema); ok {
+			return x.JsonSchema
+		}
+	}
+	return ""
+}
+
+func (x *Schema) GetProto() *ProtoSchema {
+	if x != nil {
+		if x, ok := x.Definition.(*Schema_Proto); ok {
+			return x.Proto
+		}
+	}
+	return nil
+}
+
+type isSchema_Definition interface {
+	isSchema_Definition()
+}
+
+type Schema_JsonSchema struct {
+	// JSON Schema, данные публикуются в JSON
+	JsonSchema string `protobuf:"bytes,2,opt,name=json_schema,json=jsonSchema,proto3,oneof"`
+}
+
+type Schema_Proto struct {
+	Proto *ProtoSchema `protobuf:"bytes,3,opt,name=proto,proto3,oneof"`
+}
+
+func (*Schema_JsonSchema) isSchema_Definition() {}
+
+func (*Schema_Proto) isSchema_Definition() {}
+
+type ProtoSchema struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// google.protobuf.FileDescriptorSet сообщения со всеми зависимостями
+	DescriptorSet []byte `protobuf:"bytes,1,opt,name=descriptor_set,json=descriptorSet,proto3" json:"descriptor_set,omitempty"`
+	// Полное имя сообщения, данные публикуются в protojson
+	Message       string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProtoSchema) Reset() {
+	*x = ProtoSchema{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProtoSchema) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProtoSchema) ProtoMessage() {}
+
+func (x *ProtoSchema) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProtoSchema.ProtoReflect.Descriptor instead.
+func (*ProtoSchema) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ProtoSchema) GetDescriptorSet() []byte {
+	if x != nil {
+		return x.DescriptorSet
+	}
+	return nil
+}
+
+func (x *ProtoSchema) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type RegisterSchemaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Schema        *Schema                `protobuf:"bytes,1,opt,name=schema,proto3" json:"schema,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterSchemaRequest) Reset() {
+	*x = RegisterSchemaRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterSchemaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterSchemaRequest) ProtoMessage() {}
+
+func (x *RegisterSchemaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterSchemaRequest.ProtoReflect.Descriptor instead.
+func (*RegisterSchemaRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RegisterSchemaRequest) GetSchema() *Schema {
+	if x != nil {
+		return x.Schema
+	}
+	return nil
+}
+
+type RegisterSchemaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterSchemaResponse) Reset() {
+	*x = RegisterSchemaResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterSchemaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterSchemaResponse) ProtoMessage() {}
+
+func (x *RegisterSchemaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterSchemaResponse.ProtoReflect.Descriptor instead.
+func (*RegisterSchemaResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{14}
+}
+
+type DeleteSchemaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pattern       string                 `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteSchemaRequest) Reset() {
+	*x = DeleteSchemaRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteSchemaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteSchemaRequest) ProtoMessage() {}
+
+func (x *DeleteSchemaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteSchemaRequest.ProtoReflect.Descriptor instead.
+func (*DeleteSchemaRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *DeleteSchemaRequest) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+type DeleteSchemaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteSchemaResponse) Reset() {
+	*x = DeleteSchemaResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteSchemaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteSchemaResponse) ProtoMessage() {}
+
+func (x *DeleteSchemaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteSchemaResponse.ProtoReflect.Descriptor instead.
+func (*DeleteSchemaResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{16}
+}
+
+type ListSchemasRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSchemasRequest) Reset() {
+	*x = ListSchemasRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSchemasRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSchemasRequest) ProtoMessage() {}
+
+func (x *ListSchemasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSchemasRequest.ProtoReflect.Descriptor instead.
+func (*ListSchemasRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{17}
+}
+
+type ListSchemasResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Schemas       []*Schema              `protobuf:"bytes,1,rep,name=schemas,proto3" json:"schemas,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSchemasResponse) Reset() {
+	*x = ListSchemasResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSchemasResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSchemasResponse) ProtoMessage() {}
+
+func (x *ListSchemasResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSchemasResponse.ProtoReflect.Descriptor instead.
+func (*ListSchemasResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ListSchemasResponse) GetSchemas() []*Schema {
+	if x != nil {
+		return x.Schemas
+	}
+	return nil
+}
+
+type ListTransformsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTransformsRequest) Reset() {
+	*x = ListTransformsRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTransformsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTransformsRequest) ProtoMessage() {}
+
+func (x *ListTransformsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTransformsRequest.ProtoReflect.Descriptor instead.
+func (*ListTransformsRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{19}
+}
+
+type TransformStats struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Получено сообщений исходных ключей
+	Received uint64 `protobuf:"varint,2,opt,name=received,proto3" json:"received,omitempty"`
+	// Отброшено фильтром
+	Filtered uint64 `protobuf:"varint,3,opt,name=filtered,proto3" json:"filtered,omitempty"`
+	// Опубликовано производных сообщений
+	Published uint64 `protobuf:"varint,4,opt,name=published,proto3" json:"published,omitempty"`
+	// Не удалось преобразовать или опубликовать
+	Failed uint64 `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
+	// Отброшено как уже произведённые этим правилом
+	Loops         uint64 `protobuf:"varint,6,opt,name=loops,proto3" json:"loops,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformStats) Reset() {
+	*x = TransformStats{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformStats) ProtoMessage() {}
+
+func (x *TransformStats) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformStats.ProtoReflect.Descriptor instead.
+func (*TransformStats) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *TransformStats) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TransformStats) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+func (x *TransformStats) GetFiltered() uint64 {
+	if x != nil {
+		return x.Filtered
+	}
+	return 0
+}
+
+func (x *TransformStats) GetPublished() uint64 {
+	if x != nil {
+		return x.Published
+	}
+	return 0
+}
+
+func (x *TransformStats) GetFailed() uint64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *TransformStats) GetLoops() uint64 {
+	if x != nil {
+		return x.Loops
+	}
+	return 0
+}
+
+type ListTransformsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Transforms    []*TransformStats      `protobuf:"bytes,1,rep,name=transforms,proto3" json:"transforms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTransformsResponse) Reset() {
+	*x = ListTransformsResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTransformsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTransformsResponse) ProtoMessage() {}
+
+func (x *ListTransformsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTransformsResponse.ProtoReflect.Descriptor instead.
+func (*ListTransformsResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ListTransformsResponse) GetTransforms() []*TransformStats {
+	if x != nil {
+		return x.Transforms
+	}
+	return nil
+}
+
+type SubjectMapping struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Применяется первое подходящее правило
+	Maps []*MapRule `protobuf:"bytes,1,rep,name=maps,proto3" json:"maps,omitempty"`
+	// Копируют сообщения уже переименованных ключей
+	Mirrors       []*MirrorRule `protobuf:"bytes,2,rep,name=mirrors,proto3" json:"mirrors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubjectMapping) Reset() {
+	*x = SubjectMapping{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubjectMapping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubjectMapping) ProtoMessage() {}
+
+func (x *SubjectMapping) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubjectMapping.ProtoReflect.Descriptor instead.
+func (*SubjectMapping) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *SubjectMapping) GetMaps() []*MapRule {
+	if x != nil {
+		return x.Maps
+	}
+	return nil
+}
+
+func (x *SubjectMapping) GetMirrors() []*MirrorRule {
+	if x != nil {
+		return x.Mirrors
+	}
+	return nil
+}
+
+type MapRule struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Шаблон ключей, как у схем
+	Source string `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// Ключ выбирается случайно по весам для каждого сообщения
+	Destinations  []*WeightedKey `protobuf:"bytes,2,rep,name=destinations,proto3" json:"destinations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MapRule) Reset() {
+	*x = MapRule{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MapRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MapRule) ProtoMessage() {}
+
+func (x *MapRule) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MapRule.ProtoReflect.Descriptor instead.
+func (*MapRule) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *MapRule) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *MapRule) GetDestinations() []*WeightedKey {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+type WeightedKey struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// $1, $2... — токены, совпавшие с подстановками источника
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Доля сообщений, при всех нулевых весах доли равны
+	Weight        uint32 `protobuf:"varint,2,opt,name=weight,proto3" json:"weight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WeightedKey) Reset() {
+	*x = WeightedKey{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WeightedKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WeightedKey) ProtoMessage() {}
+
+func (x *WeightedKey) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WeightedKey.ProtoReflect.Descriptor instead.
+func (*WeightedKey) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *WeightedKey) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *WeightedKey) GetWeight() uint32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+type MirrorRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Source        string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Destination   string                 `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MirrorRule) Reset() {
+	*x = MirrorRule{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MirrorRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MirrorRule) ProtoMessage() {}
+
+func (x *MirrorRule) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MirrorRule.ProtoReflect.Descriptor instead.
+func (*MirrorRule) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *MirrorRule) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *MirrorRule) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+type GetSubjectMappingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSubjectMappingRequest) Reset() {
+	*x = GetSubjectMappingRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSubjectMappingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSubjectMappingRequest) ProtoMessage() {}
+
+func (x *GetSubjectMappingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSubjectMappingRequest.ProtoReflect.Descriptor instead.
+func (*GetSubjectMappingRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{26}
+}
+
+type GetSubjectMappingResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mapping       *SubjectMapping        `protobuf:"bytes,1,opt,name=mapping,proto3" json:"mapping,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSubjectMappingResponse) Reset() {
+	*x = GetSubjectMappingResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSubjectMappingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSubjectMappingResponse) ProtoMessage() {}
+
+func (x *GetSubjectMappingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSubjectMappingResponse.ProtoReflect.Descriptor instead.
+func (*GetSubjectMappingResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *GetSubjectMappingResponse) GetMapping() *SubjectMapping {
+	if x != nil {
+		return x.Mapping
+	}
+	return nil
+}
+
+type SetSubjectMappingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mapping       *SubjectMapping        `protobuf:"bytes,1,opt,name=mapping,proto3" json:"mapping,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetSubjectMappingRequest) Reset() {
+	*x = SetSubjectMappingRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetSubjectMappingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetSubjectMappingRequest) ProtoMessage() {}
+
+func (x *SetSubjectMappingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetSubjectMappingRequest.ProtoReflect.Descriptor instead.
+func (*SetSubjectMappingRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *SetSubjectMappingRequest) GetMapping() *SubjectMapping {
+	if x != nil {
+		return x.Mapping
+	}
+	return nil
+}
+
+type SetSubjectMappingResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetSubjectMappingResponse) Reset() {
+	*x = SetSubjectMappingResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetSubjectMappingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetSubjectMappingResponse) ProtoMessage() {}
+
+func (x *SetSubjectMappingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetSubjectMappingResponse.ProtoReflect.Descriptor instead.
+func (*SetSubjectMappingResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{29}
+}
+
+type ListGroupsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListGroupsRequest) Reset() {
+	*x = ListGroupsRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListGroupsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListGroupsRequest) ProtoMessage() {}
+
+func (x *ListGroupsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListGroupsRequest.ProtoReflect.Descriptor instead.
+func (*ListGroupsRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{30}
+}
+
+type ConsumerGroup struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Group string                 `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	// Число участников группы
+	Members uint32 `protobuf:"varint,3,opt,name=members,proto3" json:"members,omitempty"`
+	// По индексу секции
+	Partitions    []*PartitionOffset `protobuf:"bytes,4,rep,name=partitions,proto3" json:"partitions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumerGroup) Reset() {
+	*x = ConsumerGroup{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumerGroup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumerGroup) ProtoMessage() {}
+
+func (x *ConsumerGroup) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumerGroup.ProtoReflect.Descriptor instead.
+func (*ConsumerGroup) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *ConsumerGroup) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ConsumerGroup) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *ConsumerGroup) GetMembers() uint32 {
+	if x != nil {
+		return x.Members
+	}
+	return 0
+}
+
+func (x *ConsumerGroup) GetPartitions() []*PartitionOffset {
+	if x != nil {
+		return x.Partitions
+	}
+	return nil
+}
+
+type PartitionOffset struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Закреплённое смещение: следующее сообщение для группы
+	Offset int64 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	// Смещение следующего опубликованного в секцию сообщения
+	End int64 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	// Пропущено по времени жизни
+	Expired uint64 `protobuf:"varint,3,opt,name=expired,proto3" json:"expired,omitempty"`
+	// Вытеснено из секции до обработки группой
+	Dropped       uint64 `protobuf:"varint,4,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PartitionOffset) Reset() {
+	*x = PartitionOffset{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PartitionOffset) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PartitionOffset) ProtoMessage() {}
+
+func (x *PartitionOffset) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PartitionOffset.ProtoReflect.Descriptor instead.
+func (*PartitionOffset) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *PartitionOffset) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *PartitionOffset) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *PartitionOffset) GetExpired() uint64 {
+	if x != nil {
+		return x.Expired
+	}
+	return 0
+}
+
+func (x *PartitionOffset) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+type ListGroupsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Groups        []*ConsumerGroup       `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListGroupsResponse) Reset() {
+	*x = ListGroupsResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListGroupsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListGroupsResponse) ProtoMessage() {}
+
+func (x *ListGroupsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListGroupsResponse.ProtoReflect.Descriptor instead.
+func (*ListGroupsResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *ListGroupsResponse) GetGroups() []*ConsumerGroup {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+type SnapshotRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotRequest) Reset() {
+	*x = SnapshotRequest{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotRequest) ProtoMessage() {}
+
+func (x *SnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotRequest.ProtoReflect.Descriptor instead.
+func (*SnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{34}
+}
+
+type SnapshotChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotChunk) Reset() {
+	*x = SnapshotChunk{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotChunk) ProtoMessage() {}
+
+func (x *SnapshotChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotChunk.ProtoReflect.Descriptor instead.
+func (*SnapshotChunk) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *SnapshotChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type RestoreResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Subjects uint32                 `protobuf:"varint,1,opt,name=subjects,proto3" json:"subjects,omitempty"`
+	// Сообщения секций
+	Messages      uint32 `protobuf:"varint,2,opt,name=messages,proto3" json:"messages,omitempty"`
+	Groups        uint32 `protobuf:"varint,3,opt,name=groups,proto3" json:"groups,omitempty"`
+	Scheduled     uint32 `protobuf:"varint,4,opt,name=scheduled,proto3" json:"scheduled,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreResponse) Reset() {
+	*x = RestoreResponse{}
+	mi := &file_pubsub_pubsub_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreResponse) ProtoMessage() {}
+
+func (x *RestoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pubsub_pubsub_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreResponse.ProtoReflect.Descriptor instead.
+func (*RestoreResponse) Descriptor() ([]byte, []int) {
+	return file_pubsub_pubsub_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *RestoreResponse) GetSubjects() uint32 {
+	if x != nil {
+		return x.Subjects
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetMessages() uint32 {
+	if x != nil {
+		return x.Messages
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetGroups() uint32 {
+	if x != nil {
+		return x.Groups
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetScheduled() uint32 {
+	if x != nil {
+		return x.Scheduled
+	}
+	return 0
+}
+
+var File_pubsub_pubsub_proto protoreflect.FileDescriptor
+
+const file_pubsub_pubsub_proto_rawDesc = "" +
+	"\n" +
+	"\x13pubsub/pubsub.proto\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb0\x01\n" +
+	"\x10SubscribeRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1d\n" +
+	"\n" +
+	"max_queued\x18\x02 \x01(\rR\tmaxQueued\x12\x14\n" +
+	"\x05group\x18\x03 \x01(\tR\x05group\x124\n" +
+	"\back_wait\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\aackWait\x12\x1f\n" +
+	"\vmax_deliver\x18\x05 \x01(\rR\n" +
+	"maxDeliver\"\xb8\x01\n" +
+	"\x0eSubscribeFrame\x121\n" +
+	"\tsubscribe\x18\x01 \x01(\v2\x11.SubscribeRequestH\x00R\tsubscribe\x12#\n" +
+	"\x05pause\x18\x02 \x01(\v2\v.PauseFrameH\x00R\x05pause\x12&\n" +
+	"\x06resume\x18\x03 \x01(\v2\f.ResumeFrameH\x00R\x06resume\x12\x1d\n" +
+	"\x03ack\x18\x04 \x01(\v2\t.AckFrameH\x00R\x03ackB\a\n" +
+	"\x05frame\"\f\n" +
+	"\n" +
+	"PauseFrame\"\r\n" +
+	"\vResumeFrame\"#\n" +
+	"\bAckFrame\x12\x17\n" +
+	"\aack_ids\x18\x01 \x03(\x04R\x06ackIds\"\x98\x02\n" +
+	"\x0ePublishRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\tR\x04data\x12*\n" +
+	"\x11wait_for_delivery\x18\x03 \x01(\bR\x0fwaitForDelivery\x129\n" +
+	"\n" +
+	"deliver_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tdeliverAt\x12+\n" +
+	"\x03ttl\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\x03ttl\x12'\n" +
+	"\x0fidempotency_key\x18\x06 \x01(\tR\x0eidempotencyKey\x12#\n" +
+	"\rpartition_key\x18\a \x01(\tR\fpartitionKey\"R\n" +
+	"\x0fPublishResponse\x12\x1c\n" +
+	"\tdelivered\x18\x01 \x01(\rR\tdelivered\x12!\n" +
+	"\fscheduled_id\x18\x02 \x01(\x04R\vscheduledId\"\x84\x02\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\tR\x04data\x12=\n" +
+	"\rtrace_context\x18\x02 \x03(\v2\x18.Event.TraceContextEntryR\ftraceContext\x12\x1c\n" +
+	"\tpartition\x18\x03 \x01(\rR\tpartition\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x03R\x06offset\x12\x15\n" +
+	"\x06ack_id\x18\x05 \x01(\x04R\x05ackId\x12\x1a\n" +
+	"\bdelivery\x18\x06 \x01(\rR\bdelivery\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xac\x01\n" +
+	"\tTxMessage\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\tR\x04data\x12+\n" +
+	"\x03ttl\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\x03ttl\x12'\n" +
+	"\x0fidempotency_key\x18\x04 \x01(\tR\x0eidempotencyKey\x12#\n" +
+	"\rpartition_key\x18\x05 \x01(\tR\fpartitionKey\":\n" +
+	"\x10PublishTxRequest\x12&\n" +
+	"\bmessages\x18\x01 \x03(\v2\n" +
+	".TxMessageR\bmessages\"\x13\n" +
+	"\x11PublishTxResponse\"y\n" +
+	"\x06Schema\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern\x12!\n" +
+	"\vjson_schema\x18\x02 \x01(\tH\x00R\n" +
+	"jsonSchema\x12$\n" +
+	"\x05proto\x18\x03 \x01(\v2\f.ProtoSchemaH\x00R\x05protoB\f\n" +
+	"\n" +
+	"definition\"N\n" +
+	"\vProtoSchema\x12%\n" +
+	"\x0edescriptor_set\x18\x01 \x01(\fR\rdescriptorSet\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"8\n" +
+	"\x15RegisterSchemaRequest\x12\x1f\n" +
+	"\x06schema\x18\x01 \x01(\v2\a.SchemaR\x06schema\"\x18\n" +
+	"\x16RegisterSchemaResponse\"/\n" +
+	"\x13DeleteSchemaRequest\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern\"\x16\n" +
+	"\x14DeleteSchemaResponse\"\x14\n" +
+	"\x12ListSchemasRequest\"8\n" +
+	"\x13ListSchemasResponse\x12!\n" +
+	"\aschemas\x18\x01 \x03(\v2\a.SchemaR\aschemas\"\x17\n" +
+	"\x15ListTransformsRequest\"\xa8\x01\n" +
+	"\x0eTransformStats\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\breceived\x18\x02 \x01(\x04R\breceived\x12\x1a\n" +
+	"\bfiltered\x18\x03 \x01(\x04R\bfiltered\x12\x1c\n" +
+	"\tpublished\x18\x04 \x01(\x04R\tpublished\x12\x16\n" +
+	"\x06failed\x18\x05 \x01(\x04R\x06failed\x12\x14\n" +
+	"\x05loops\x18\x06 \x01(\x04R\x05loops\"I\n" +
+	"\x16ListTransformsResponse\x12/\n" +
+	"\n" +
+	"transforms\x18\x01 \x03(\v2\x0f.TransformStatsR\n" +
+	"transforms\"U\n" +
+	"\x0eSubjectMapping\x12\x1c\n" +
+	"\x04maps\x18\x01 \x03(\v2\b.MapRuleR\x04maps\x12%\n" +
+	"\amirrors\x18\x02 \x03(\v2\v.MirrorRuleR\amirrors\"S\n" +
+	"\aMapRule\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x120\n" +
+	"\fdestinations\x18\x02 \x03(\v2\f.WeightedKeyR\fdestinations\"7\n" +
+	"\vWeightedKey\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x16\n" +
+	"\x06weight\x18\x02 \x01(\rR\x06weight\"F\n" +
+	"\n" +
+	"MirrorRule\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12 \n" +
+	"\vdestination\x18\x02 \x01(\tR\vdestination\"\x1a\n" +
+	"\x18GetSubjectMappingRequest\"F\n" +
+	"\x19GetSubjectMappingResponse\x12)\n" +
+	"\amapping\x18\x01 \x01(\v2\x0f.SubjectMappingR\amapping\"E\n" +
+	"\x18SetSubjectMappingRequest\x12)\n" +
+	"\amapping\x18\x01 \x01(\v2\x0f.SubjectMappingR\amapping\"\x1b\n" +
+	"\x19SetSubjectMappingResponse\"\x13\n" +
+	"\x11ListGroupsRequest\"\x83\x01\n" +
+	"\rConsumerGroup\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05group\x18\x02 \x01(\tR\x05group\x12\x18\n" +
+	"\amembers\x18\x03 \x01(\rR\amembers\x120\n" +
+	"\n" +
+	"partitions\x18\x04 \x03(\v2\x10.PartitionOffsetR\n" +
+	"partitions\"o\n" +
+	"\x0fPartitionOffset\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x03R\x06offset\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x03R\x03end\x12\x18\n" +
+	"\aexpired\x18\x03 \x01(\x04R\aexpired\x12\x18\n" +
+	"\adropped\x18\x04 \x01(\x04R\adropped\"<\n" +
+	"\x12ListGroupsResponse\x12&\n" +
+	"\x06groups\x18\x01 \x03(\v2\x0e.ConsumerGroupR\x06groups\"\x11\n" +
+	"\x0fSnapshotRequest\"#\n" +
+	"\rSnapshotChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"\x7f\n" +
+	"\x0fRestoreResponse\x12\x1a\n" +
+	"\bsubjects\x18\x01 \x01(\rR\bsubjects\x12\x1a\n" +
+	"\bmessages\x18\x02 \x01(\rR\bmessages\x12\x16\n" +
+	"\x06groups\x18\x03 \x01(\rR\x06groups\x12\x1c\n" +
+	"\tscheduled\x18\x04 \x01(\rR\tscheduled2\xc4\x01\n" +
+	"\x06PubSub\x12(\n" +
+	"\tSubscribe\x12\x11.SubscribeRequest\x1a\x06.Event0\x01\x12.\n" +
+	"\x0fSubscribeStream\x12\x0f.SubscribeFrame\x1a\x06.Event(\x010\x01\x12,\n" +
+	"\aPublish\x12\x0f.PublishRequest\x1a\x10.PublishResponse\x122\n" +
+	"\tPublishTx\x12\x11.PublishTxRequest\x1a\x12.PublishTxResponse2\xb2\x04\n" +
+	"\x05Admin\x12A\n" +
+	"\x0eRegisterSchema\x12\x16.RegisterSchemaRequest\x1a\x17.RegisterSchemaResponse\x12;\n" +
+	"\fDeleteSchema\x12\x14.DeleteSchemaRequest\x1a\x15.DeleteSchemaResponse\x128\n" +
+	"\vListSchemas\x12\x13.ListSchemasRequest\x1a\x14.ListSchemasResponse\x12A\n" +
+	"\x0eListTransforms\x12\x16.ListTransformsRequest\x1a\x17.ListTransformsResponse\x12J\n" +
+	"\x11GetSubjectMapping\x12\x19.GetSubjectMappingRequest\x1a\x1a.GetSubjectMappingResponse\x12J\n" +
+	"\x11SetSubjectMapping\x12\x19.SetSubjectMappingRequest\x1a\x1a.SetSubjectMappingResponse\x125\n" +
+	"\n" +
+	"ListGroups\x12\x12.ListGroupsRequest\x1a\x13.ListGroupsResponse\x12.\n" +
+	"\bSnapshot\x12\x10.SnapshotRequest\x1a\x0e.SnapshotChunk0\x01\x12-\n" +
+	"\aRestore\x12\x0e.SnapshotChunk\x1a\x10.RestoreResponse(\x01B\x1bZ\x19Kry0z1.pubsub.v1;pubsubv1b\x06proto3"
+
+var (
+	file_pubsub_pubsub_proto_rawDescOnce sync.Once
+	file_pubsub_pubsub_proto_rawDescData []byte
+)
+
+func file_pubsub_pubsub_proto_rawDescGZIP() []byte {
+	file_pubsub_pubsub_proto_rawDescOnce.Do(func() {
+		file_pubsub_pubsub_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pubsub_pubsub_proto_rawDesc), len(file_pubsub_pubsub_proto_rawDesc)))
+	})
+	return file_pubsub_pubsub_proto_rawDescData
+}
+
+var file_pubsub_pubsub_proto_msgTypes = make([]protoimpl.MessageInfo, 38)
+var file_pubsub_pubsub_proto_goTypes = []any{
+	(*SubscribeRequest)(nil),          // 0: SubscribeRequest
+	(*SubscribeFrame)(nil),            // 1: SubscribeFrame
+	(*PauseFrame)(nil),                // 2: PauseFrame
+	(*ResumeFrame)(nil),               // 3: ResumeFrame
+	(*AckFrame)(nil),                  // 4: AckFrame
+	(*PublishRequest)(nil),            // 5: PublishRequest
+	(*PublishResponse)(nil),           // 6: PublishResponse
+	(*Event)(nil),                     // 7: Event
+	(*TxMessage)(nil),                 // 8: TxMessage
+	(*PublishTxRequest)(nil),          // 9: PublishTxRequest
+	(*PublishTxResponse)(nil),         // 10: PublishTxResponse
+	(*Schema)(nil),                    // 11: Schema
+	(*ProtoSchema)(nil),               // 12: ProtoSchema
+	(*RegisterSchemaRequest)(nil),     // 13: RegisterSchemaRequest
+	(*RegisterSchemaResponse)(nil),    // 14: RegisterSchemaResponse
+	(*DeleteSchemaRequest)(nil),       // 15: DeleteSchemaRequest
+	(*DeleteSchemaResponse)(nil),      // 16: DeleteSchemaResponse
+	(*ListSchemasRequest)(nil),        // 17: ListSchemasRequest
+	(*ListSchemasResponse)(nil),       // 18: ListSchemasResponse
+	(*ListTransformsRequest)(nil),     // 19: ListTransformsRequest
+	(*TransformStats)(nil),            // 20: TransformStats
+	(*ListTransformsResponse)(nil),    // 21: ListTransformsResponse
+	(*SubjectMapping)(nil),            // 22: SubjectMapping
+	(*MapRule)(nil),                   // 23: MapRule
+	(*WeightedKey)(nil),               // 24: WeightedKey
+	(*MirrorRule)(nil),                // 25: MirrorRule
+	(*GetSubjectMappingRequest)(nil),  // 26: GetSubjectMappingRequest
+	(*GetSubjectMappingResponse)(nil), // 27: GetSubjectMappingResponse
+	(*SetSubjectMappingRequest)(nil),  // 28: SetSubjectMappingRequest
+	(*SetSubjectMappingResponse)(nil), // 29: SetSubjectMappingResponse
+	(*ListGroupsRequest)(nil),         // 30: ListGroupsRequest
+	(*ConsumerGroup)(nil),             // 31: ConsumerGroup
+	(*PartitionOffset)(nil),           // 32: PartitionOffset
+	(*ListGroupsResponse)(nil),        // 33: ListGroupsResponse
+	(*SnapshotRequest)(nil),           // 34: SnapshotRequest
+	(*SnapshotChunk)(nil),             // 35: SnapshotChunk
+	(*RestoreResponse)(nil),           // 36: RestoreResponse
+	nil,                               // 37: Event.TraceContextEntry
+	(*durationpb.Duration)(nil),       // 38: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil),     // 39: google.protobuf.Timestamp
+}
+var file_pubsub_pubsub_proto_depIdxs = []int32{
+	38, // 0: SubscribeRequest.ack_wait:type_name -> google.protobuf.Duration
+	0,  // 1: SubscribeFrame.subscribe:type_name -> SubscribeRequest
+	2,  // 2: SubscribeFrame.pause:type_name -> PauseFrame
+	3,  // 3: SubscribeFrame.resume:type_name -> ResumeFrame
+	4,  // 4: SubscribeFrame.ack:type_name -> AckFrame
+	39, // 5: PublishRequest.deliver_at:type_name -> google.protobuf.Timestamp
+	38, // 6: PublishRequest.ttl:type_name -> google.protobuf.Duration
+	37, // 7: Event.trace_context:type_name -> Event.TraceContextEntry
+	38, // 8: TxMessage.ttl:type_name -> google.protobuf.Duration
+	8,  // 9: PublishTxRequest.messages:type_name -> TxMessage
+	12, // 10: Schema.proto:type_name -> ProtoSchema
+	11, // 11: RegisterSchemaRequest.schema:type_name -> Schema
+	11, // 12: ListSchemasResponse.schemas:type_name -> Schema
+	20, // 13: ListTransformsResponse.transforms:type_name -> TransformStats
+	23, // 14: SubjectMapping.maps:type_name -> MapRule
+	25, // 15: SubjectMapping.mirrors:type_name -> MirrorRule
+	24, // 16: MapRule.destinations:type_name -> WeightedKey
+	22, // 17: GetSubjectMappingResponse.mapping:type_name -> SubjectMapping
+	22, // 18: SetSubjectMappingRequest.mapping:type_name -> SubjectMapping
+	32, // 19: ConsumerGroup.partitions:type_name -> PartitionOffset
+	31, // 20: ListGroupsResponse.groups:type_name -> ConsumerGroup
+	0,  // 21: PubSub.Subscribe:input_type -> SubscribeRequest
+	1,  // 22: PubSub.SubscribeStream:input_type -> SubscribeFrame
+	5,  // 23: PubSub.Publish:input_type -> PublishRequest
+	9,  // 24: PubSub.PublishTx:input_type -> PublishTxRequest
+	13, // 25: Admin.RegisterSchema:input_type -> RegisterSchemaRequest
+	15, // 26: Admin.DeleteSchema:input_type -> DeleteSchemaRequest
+	17, // 27: Admin.ListSchemas:input_type -> ListSchemasRequest
+	19, // 28: Admin.ListTransforms:input_type -> ListTransformsRequest
+	26, // 29: Admin.GetSubjectMapping:input_type -> GetSubjectMappingRequest
+	28, // 30: Admin.SetSubjectMapping:input_type -> SetSubjectMappingRequest
+	30, // 31: Admin.ListGroups:input_type -> ListGroupsRequest
+	34, // 32: Admin.Snapshot:input_type -> SnapshotRequest
+	35, // 33: Admin.Restore:input_type -> SnapshotChunk
+	7,  // 34: PubSub.Subscribe:output_type -> Event
+	7,  // 35: PubSub.SubscribeStream:output_type -> Event
+	6,  // 36: PubSub.Publish:output_type -> PublishResponse
+	10, // 37: PubSub.PublishTx:output_type -> PublishTxResponse
+	14, // 38: Admin.RegisterSchema:output_type -> RegisterSchemaResponse
+	16, // 39: Admin.DeleteSchema:output_type -> DeleteSchemaResponse
+	18, // 40: Admin.ListSchemas:output_type -> ListSchemasResponse
+	21, // 41: Admin.ListTransforms:output_type -> ListTransformsResponse
+	27, // 42: Admin.GetSubjectMapping:output_type -> GetSubjectMappingResponse
+	29, // 43: Admin.SetSubjectMapping:output_type -> SetSubjectMappingResponse
+	33, // 44: Admin.ListGroups:output_type -> ListGroupsResponse
+	35, // 45: Admin.Snapshot:output_type -> SnapshotChunk
+	36, // 46: Admin.Restore:output_type -> RestoreResponse
+	34, // [34:47] is the sub-list for method output_type
+	21, // [21:34] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
+}
+
+func init() { file_pubsub_pubsub_proto_init() }
 func file_pubsub_pubsub_proto_init() {
 	if File_pubsub_pubsub_proto != nil {
 		return
 	}
+	file_pubsub_pubsub_proto_msgTypes[1].OneofWrappers = []any{
+		(*SubscribeFrame_Subscribe)(nil),
+		(*SubscribeFrame_Pause)(nil),
+		(*SubscribeFrame_Resume)(nil),
+		(*SubscribeFrame_Ack)(nil),
+	}
+	file_pubsub_pubsub_proto_msgTypes[11].OneofWrappers = []any{
+		(*Schema_JsonSchema)(nil),
+		(*Schema_Proto)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pubsub_pubsub_proto_rawDesc), len(file_pubsub_pubsub_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   38,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_pubsub_pubsub_proto_goTypes,
 		DependencyIndexes: file_pubsub_pubsub_proto_depIdxs,
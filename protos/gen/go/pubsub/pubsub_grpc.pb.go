@@ -11,7 +11,6 @@ import (
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
-	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 // This is a compile-time assertion to ensure that this generated file
@@ -20,8 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	PubSub_Subscribe_FullMethodName = "/PubSub/Subscribe"
-	PubSub_Publish_FullMethodName   = "/PubSub/Publish"
+	PubSub_Subscribe_FullMethodName       = "/PubSub/Subscribe"
+	PubSub_SubscribeStream_FullMethodName = "/PubSub/SubscribeStream"
+	PubSub_Publish_FullMethodName         = "/PubSub/Publish"
+	PubSub_PublishTx_FullMethodName       = "/PubSub/PublishTx"
 )
 
 // PubSubClient is the client API for PubSub service.
@@ -30,8 +31,13 @@ const (
 type PubSubClient interface {
 	// Подписка (сервер отправляет поток событий)
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// Подписка с управлением: первый кадр открывает подписку,
+	// следующие приостанавливают и возобновляют доставку
+	SubscribeStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SubscribeFrame, Event], error)
 	// Публикация (классический запрос-ответ)
-	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	// Атомарная публикация в несколько ключей: подписчики видят либо все сообщения, либо ни одного
+	PublishTx(ctx context.Context, in *PublishTxRequest, opts ...grpc.CallOption) (*PublishTxResponse, error)
 }
 
 type pubSubClient struct {
@@ -61,9 +67,22 @@ func (c *pubSubClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type PubSub_SubscribeClient = grpc.ServerStreamingClient[Event]
 
-func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+func (c *pubSubClient) SubscribeStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SubscribeFrame, Event], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	out := new(emptypb.Empty)
+	stream, err := c.cc.NewStream(ctx, &PubSub_ServiceDesc.Streams[1], PubSub_SubscribeStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeFrame, Event]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SubscribeStreamClient = grpc.BidiStreamingClient[SubscribeFrame, Event]
+
+func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishResponse)
 	err := c.cc.Invoke(ctx, PubSub_Publish_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
@@ -71,14 +90,29 @@ func (c *pubSubClient) Publish(ctx context.Context, in *PublishRequest, opts ...
 	return out, nil
 }
 
+func (c *pubSubClient) PublishTx(ctx context.Context, in *PublishTxRequest, opts ...grpc.CallOption) (*PublishTxResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishTxResponse)
+	err := c.cc.Invoke(ctx, PubSub_PublishTx_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PubSubServer is the server API for PubSub service.
 // All implementations must embed UnimplementedPubSubServer
 // for forward compatibility.
 type PubSubServer interface {
 	// Подписка (сервер отправляет поток событий)
 	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error
+	// Подписка с управлением: первый кадр открывает подписку,
+	// следующие приостанавливают и возобновляют доставку
+	SubscribeStream(grpc.BidiStreamingServer[SubscribeFrame, Event]) error
 	// Публикация (классический запрос-ответ)
-	Publish(context.Context, *PublishRequest) (*emptypb.Empty, error)
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+	// Атомарная публикация в несколько ключей: подписчики видят либо все сообщения, либо ни одного
+	PublishTx(context.Context, *PublishTxRequest) (*PublishTxResponse, error)
 	mustEmbedUnimplementedPubSubServer()
 }
 
@@ -92,9 +126,15 @@ type UnimplementedPubSubServer struct{}
 func (UnimplementedPubSubServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error {
 	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
-func (UnimplementedPubSubServer) Publish(context.Context, *PublishRequest) (*emptypb.Empty, error) {
+func (UnimplementedPubSubServer) SubscribeStream(grpc.BidiStreamingServer[SubscribeFrame, Event]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeStream not implemented")
+}
+func (UnimplementedPubSubServer) Publish(context.Context, *PublishRequest) (*PublishResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedPubSubServer) PublishTx(context.Context, *PublishTxRequest) (*PublishTxResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishTx not implemented")
+}
 func (UnimplementedPubSubServer) mustEmbedUnimplementedPubSubServer() {}
 func (UnimplementedPubSubServer) testEmbeddedByValue()                {}
 
@@ -127,6 +167,13 @@ func _PubSub_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type PubSub_SubscribeServer = grpc.ServerStreamingServer[Event]
 
+func _PubSub_SubscribeStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PubSubServer).SubscribeStream(&grpc.GenericServerStream[SubscribeFrame, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PubSub_SubscribeStreamServer = grpc.BidiStreamingServer[SubscribeFrame, Event]
+
 func _PubSub_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PublishRequest)
 	if err := dec(in); err != nil {
@@ -145,6 +192,24 @@ func _PubSub_Publish_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PubSub_PublishTx_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishTxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PubSubServer).PublishTx(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PubSub_PublishTx_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PubSubServer).PublishTx(ctx, req.(*PublishTxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PubSub_ServiceDesc is the grpc.ServiceDesc for PubSub service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -156,6 +221,10 @@ var PubSub_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _PubSub_Publish_Handler,
 		},
+		{
+			MethodName: "PublishTx",
+			Handler:    _PubSub_PublishTx_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -163,6 +232,441 @@ var PubSub_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _PubSub_Subscribe_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "SubscribeStream",
+			Handler:       _PubSub_SubscribeStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pubsub/pubsub.proto",
+}
+
+const (
+	Admin_RegisterSchema_FullMethodName    = "/Admin/RegisterSchema"
+	Admin_DeleteSchema_FullMethodName      = "/Admin/DeleteSchema"
+	Admin_ListSchemas_FullMethodName       = "/Admin/ListSchemas"
+	Admin_ListTransforms_FullMethodName    = "/Admin/ListTransforms"
+	Admin_GetSubjectMapping_FullMethodName = "/Admin/GetSubjectMapping"
+	Admin_SetSubjectMapping_FullMethodName = "/Admin/SetSubjectMapping"
+	Admin_ListGroups_FullMethodName        = "/Admin/ListGroups"
+	Admin_Snapshot_FullMethodName          = "/Admin/Snapshot"
+	Admin_Restore_FullMethodName           = "/Admin/Restore"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Управление сервисом
+type AdminClient interface {
+	// Регистрация схемы данных для ключей по шаблону, заменяет прежнюю схему шаблона
+	RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error)
+	// Удаление схемы шаблона
+	DeleteSchema(ctx context.Context, in *DeleteSchemaRequest, opts ...grpc.CallOption) (*DeleteSchemaResponse, error)
+	// Список схем, отсортированный по шаблону
+	ListSchemas(ctx context.Context, in *ListSchemasRequest, opts ...grpc.CallOption) (*ListSchemasResponse, error)
+	// Счётчики правил производных ключей в порядке конфигурации
+	ListTransforms(ctx context.Context, in *ListTransformsRequest, opts ...grpc.CallOption) (*ListTransformsResponse, error)
+	// Текущие правила переименования и зеркалирования ключей
+	GetSubjectMapping(ctx context.Context, in *GetSubjectMappingRequest, opts ...grpc.CallOption) (*GetSubjectMappingResponse, error)
+	// Замена всех правил переименования и зеркалирования ключей
+	SetSubjectMapping(ctx context.Context, in *SetSubjectMappingRequest, opts ...grpc.CallOption) (*SetSubjectMappingResponse, error)
+	// Группы потребителей секционированных ключей с закреплёнными смещениями
+	ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error)
+	// Снимок состояния сервера: ключи, сообщения секций, смещения групп
+	// и отложенные сообщения. Файл снимка передаётся частями
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SnapshotChunk], error)
+	// Загрузка снимка в новый сервер, секционированные ключи которого
+	// настроены так же и ещё пусты
+	Restore(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotChunk, RestoreResponse], error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterSchemaResponse)
+	err := c.cc.Invoke(ctx, Admin_RegisterSchema_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteSchema(ctx context.Context, in *DeleteSchemaRequest, opts ...grpc.CallOption) (*DeleteSchemaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteSchemaResponse)
+	err := c.cc.Invoke(ctx, Admin_DeleteSchema_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListSchemas(ctx context.Context, in *ListSchemasRequest, opts ...grpc.CallOption) (*ListSchemasResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSchemasResponse)
+	err := c.cc.Invoke(ctx, Admin_ListSchemas_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListTransforms(ctx context.Context, in *ListTransformsRequest, opts ...grpc.CallOption) (*ListTransformsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTransformsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListTransforms_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetSubjectMapping(ctx context.Context, in *GetSubjectMappingRequest, opts ...grpc.CallOption) (*GetSubjectMappingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSubjectMappingResponse)
+	err := c.cc.Invoke(ctx, Admin_GetSubjectMapping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetSubjectMapping(ctx context.Context, in *SetSubjectMappingRequest, opts ...grpc.CallOption) (*SetSubjectMappingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetSubjectMappingResponse)
+	err := c.cc.Invoke(ctx, Admin_SetSubjectMapping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListGroupsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListGroups_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SnapshotChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[0], Admin_Snapshot_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SnapshotRequest, SnapshotChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_SnapshotClient = grpc.ServerStreamingClient[SnapshotChunk]
+
+func (c *adminClient) Restore(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotChunk, RestoreResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[1], Admin_Restore_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SnapshotChunk, RestoreResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_RestoreClient = grpc.ClientStreamingClient[SnapshotChunk, RestoreResponse]
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Управление сервисом
+type AdminServer interface {
+	// Регистрация схемы данных для ключей по шаблону, заменяет прежнюю схему шаблона
+	RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error)
+	// Удаление схемы шаблона
+	DeleteSchema(context.Context, *DeleteSchemaRequest) (*DeleteSchemaResponse, error)
+	// Список схем, отсортированный по шаблону
+	ListSchemas(context.Context, *ListSchemasRequest) (*ListSchemasResponse, error)
+	// Счётчики правил производных ключей в порядке конфигурации
+	ListTransforms(context.Context, *ListTransformsRequest) (*ListTransformsResponse, error)
+	// Текущие правила переименования и зеркалирования ключей
+	GetSubjectMapping(context.Context, *GetSubjectMappingRequest) (*GetSubjectMappingResponse, error)
+	// Замена всех правил переименования и зеркалирования ключей
+	SetSubjectMapping(context.Context, *SetSubjectMappingRequest) (*SetSubjectMappingResponse, error)
+	// Группы потребителей секционированных ключей с закреплёнными смещениями
+	ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error)
+	// Снимок состояния сервера: ключи, сообщения секций, смещения групп
+	// и отложенные сообщения. Файл снимка передаётся частями
+	Snapshot(*SnapshotRequest, grpc.ServerStreamingServer[SnapshotChunk]) error
+	// Загрузка снимка в новый сервер, секционированные ключи которого
+	// настроены так же и ещё пусты
+	Restore(grpc.ClientStreamingServer[SnapshotChunk, RestoreResponse]) error
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterSchema not implemented")
+}
+func (UnimplementedAdminServer) DeleteSchema(context.Context, *DeleteSchemaRequest) (*DeleteSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSchema not implemented")
+}
+func (UnimplementedAdminServer) ListSchemas(context.Context, *ListSchemasRequest) (*ListSchemasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchemas not implemented")
+}
+func (UnimplementedAdminServer) ListTransforms(context.Context, *ListTransformsRequest) (*ListTransformsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTransforms not implemented")
+}
+func (UnimplementedAdminServer) GetSubjectMapping(context.Context, *GetSubjectMappingRequest) (*GetSubjectMappingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSubjectMapping not implemented")
+}
+func (UnimplementedAdminServer) SetSubjectMapping(context.Context, *SetSubjectMappingRequest) (*SetSubjectMappingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSubjectMapping not implemented")
+}
+func (UnimplementedAdminServer) ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGroups not implemented")
+}
+func (UnimplementedAdminServer) Snapshot(*SnapshotRequest, grpc.ServerStreamingServer[SnapshotChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedAdminServer) Restore(grpc.ClientStreamingServer[SnapshotChunk, RestoreResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_RegisterSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RegisterSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RegisterSchema_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RegisterSchema(ctx, req.(*RegisterSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_DeleteSchema_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteSchema(ctx, req.(*DeleteSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListSchemas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchemasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListSchemas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListSchemas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListSchemas(ctx, req.(*ListSchemasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListTransforms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTransformsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListTransforms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListTransforms_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListTransforms(ctx, req.(*ListTransformsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetSubjectMapping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSubjectMappingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetSubjectMapping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetSubjectMapping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetSubjectMapping(ctx, req.(*GetSubjectMappingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetSubjectMapping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSubjectMappingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetSubjectMapping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_SetSubjectMapping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetSubjectMapping(ctx, req.(*SetSubjectMappingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGroupsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListGroups_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListGroups(ctx, req.(*ListGroupsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Snapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SnapshotRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).Snapshot(m, &grpc.GenericServerStream[SnapshotRequest, SnapshotChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_SnapshotServer = grpc.ServerStreamingServer[SnapshotChunk]
+
+func _Admin_Restore_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AdminServer).Restore(&grpc.GenericServerStream[SnapshotChunk, RestoreResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Admin_RestoreServer = grpc.ClientStreamingServer[SnapshotChunk, RestoreResponse]
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterSchema",
+			Handler:    _Admin_RegisterSchema_Handler,
+		},
+		{
+			MethodName: "DeleteSchema",
+			Handler:    _Admin_DeleteSchema_Handler,
+		},
+		{
+			MethodName: "ListSchemas",
+			Handler:    _Admin_ListSchemas_Handler,
+		},
+		{
+			MethodName: "ListTransforms",
+			Handler:    _Admin_ListTransforms_Handler,
+		},
+		{
+			MethodName: "GetSubjectMapping",
+			Handler:    _Admin_GetSubjectMapping_Handler,
+		},
+		{
+			MethodName: "SetSubjectMapping",
+			Handler:    _Admin_SetSubjectMapping_Handler,
+		},
+		{
+			MethodName: "ListGroups",
+			Handler:    _Admin_ListGroups_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Snapshot",
+			Handler:       _Admin_Snapshot_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Restore",
+			Handler:       _Admin_Restore_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "pubsub/pubsub.proto",
 }
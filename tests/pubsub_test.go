@@ -1,17 +1,37 @@
 package tests
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/Kry0z1/subpub/internal/cli"
+	"github.com/Kry0z1/subpub/pkg/subpub"
 	pubsubv1 "github.com/Kry0z1/subpub/protos/gen/go/pubsub"
 	"github.com/Kry0z1/subpub/tests/suite"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,12 +39,26 @@ import (
 
 const receiveTimeout = 2 * time.Second
 
+// spans are exported by both client and server,
+// which use global tracer provider.
+var spans = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	os.Exit(m.Run())
+}
+
 type receiveData struct {
 	data string
 	err  error
 }
 
-func msgReceive(stream grpc.ServerStreamingClient[pubsubv1.Event]) chan receiveData {
+// eventStream is either Subscribe or SubscribeStream client.
+type eventStream interface {
+	Recv() (*pubsubv1.Event, error)
+}
+
+func msgReceive(stream eventStream) chan receiveData {
 	ch := make(chan receiveData)
 	go func() {
 		data, err := stream.Recv()
@@ -253,3 +287,855 @@ func TestConcurrentPublish(t *testing.T) {
 	defer mu.Unlock()
 	assert.Equal(t, workers, len(received))
 }
+
+func TestPublishWaitForDelivery(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream1, err := st.Subscribe(ctx, "wait")
+	require.NoError(t, err)
+
+	stream2, err := st.Subscribe(ctx, "wait")
+	require.NoError(t, err)
+
+	received1 := msgReceive(stream1)
+	received2 := msgReceive(stream2)
+
+	delivered, err := st.PublishAndWait(ctx, "wait", "test")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), delivered)
+
+	for _, received := range []chan receiveData{received1, received2} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, "test", msg.data, "Received wrong message")
+		case <-time.After(receiveTimeout):
+			t.Error("Message has not been received")
+		}
+	}
+
+	delivered, err = st.PublishAndWait(ctx, "nobody", "test")
+	require.NoError(t, err)
+	assert.Zero(t, delivered)
+}
+
+func TestPublishDeliverAt(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "delayed")
+	require.NoError(t, err)
+
+	const delay = 200 * time.Millisecond
+	start := time.Now()
+
+	id, err := st.PublishAt(ctx, "delayed", "test", start.Add(delay))
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	select {
+	case msg := <-msgReceive(stream):
+		assert.Equal(t, "test", msg.data, "Received wrong message")
+		assert.GreaterOrEqual(t, time.Since(start), delay, "Delivered too early")
+	case <-time.After(receiveTimeout):
+		t.Error("Message has not been received")
+	}
+}
+
+func TestPublishTTL(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "ttl")
+	require.NoError(t, err)
+
+	_, err = st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+		Key:  "ttl",
+		Data: "invalid",
+		Ttl:  durationpb.New(-time.Second),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+		Key:  "ttl",
+		Data: "test",
+		Ttl:  durationpb.New(time.Minute),
+	})
+	require.NoError(t, err)
+
+	select {
+	case msg := <-msgReceive(stream):
+		assert.Equal(t, "test", msg.data, "Received wrong message")
+	case <-time.After(receiveTimeout):
+		t.Error("Message has not been received")
+	}
+}
+
+func TestPublishIdempotencyKey(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "idempotent")
+	require.NoError(t, err)
+
+	for _, data := range []string{"first", "retry", "second"} {
+		key := "key-1"
+		if data == "second" {
+			key = "key-2"
+		}
+		_, err = st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+			Key:            "idempotent",
+			Data:           data,
+			IdempotencyKey: key,
+		})
+		require.NoError(t, err)
+	}
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case msg := <-msgReceive(stream):
+			assert.Equal(t, expected, msg.data, "Received wrong message")
+		case <-time.After(receiveTimeout):
+			t.Error("Message has not been received")
+		}
+	}
+}
+
+func TestPublishTx(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	orders, err := st.Subscribe(ctx, "order.created")
+	require.NoError(t, err)
+
+	inventory, err := st.Subscribe(ctx, "inventory.reserved")
+	require.NoError(t, err)
+
+	_, err = st.PubSub.PublishTx(ctx, &pubsubv1.PublishTxRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.PubSub.PublishTx(ctx, &pubsubv1.PublishTxRequest{
+		Messages: []*pubsubv1.TxMessage{
+			{Key: "order.created", Data: "order"},
+			{Key: "inventory.reserved", Data: "reservation"},
+		},
+	})
+	require.NoError(t, err)
+
+	for stream, expected := range map[grpc.ServerStreamingClient[pubsubv1.Event]]string{
+		orders:    "order",
+		inventory: "reservation",
+	} {
+		select {
+		case msg := <-msgReceive(stream):
+			assert.Equal(t, expected, msg.data, "Received wrong message")
+		case <-time.After(receiveTimeout):
+			t.Error("Message has not been received")
+		}
+	}
+}
+
+func TestSubscribeStreamPauseResume(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{Key: "paused"})
+	require.NoError(t, err)
+
+	require.NoError(t, st.Publish(ctx, "paused", "before"))
+	select {
+	case msg := <-msgReceive(stream):
+		require.NoError(t, msg.err)
+		assert.Equal(t, "before", msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("Message has not been received")
+	}
+
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Pause{Pause: &pubsubv1.PauseFrame{}},
+	}))
+	// frames are applied asynchronously
+	time.Sleep(100 * time.Millisecond)
+
+	for i := range 3 {
+		require.NoError(t, st.Publish(ctx, "paused", strconv.Itoa(i)))
+	}
+
+	received := msgReceive(stream)
+	select {
+	case msg := <-received:
+		t.Fatalf("Message %q has been received while paused", msg.data)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Resume{Resume: &pubsubv1.ResumeFrame{}},
+	}))
+	for i := range 3 {
+		select {
+		case msg := <-received:
+			require.NoError(t, msg.err)
+			assert.Equal(t, strconv.Itoa(i), msg.data)
+		case <-time.After(receiveTimeout):
+			t.Fatal("Message has not been received after resume")
+		}
+		received = msgReceive(stream)
+	}
+}
+
+func TestSubscribeStreamInvalidFrames(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.PubSub.SubscribeStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Pause{Pause: &pubsubv1.PauseFrame{}},
+	}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{Key: "twice"})
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Subscribe{Subscribe: &pubsubv1.SubscribeRequest{Key: "twice"}},
+	}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSlowConsumerEvicted(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	reports, err := st.Subscribe(ctx, subpub.SlowConsumerSubject)
+	require.NoError(t, err)
+
+	stream, err := st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{Key: "slow"})
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Pause{Pause: &pubsubv1.PauseFrame{}},
+	}))
+	time.Sleep(100 * time.Millisecond)
+
+	// paused for longer than max queue age of test config
+	require.NoError(t, st.Publish(ctx, "slow", "stale"))
+
+	select {
+	case msg := <-msgReceive(stream):
+		require.Error(t, msg.err)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(msg.err))
+		assert.Contains(t, msg.err.Error(), "slow consumer")
+	case <-time.After(receiveTimeout):
+		t.Fatal("Slow consumer has not been evicted")
+	}
+
+	select {
+	case msg := <-msgReceive(reports):
+		require.NoError(t, msg.err)
+		var report subpub.SlowConsumer
+		require.NoError(t, json.Unmarshal([]byte(msg.data), &report))
+		assert.Equal(t, "slow", report.Subject)
+		assert.True(t, report.Evicted)
+	case <-time.After(receiveTimeout):
+		t.Fatal("Slow consumer has not been reported")
+	}
+}
+
+func TestSysEvents(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	events, err := st.Subscribe(ctx, subpub.SysTopicCreated)
+	require.NoError(t, err)
+
+	_, err = st.Subscribe(ctx, "created")
+	require.NoError(t, err)
+
+	// events are structs, which are sent as JSON
+	select {
+	case msg := <-msgReceive(events):
+		require.NoError(t, msg.err)
+		var event subpub.SysEvent
+		require.NoError(t, json.Unmarshal([]byte(msg.data), &event))
+		assert.Equal(t, subpub.SysTopicCreated, event.Type)
+		assert.Equal(t, "created", event.Subject)
+	case <-time.After(receiveTimeout):
+		t.Fatal("Event has not been received")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	letters, err := st.Subscribe(ctx, "$dead")
+	require.NoError(t, err)
+
+	stream, err := st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{
+		Key:        "doomed",
+		AckWait:    durationpb.New(50 * time.Millisecond),
+		MaxDeliver: 1,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, st.Publish(ctx, "doomed", "never acked"))
+	select {
+	case msg := <-msgReceive(stream):
+		require.NoError(t, msg.err)
+	case <-time.After(receiveTimeout):
+		t.Fatal("Message has not been received")
+	}
+
+	// dead letter is sent as JSON of subpub.DeadLetter
+	select {
+	case msg := <-msgReceive(letters):
+		require.NoError(t, msg.err)
+		assert.Contains(t, msg.data, `"Reason":"not_acked"`)
+		var letter subpub.DeadLetter
+		require.NoError(t, json.Unmarshal([]byte(msg.data), &letter))
+		assert.Equal(t, "doomed", letter.Subject)
+		assert.Equal(t, "never acked", letter.Msg)
+		assert.Equal(t, subpub.DeliveryNotAcked, letter.Reason)
+	case <-time.After(receiveTimeout):
+		t.Fatal("Dead letter has not been received")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "traced")
+	require.NoError(t, err)
+
+	ctx, root := otel.Tracer("test").Start(ctx, "producer")
+	require.NoError(t, st.Publish(ctx, "traced", "test"))
+	root.End()
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	delivery := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(
+		context.Background(), propagation.MapCarrier(event.GetTraceContext()),
+	))
+	require.True(t, delivery.IsValid())
+	assert.Equal(t, root.SpanContext().TraceID(), delivery.TraceID())
+
+	// client call, server call, send and process
+	var names []string
+	var process trace.SpanID
+	require.Eventually(t, func() bool {
+		names = names[:0]
+		for _, span := range spans.GetSpans() {
+			if span.SpanContext.TraceID() == root.SpanContext().TraceID() && span.Name != "producer" {
+				names = append(names, span.Name)
+			}
+			if span.Name == "process traced" {
+				process = span.SpanContext.SpanID()
+			}
+		}
+		return len(names) == 4
+	}, receiveTimeout, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"PubSub/Publish", "PubSub/Publish", "send traced", "process traced"}, names)
+	assert.Equal(t, process, delivery.SpanID())
+}
+
+func TestSchemaFromConfig(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "orders.eu")
+	require.NoError(t, err)
+
+	err = st.Publish(ctx, "orders.eu", `{"id": 0}`)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		for _, violation := range detail.(*errdetails.BadRequest).GetFieldViolations() {
+			fields = append(fields, violation.GetField())
+		}
+	}
+	assert.ElementsMatch(t, []string{"data", "data/id"}, fields)
+
+	_, err = st.PublishAndWait(ctx, "orders.eu", "not json")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = st.PubSub.PublishTx(ctx, &pubsubv1.PublishTxRequest{Messages: []*pubsubv1.TxMessage{
+		{Key: "users", Data: "anything"},
+		{Key: "orders.eu", Data: "{}"},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// other keys are not checked
+	require.NoError(t, st.Publish(ctx, "orders", "not json"))
+
+	valid := `{"id": 1, "item": "book"}`
+	require.NoError(t, st.Publish(ctx, "orders.eu", valid))
+	select {
+	case msg := <-msgReceive(stream):
+		require.NoError(t, msg.err)
+		assert.Equal(t, valid, msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("valid message not delivered")
+	}
+}
+
+func TestSchemaAdmin(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	// descriptor set of Event with all dependencies
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range []protoreflect.FileDescriptor{
+		durationpb.File_google_protobuf_duration_proto,
+		timestamppb.File_google_protobuf_timestamp_proto,
+		pubsubv1.File_pubsub_pubsub_proto,
+	} {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	descriptorSet, err := proto.Marshal(set)
+	require.NoError(t, err)
+
+	_, err = st.Admin.RegisterSchema(ctx, &pubsubv1.RegisterSchemaRequest{Schema: &pubsubv1.Schema{
+		Pattern: "events.*",
+		Definition: &pubsubv1.Schema_Proto{Proto: &pubsubv1.ProtoSchema{
+			DescriptorSet: descriptorSet,
+			Message:       "Event",
+		}},
+	}})
+	require.NoError(t, err)
+
+	_, err = st.Admin.RegisterSchema(ctx, &pubsubv1.RegisterSchemaRequest{Schema: &pubsubv1.Schema{
+		Pattern:    "bad",
+		Definition: &pubsubv1.Schema_JsonSchema{JsonSchema: `{"type": 1}`},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := st.Admin.ListSchemas(ctx, &pubsubv1.ListSchemasRequest{})
+	require.NoError(t, err)
+	var patterns []string
+	for _, schema := range list.GetSchemas() {
+		patterns = append(patterns, schema.GetPattern())
+	}
+	assert.Equal(t, []string{"events.*", "orders.>"}, patterns)
+
+	require.NoError(t, st.Publish(ctx, "events.login", `{"data": "user"}`))
+	err = st.Publish(ctx, "events.login", `{"user": "name"}`)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.Admin.DeleteSchema(ctx, &pubsubv1.DeleteSchemaRequest{Pattern: "events.*"})
+	require.NoError(t, err)
+	_, err = st.Admin.DeleteSchema(ctx, &pubsubv1.DeleteSchemaRequest{Pattern: "events.*"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	require.NoError(t, st.Publish(ctx, "events.login", `{"user": "name"}`))
+}
+
+func TestTransforms(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	big, err := st.Subscribe(ctx, "big.eu")
+	require.NoError(t, err)
+	greetings, err := st.Subscribe(ctx, "greetings")
+	require.NoError(t, err)
+
+	// filtered out, but still published to source
+	require.NoError(t, st.Publish(ctx, "orders.eu", `{"id": 5, "item": "pen"}`))
+	require.NoError(t, st.Publish(ctx, "orders.eu", `{"id": 500, "item": "car", "price": 3}`))
+	require.NoError(t, st.Publish(ctx, "greet", "world"))
+
+	select {
+	case msg := <-msgReceive(big):
+		require.NoError(t, msg.err)
+		assert.JSONEq(t, `{"id": 500, "item": "car"}`, msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("projected message not delivered")
+	}
+	select {
+	case msg := <-msgReceive(greetings):
+		require.NoError(t, msg.err)
+		assert.Equal(t, "hello, world", msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("rewritten message not delivered")
+	}
+
+	assert.Eventually(t, func() bool {
+		resp, err := st.Admin.ListTransforms(ctx, &pubsubv1.ListTransformsRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetTransforms(), 4)
+
+		orders, greeting := resp.GetTransforms()[0], resp.GetTransforms()[1]
+		return orders.GetName() == "big-orders" &&
+			orders.GetReceived() == 2 && orders.GetFiltered() == 1 && orders.GetPublished() == 1 &&
+			greeting.GetReceived() == 1 && greeting.GetPublished() == 1
+	}, receiveTimeout, 10*time.Millisecond)
+}
+
+func TestTransformLoop(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.Subscribe(ctx, "loop.a")
+	require.NoError(t, err)
+
+	require.NoError(t, st.Publish(ctx, "loop.a", "ball"))
+
+	// published one and derived by pong, then loop is cut
+	for range 2 {
+		select {
+		case msg := <-msgReceive(stream):
+			require.NoError(t, msg.err)
+			assert.Equal(t, "ball", msg.data)
+		case <-time.After(receiveTimeout):
+			t.Fatal("message not delivered")
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		resp, err := st.Admin.ListTransforms(ctx, &pubsubv1.ListTransformsRequest{})
+		require.NoError(t, err)
+
+		ping, pong := resp.GetTransforms()[2], resp.GetTransforms()[3]
+		return ping.GetReceived() == 2 && ping.GetPublished() == 1 && ping.GetLoops() == 1 &&
+			pong.GetReceived() == 1 && pong.GetPublished() == 1
+	}, receiveTimeout, 10*time.Millisecond)
+
+	select {
+	case <-msgReceive(stream):
+		t.Fatal("loop is not cut")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubjectMapping(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	renamed, err := st.Subscribe(ctx, "renamed.x")
+	require.NoError(t, err)
+	mirrored, err := st.Subscribe(ctx, "copy.x")
+	require.NoError(t, err)
+
+	delivered, err := st.PublishAndWait(ctx, "legacy.x", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), delivered)
+
+	for _, stream := range []eventStream{renamed, mirrored} {
+		select {
+		case msg := <-msgReceive(stream):
+			require.NoError(t, msg.err)
+			assert.Equal(t, "hello", msg.data)
+		case <-time.After(receiveTimeout):
+			t.Fatal("message not delivered")
+		}
+	}
+
+	resp, err := st.Admin.GetSubjectMapping(ctx, &pubsubv1.GetSubjectMappingRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetMapping().GetMaps(), 1)
+	assert.Equal(t, "renamed.$1", resp.GetMapping().GetMaps()[0].GetDestinations()[0].GetKey())
+	require.Len(t, resp.GetMapping().GetMirrors(), 1)
+
+	_, err = st.Admin.SetSubjectMapping(ctx, &pubsubv1.SetSubjectMappingRequest{Mapping: &pubsubv1.SubjectMapping{
+		Maps: []*pubsubv1.MapRule{{Source: "legacy.*", Destinations: []*pubsubv1.WeightedKey{{Key: "renamed.$2"}}}},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// mapping is removed, so key is published as is
+	_, err = st.Admin.SetSubjectMapping(ctx, &pubsubv1.SetSubjectMappingRequest{})
+	require.NoError(t, err)
+	legacy, err := st.Subscribe(ctx, "legacy.x")
+	require.NoError(t, err)
+	delivered, err = st.PublishAndWait(ctx, "legacy.x", "again")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), delivered)
+
+	select {
+	case msg := <-msgReceive(legacy):
+		require.NoError(t, msg.err)
+		assert.Equal(t, "again", msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestSchemaAfterMapping(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	_, err := st.Admin.SetSubjectMapping(ctx, &pubsubv1.SetSubjectMappingRequest{Mapping: &pubsubv1.SubjectMapping{
+		Maps: []*pubsubv1.MapRule{
+			{Source: "orders.old", Destinations: []*pubsubv1.WeightedKey{{Key: "archive"}}},
+			{Source: "legacy.*", Destinations: []*pubsubv1.WeightedKey{{Key: "orders.$1"}}},
+		},
+		Mirrors: []*pubsubv1.MirrorRule{{Source: "shop", Destination: "orders.copy"}},
+	}})
+	require.NoError(t, err)
+
+	// schema of key data is mapped to
+	err = st.Publish(ctx, "legacy.eu", `{"id": 0}`)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = st.PublishAndWait(ctx, "legacy.eu", "not json")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = st.PubSub.PublishTx(ctx, &pubsubv1.PublishTxRequest{Messages: []*pubsubv1.TxMessage{
+		{Key: "legacy.eu", Data: "{}"},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// key data is mapped from is not checked
+	archive, err := st.Subscribe(ctx, "archive")
+	require.NoError(t, err)
+	require.NoError(t, st.Publish(ctx, "orders.old", "not json"))
+	select {
+	case msg := <-msgReceive(archive):
+		require.NoError(t, msg.err)
+		assert.Equal(t, "not json", msg.data)
+	case <-time.After(receiveTimeout):
+		t.Fatal("mapped message not delivered")
+	}
+
+	// copy is checked against schema of mirror, original is published
+	shop, err := st.Subscribe(ctx, "shop")
+	require.NoError(t, err)
+	copied, err := st.Subscribe(ctx, "orders.copy")
+	require.NoError(t, err)
+	require.NoError(t, st.Publish(ctx, "shop", "not json"))
+	valid := `{"id": 1, "item": "book"}`
+	require.NoError(t, st.Publish(ctx, "shop", valid))
+	for _, expected := range []string{"not json", valid} {
+		select {
+		case msg := <-msgReceive(shop):
+			require.NoError(t, msg.err)
+			assert.Equal(t, expected, msg.data)
+		case <-time.After(receiveTimeout):
+			t.Fatal("message not delivered")
+		}
+	}
+	select {
+	case msg := <-msgReceive(copied):
+		require.NoError(t, msg.err)
+		assert.Equal(t, valid, msg.data, "invalid copy is dropped")
+	case <-time.After(receiveTimeout):
+		t.Fatal("copy not delivered")
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	var streams []grpc.ServerStreamingClient[pubsubv1.Event]
+	for range 2 {
+		stream, err := st.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: "jobs", Group: "workers"})
+		require.NoError(t, err)
+		_, err = stream.Header()
+		require.NoError(t, err)
+		streams = append(streams, stream)
+	}
+
+	const messages = 20
+	for i := range messages {
+		_, err := st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+			Key:          "jobs",
+			Data:         strconv.Itoa(i),
+			PartitionKey: strconv.Itoa(i % 4),
+		})
+		require.NoError(t, err)
+	}
+
+	// every partition is read by one member in order of offsets
+	events := make(chan *pubsubv1.Event, messages)
+	for _, stream := range streams {
+		go func() {
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				events <- event
+			}
+		}()
+	}
+	next := map[uint32]int64{}
+	for range messages {
+		select {
+		case event := <-events:
+			assert.Equal(t, next[event.GetPartition()], event.GetOffset())
+			next[event.GetPartition()]++
+		case <-time.After(receiveTimeout):
+			t.Fatal("message not delivered")
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := st.Admin.ListGroups(ctx, &pubsubv1.ListGroupsRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetGroups(), 1)
+		group := resp.GetGroups()[0]
+		assert.Equal(t, "workers", group.GetGroup())
+		assert.Equal(t, uint32(2), group.GetMembers())
+
+		var committed int64
+		for _, p := range group.GetPartitions() {
+			committed += p.GetOffset()
+		}
+		return committed == messages
+	}, receiveTimeout, 10*time.Millisecond)
+
+	stream, err := st.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: "orders", Group: "workers"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestSubscribeStreamAck(t *testing.T) {
+	ctx, st := suite.New(t)
+	defer st.Close()
+
+	stream, err := st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{
+		Key:        "acked",
+		AckWait:    durationpb.New(100 * time.Millisecond),
+		MaxDeliver: 2,
+	})
+	require.NoError(t, err)
+
+	events := make(chan *pubsubv1.Event, 10)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+	receive := func() *pubsubv1.Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(receiveTimeout):
+			t.Fatal("Message has not been received")
+			return nil
+		}
+	}
+
+	require.NoError(t, st.Publish(ctx, "acked", "acked"))
+	event := receive()
+	assert.Equal(t, "acked", event.GetData())
+	assert.Equal(t, uint32(1), event.GetDelivery())
+	require.NoError(t, stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Ack{Ack: &pubsubv1.AckFrame{AckIds: []uint64{event.GetAckId()}}},
+	}))
+
+	// not acked message is redelivered up to max_deliver times
+	require.NoError(t, st.Publish(ctx, "acked", "ignored"))
+	for delivery := range 2 {
+		event := receive()
+		assert.Equal(t, "ignored", event.GetData())
+		assert.Equal(t, uint32(delivery+1), event.GetDelivery())
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("Message %q has been delivered after max_deliver", event.GetData())
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// ack_wait requires ack frames
+	plain, err := st.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: "acked", AckWait: durationpb.New(time.Second)})
+	require.NoError(t, err)
+	_, err = plain.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// ack frame without ack mode
+	unacked, err := st.SubscribeStream(ctx, &pubsubv1.SubscribeRequest{Key: "acked"})
+	require.NoError(t, err)
+	require.NoError(t, unacked.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Ack{Ack: &pubsubv1.AckFrame{AckIds: []uint64{1}}},
+	}))
+	_, err = unacked.Recv()
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// group takes one message and leaves, the rest are retained for it
+	subCtx, cancel := context.WithCancel(ctx)
+	stream, err := st.PubSub.Subscribe(subCtx, &pubsubv1.SubscribeRequest{Key: "jobs", Group: "workers"})
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+	_, err = st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{Key: "jobs", Data: "0", PartitionKey: "key"})
+	require.NoError(t, err)
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "0", event.GetData())
+	cancel()
+	require.Eventually(t, func() bool {
+		resp, err := st.Admin.ListGroups(ctx, &pubsubv1.ListGroupsRequest{})
+		return err == nil && len(resp.GetGroups()) == 1 && resp.GetGroups()[0].GetMembers() == 0
+	}, time.Second, 10*time.Millisecond)
+	for i := 1; i < 3; i++ {
+		_, err := st.PubSub.Publish(ctx, &pubsubv1.PublishRequest{Key: "jobs", Data: strconv.Itoa(i), PartitionKey: "key"})
+		require.NoError(t, err)
+	}
+
+	id, err := st.PublishAt(ctx, "later", "scheduled", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	path := t.TempDir() + "/snapshot.json"
+	addr := net.JoinHostPort("localhost", strconv.Itoa(st.Cfg.GRPC.Port))
+	var out bytes.Buffer
+	require.NoError(t, cli.Run([]string{"snapshot", "-addr", addr, path}, &out))
+	assert.Contains(t, out.String(), "saved to "+path)
+	st.Close()
+
+	ctx, st = suite.New(t)
+	defer st.Close()
+
+	// tampered snapshot is rejected
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	restore, err := st.Admin.Restore(ctx)
+	require.NoError(t, err)
+	require.NoError(t, restore.Send(&pubsubv1.SnapshotChunk{Data: bytes.Replace(data, []byte(`"scheduled"`), []byte(`"tampered!"`), 1)}))
+	_, err = restore.CloseAndRecv()
+	assert.Equal(t, codes.DataLoss, status.Code(err))
+
+	// snapshot over max size of test config is rejected
+	restore, err = st.Admin.Restore(ctx)
+	require.NoError(t, err)
+	for range 2 {
+		if err := restore.Send(&pubsubv1.SnapshotChunk{Data: make([]byte, 40000)}); err != nil {
+			break
+		}
+	}
+	_, err = restore.CloseAndRecv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	out.Reset()
+	require.NoError(t, cli.Run([]string{"restore", "-addr", addr, path}, &out))
+	assert.Equal(t, "restored 3 subjects, 2 messages, 1 groups, 1 scheduled messages\n", out.String())
+
+	// group continues where it stopped
+	stream, err = st.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: "jobs", Group: "workers"})
+	require.NoError(t, err)
+	for i := 1; i < 3; i++ {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), event.GetData())
+		assert.Equal(t, int64(i), event.GetOffset())
+	}
+
+	// IDs of restored scheduled messages are not reused
+	next, err := st.PublishAt(ctx, "later", "next", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Greater(t, next, id)
+
+	// snapshot can't be loaded twice
+	err = cli.Run([]string{"restore", "-addr", addr, path}, &out)
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
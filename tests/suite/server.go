@@ -11,7 +11,7 @@ import (
 func StartServer(cfg *config.Config) func() {
 	logger := slog.New(slogdiscard.NewDiscardHandler())
 
-	application := app.New(logger, cfg.GRPC.Port, cfg.GRPC.Timeout)
+	application := app.New(logger, cfg.GRPC.Port, cfg.GRPC.Timeout, cfg.GRPC.MaxSnapshotSize, cfg.SubPub)
 
 	go func() {
 		application.GRPCServer.MustRun()
@@ -6,23 +6,61 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/Kry0z1/subpub/internal/config"
 	pubsubv1 "github.com/Kry0z1/subpub/protos/gen/go/pubsub"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Suite struct {
 	*testing.T
 	PubSub     pubsubv1.PubSubClient
+	Admin      pubsubv1.AdminClient
 	Cfg        *config.Config
 	serverStop func()
 }
 
+// Subscribe opens stream and waits for server to confirm subscription,
+// so that messages published after it returns are not missed.
 func (s *Suite) Subscribe(ctx context.Context, key string) (grpc.ServerStreamingClient[pubsubv1.Event], error) {
-	return s.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: key})
+	stream, err := s.PubSub.Subscribe(ctx, &pubsubv1.SubscribeRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := stream.Header(); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// SubscribeStream opens stream with control frames, sends subscribe
+// frame and waits for server to confirm subscription.
+func (s *Suite) SubscribeStream(ctx context.Context, request *pubsubv1.SubscribeRequest) (grpc.BidiStreamingClient[pubsubv1.SubscribeFrame, pubsubv1.Event], error) {
+	stream, err := s.PubSub.SubscribeStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&pubsubv1.SubscribeFrame{
+		Frame: &pubsubv1.SubscribeFrame_Subscribe{Subscribe: request},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := stream.Header(); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
 }
 
 func (s *Suite) Publish(ctx context.Context, key string, data string) error {
@@ -34,6 +72,26 @@ func (s *Suite) Publish(ctx context.Context, key string, data string) error {
 	return err
 }
 
+func (s *Suite) PublishAndWait(ctx context.Context, key string, data string) (uint32, error) {
+	resp, err := s.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+		Key:             key,
+		Data:            data,
+		WaitForDelivery: true,
+	})
+
+	return resp.GetDelivered(), err
+}
+
+func (s *Suite) PublishAt(ctx context.Context, key string, data string, at time.Time) (uint64, error) {
+	resp, err := s.PubSub.Publish(ctx, &pubsubv1.PublishRequest{
+		Key:       key,
+		Data:      data,
+		DeliverAt: timestamppb.New(at),
+	})
+
+	return resp.GetScheduledId(), err
+}
+
 func (s *Suite) Close() {
 	s.serverStop()
 }
@@ -46,7 +104,15 @@ func New(t *testing.T) (context.Context, Suite) {
 	serverStop := StartServer(cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.GRPC.Timeout)
-	cc, err := grpc.DialContext(ctx, grpcAddress(cfg), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// server is started asynchronously, so wait for it to listen
+	cc, err := grpc.DialContext(ctx, grpcAddress(cfg),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		// propagates trace context of ctx passed to calls
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithPropagators(propagation.TraceContext{}),
+		)),
+	)
 	if err != nil {
 		t.Fatalf("failed to connect to grpc server: %v", err)
 	}
@@ -59,6 +125,7 @@ func New(t *testing.T) (context.Context, Suite) {
 	return ctx, Suite{
 		T:          t,
 		PubSub:     pubsubv1.NewPubSubClient(cc),
+		Admin:      pubsubv1.NewAdminClient(cc),
 		Cfg:        cfg,
 		serverStop: serverStop,
 	}